@@ -2,105 +2,627 @@ package checker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"NDClasses/clients/database"
 	"NDClasses/clients/logger"
+	"NDClasses/clients/metrics"
 	"NDClasses/clients/ndparser"
 	"NDClasses/clients/telegram"
 )
 
+var (
+	cycleDuration = metrics.Default().NewHistogram(
+		"ndclasses_checker_cycle_duration_seconds",
+		"Time taken to check all tracked sections once.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200},
+	)
+	lastCycle = metrics.Default().NewGauge(
+		"ndclasses_checker_last_cycle_timestamp_seconds",
+		"Unix time the last complete check of all tracked sections finished.",
+	)
+	trackedCRNs = metrics.Default().NewGauge(
+		"ndclasses_tracked_crns",
+		"Active tracked CRNs, counting a section once per user tracking it.",
+	)
+	trackedSections = metrics.Default().NewGauge(
+		"ndclasses_tracked_sections",
+		"Distinct sections being tracked.",
+	)
+	activeUsers = metrics.Default().NewGauge(
+		"ndclasses_active_users",
+		"Users tracking at least one CRN.",
+	)
+)
+
 // Checker periodically checks class availability for all tracked CRNs
 type Checker struct {
-	db     *database.Database
-	parser ndparser.Parser
-	client telegram.Client
-	logger *logger.Logger
+	db            database.Store
+	parser        ndparser.ClassSource
+	client        telegram.Client
+	logger        *logger.Logger
+	notifyOnClose bool
+	workers       int
+
+	// Observations older than retention are pruned at most once per pruneInterval; 0 keeps them forever
+	retention time.Duration
+	lastPrune atomic.Int64
+
+	// pending counts the sections of the current cycle that haven't been checked yet
+	pending atomic.Int64
+
+	// lastSuccess is the Unix time, in nanoseconds, the last complete cycle finished, and lastDuration how long it took
+	lastSuccess  atomic.Int64
+	lastDuration atomic.Int64
+
+	// Scheduled cycles are skipped while paused; pauseChanged is the Unix time, in nanoseconds, of the last Pause or Resume
+	paused       atomic.Bool
+	pauseChanged atomic.Int64
+	wake         chan struct{}
+
+	// cycleMu keeps cycles from overlapping when CheckNow is called while Run is checking
+	cycleMu sync.Mutex
+
+	// stop, abort and done control a running Run loop.
+	// CheckNow follows stopping and aborting, so that Stop also stops forced checks, and checks counts those running.
+	mu          sync.Mutex
+	stop        context.CancelFunc
+	abort       context.CancelFunc
+	done        chan struct{}
+	stopping    context.Context
+	aborting    context.Context
+	abortChecks context.CancelFunc
+	checks      sync.WaitGroup
 }
 
-// New creates a new checker
-func New(db *database.Database, client telegram.Client, logger *logger.Logger) *Checker {
+// ErrStopped is returned by CheckNow when the checker stops before or during the check
+var ErrStopped = errors.New("checker stopped")
+
+// checkInterval is the pause between two checks of all tracked CRNs
+const checkInterval = 3 * time.Minute
+
+// defaultWorkers is the number of sections checked at the same time
+const defaultWorkers = 4
+
+// defaultRetentionDays is how long the history of section observations is kept
+const defaultRetentionDays = 90
+
+// pruneInterval is how often old observations are deleted
+const pruneInterval = 24 * time.Hour
+
+// New creates a new checker.
+// Setting NOTIFY_ON_CLOSE=true also alerts users when a section they were told about fills up again,
+// CHECKER_WORKERS caps how many sections are checked at the same time,
+// and HISTORY_RETENTION_DAYS sets how long observations are kept (0 keeps them forever).
+func New(db database.Store, client telegram.Client, parser ndparser.ClassSource, logger *logger.Logger) *Checker {
+	notifyOnClose, _ := strconv.ParseBool(os.Getenv("NOTIFY_ON_CLOSE"))
+
+	workers, err := strconv.Atoi(os.Getenv("CHECKER_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = defaultWorkers
+	}
+
+	retentionDays, err := strconv.Atoi(os.Getenv("HISTORY_RETENTION_DAYS"))
+	if err != nil || retentionDays < 0 {
+		retentionDays = defaultRetentionDays
+	}
+
 	return &Checker{
-		db:     db,
-		parser: ndparser.New(logger),
-		client: client,
-		logger: logger,
+		db:            db,
+		parser:        parser,
+		client:        client,
+		logger:        logger,
+		notifyOnClose: notifyOnClose,
+		workers:       workers,
+		retention:     time.Duration(retentionDays) * 24 * time.Hour,
+		wake:          make(chan struct{}, 1),
 	}
 }
 
-// Start begins the periodic checking process
-func (c *Checker) Start() {
-	go func() {
-		for {
-			// Check all tracked CRNs
-			if err := c.checkAllTrackedCRNs(); err != nil {
-				log.Printf("Error checking tracked CRNs: %v", err)
-			}
+// QueueDepth returns how many sections of the current cycle are still waiting to be checked
+func (c *Checker) QueueDepth() int {
+	return int(c.pending.Load())
+}
+
+// LastCycle returns when the last complete check of all tracked CRNs finished, or the zero time if none has
+func (c *Checker) LastCycle() time.Time {
+	last := c.lastSuccess.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// LastCycleDuration returns how long the last complete check of all tracked CRNs took, or 0 if none has finished
+func (c *Checker) LastCycleDuration() time.Duration {
+	return time.Duration(c.lastDuration.Load())
+}
+
+// Pause stops Run from starting new cycles until Resume is called; a cycle already running finishes.
+// It returns false if the checker was already paused.
+func (c *Checker) Pause() bool {
+	if !c.paused.CompareAndSwap(false, true) {
+		return false
+	}
+	c.pauseChanged.Store(time.Now().UnixNano())
+	c.logger.Info("Checker paused")
+	return true
+}
+
+// Resume lets Run check again, starting a cycle right away. It returns false if the checker wasn't paused.
+func (c *Checker) Resume() bool {
+	if !c.paused.CompareAndSwap(true, false) {
+		return false
+	}
+	c.pauseChanged.Store(time.Now().UnixNano())
+	c.logger.Info("Checker resumed")
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Paused reports whether the checker is paused, and when it was last paused or resumed (the zero time if never)
+func (c *Checker) Paused() (bool, time.Time) {
+	changed := c.pauseChanged.Load()
+	if changed == 0 {
+		return c.paused.Load(), time.Time{}
+	}
+	return c.paused.Load(), time.Unix(0, changed)
+}
+
+// Run checks all tracked CRNs every checkInterval until ctx is cancelled or Stop is called, skipping cycles while paused.
+// Once stopped, the running cycle doesn't start new sections but lets searches in flight finish.
+func (c *Checker) Run(ctx context.Context) error {
+	return c.start(ctx)()
+}
+
+// Start runs the checker in the background until ctx is done or Stop is called.
+// Unlike go Run, the loop is registered before Start returns, so a Stop right after it still waits for the loop.
+func (c *Checker) Start(ctx context.Context) {
+	go c.start(ctx)()
+}
+
+// start registers a Run loop with Stop and returns the loop, ready to be run
+func (c *Checker) start(ctx context.Context) func() error {
+	runCtx, stop := context.WithCancel(ctx)
+
+	// Searches get their own context, so that stopping doesn't kill them halfway
+	workCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+
+	done := make(chan struct{})
 
-			// Wait for 3 minutes before checking again
-			time.Sleep(3 * time.Minute)
+	// Forced checks are aborted along with the loop's searches, but not merely because the loop returned
+	aborting, abortChecks := context.WithCancel(context.Background())
+
+	c.mu.Lock()
+	c.stop, c.abort, c.done = stop, abort, done
+	c.stopping, c.aborting, c.abortChecks = runCtx, aborting, abortChecks
+	c.mu.Unlock()
+
+	return func() error {
+		defer close(done)
+		defer abort()
+		defer stop()
+		return c.loop(runCtx, workCtx)
+	}
+}
+
+// loop checks all tracked CRNs every checkInterval until runCtx is done
+func (c *Checker) loop(runCtx context.Context, workCtx context.Context) error {
+	// Stopped before the loop got going
+	if runCtx.Err() != nil {
+		return nil
+	}
+
+	for {
+		// Check all tracked CRNs
+		if c.paused.Load() {
+			c.logger.Debug("Checker is paused, skipping check")
+		} else if err := c.check(runCtx, workCtx); err != nil {
+			c.logger.Error("Error checking tracked CRNs: %v", err)
+		}
+
+		// Wait before checking again, or until resumed
+		select {
+		case <-runCtx.Done():
+			return nil
+		case <-time.After(checkInterval):
+		case <-c.wake:
 		}
+	}
+}
+
+// Stop asks Run and any CheckNow to return and waits for the running cycles to finish.
+// If ctx is done first, searches still in flight are cancelled and ctx's error is returned.
+func (c *Checker) Stop(ctx context.Context) error {
+	// Stopping under mu keeps CheckNow from counting a new check once Stop waits for them
+	c.mu.Lock()
+	stop, abort, abortChecks, done := c.stop, c.abort, c.abortChecks, c.done
+	if done != nil {
+		stop()
+	}
+	c.mu.Unlock()
+
+	if done == nil {
+		return nil // Never started
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		<-done
+		c.checks.Wait()
+		close(finished)
 	}()
+
+	defer abortChecks()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		abort()
+		abortChecks()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// sectionKey identifies a section within a term
+type sectionKey struct {
+	term string
+	crn  string
 }
 
-// checkAllTrackedCRNs checks availability for all tracked CRNs
-func (c *Checker) checkAllTrackedCRNs() error {
+// CheckNow checks availability for all tracked CRNs once.
+// Each distinct section is fetched once and the result is shared by all of its watchers.
+// It runs even while the checker is paused, and waits for a cycle already running to finish first.
+// Once the checker is started, Stop cuts it short like a scheduled cycle and ErrStopped is returned.
+func (c *Checker) CheckNow(ctx context.Context) error {
+	c.mu.Lock()
+	started, stopping, aborting := c.done != nil, c.stopping, c.aborting
+	if started && stopping.Err() != nil {
+		c.mu.Unlock()
+		return ErrStopped
+	}
+	if started {
+		c.checks.Add(1)
+		defer c.checks.Done()
+	}
+	c.mu.Unlock()
+
+	if !started {
+		return c.check(ctx, ctx)
+	}
+
+	// Follow the Run loop's contexts as well as ctx
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	defer context.AfterFunc(stopping, func() { cancelRun(ErrStopped) })()
+
+	workCtx, cancelWork := context.WithCancel(ctx)
+	defer cancelWork()
+	defer context.AfterFunc(aborting, cancelWork)()
+
+	if err := c.check(runCtx, workCtx); err != nil {
+		return err
+	}
+	if runCtx.Err() != nil {
+		return context.Cause(runCtx)
+	}
+	return nil
+}
+
+// check runs one cycle. Sections are picked up until runCtx is done, and searched under workCtx.
+func (c *Checker) check(runCtx context.Context, workCtx context.Context) error {
+	c.cycleMu.Lock()
+	defer c.cycleMu.Unlock()
+
+	c.pruneHistory()
+
 	// Get all tracked CRNs
-	trackedCRNs, err := c.db.GetAllTrackedCRNs()
+	tracked, err := c.db.GetAllTrackedCRNs()
 	if err != nil {
 		return fmt.Errorf("failed to get tracked CRNs: %w", err)
 	}
 
-	// Keep track of users we've already notified to avoid duplicate messages
-	notifiedUsers := make(map[int64]bool)
-	var mu sync.Mutex
+	// Rows tracked before terms were stored have an empty term, meaning the current one.
+	// Spelling it out puts them in the same group as rows that name the current term;
+	// when the current term can't be told, they're left for the next cycle.
+	current := ""
+	if term, err := c.parser.CurrentTerm(workCtx); err != nil {
+		c.logger.Warn("Skipping classes tracked without a term this cycle, can't resolve current term: %v", err)
+	} else {
+		current = term.Code
+	}
+
+	// Group the rows by section so popular sections aren't fetched once per student
+	watchers := make(map[sectionKey][]database.TrackedCRN)
+	users := make(map[int64]bool)
+	for _, crn := range tracked {
+		users[crn.UserID] = true
+
+		key := sectionKey{term: crn.Term, crn: crn.CRN}
+		if key.term == "" {
+			if current == "" {
+				continue
+			}
+			key.term = current
+		}
+		watchers[key] = append(watchers[key], crn)
+	}
+	trackedCRNs.Set(float64(len(tracked)))
+	trackedSections.Set(float64(len(watchers)))
+	activeUsers.Set(float64(len(users)))
+
+	// Queue every section and let a fixed number of workers check them
+	jobs := make(chan sectionKey, len(watchers))
+	for key := range watchers {
+		jobs <- key
+	}
+	close(jobs)
+	c.pending.Store(int64(len(watchers)))
+	c.logger.With("sections", len(watchers), "workers", c.workers).Debug("Checking tracked sections")
+	start := time.Now()
 
-	// Check each CRN
 	wg := sync.WaitGroup{}
-	for _, crn := range trackedCRNs {
+	for i := 0; i < c.workers; i++ {
 		wg.Add(1)
-		// Check class availability
-		go func(crn database.TrackedCRN) {
+		go func() {
 			defer wg.Done()
-			class, err := c.parser.SearchClass(context.Background(), crn.CRN)
-			if err != nil {
-				log.Printf("Error checking class %s: %v", crn.CRN, err)
-				return
+			for key := range jobs {
+				// Skip what's left of the queue once stopped
+				if runCtx.Err() == nil {
+					c.checkSection(workCtx, key, watchers[key])
+				}
+				c.pending.Add(-1)
 			}
+		}()
+	}
 
-			// If seats are available and we haven't notified this user yet
-			mu.Lock()
-			alreadyNotified := notifiedUsers[crn.UserID]
-			mu.Unlock()
-
-			if class.Seats > 0 && !alreadyNotified {
-				// Get user by ID
-				user, err := c.db.GetUserByID(crn.UserID)
-				if err != nil {
-					log.Printf("Error getting user %d: %v", crn.UserID, err)
-					return
-				}
+	// Wait for all workers to complete
+	wg.Wait()
+	c.logger.With("sections", len(watchers), "duration", time.Since(start)).Debug("Finished checking tracked sections")
 
-				// Send notification
-				message := fmt.Sprintf("Good news! Class %s (%s) now has %d seat(s) available.",
-					crn.CRN, crn.Title, class.Seats)
-				if err := c.client.SendMessage(user.TelegramID, message); err != nil {
-					log.Printf("Error sending message to user %d: %v", user.TelegramID, err)
-				}
+	// Cycles cut short by a shutdown didn't check everything and aren't counted
+	if runCtx.Err() == nil {
+		now := time.Now()
+		c.lastSuccess.Store(now.UnixNano())
+		c.lastDuration.Store(int64(now.Sub(start)))
+		cycleDuration.Observe(now.Sub(start).Seconds())
+		lastCycle.Set(float64(now.Unix()))
+	}
 
-				// Mark user as notified
-				mu.Lock()
-				notifiedUsers[crn.UserID] = true
-				mu.Unlock()
-			}
-		}(crn)
+	return nil
+}
+
+// checkSection fetches a section, records its seat state and alerts its watchers about transitions
+func (c *Checker) checkSection(ctx context.Context, key sectionKey, rows []database.TrackedCRN) {
+	log := c.logger.With("crn", key.crn, "term", key.term)
+
+	class, err := c.parser.SearchClass(ctx, key.term, key.crn)
+	c.recordObservation(ctx, key, class, err)
+	if err != nil {
+		log.Warn("Error checking class: %v", err)
+		return
+	}
+
+	state, err := c.updateState(key, class.Seats)
+	if err != nil {
+		log.Error("Error saving state of class: %v", err)
+		return
+	}
+
+	// Full sections only send alerts with NOTIFY_ON_CLOSE
+	if !state.Open && !c.notifyOnClose {
+		return
 	}
 
-	// Wait for all goroutines to complete
+	// Closing alerts go to users who heard about the opening, so look back to it
+	since := state.ChangedAt
+	if state.OpenedAt > 0 {
+		since = min(since, state.OpenedAt)
+	}
+	delivered, err := c.deliveredAlerts(key, since)
+	if err != nil {
+		log.Error("Error checking notifications: %v", err)
+		return
+	}
+
+	// Alerts go out concurrently; the Telegram outbox keeps them within the rate limits
+	var wg sync.WaitGroup
+	for _, crn := range watchers(rows) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.notifyWatcher(ctx, state, crn, class, delivered)
+		}()
+	}
 	wg.Wait()
+}
 
-	return nil
+// watchers picks one row per user watching a section, since a user can track it twice,
+// e.g. once from before terms were stored. A snooze on any of the user's rows holds back the alert.
+func watchers(rows []database.TrackedCRN) []database.TrackedCRN {
+	var picked []database.TrackedCRN
+	index := make(map[int64]int, len(rows))
+	for _, crn := range rows {
+		i, ok := index[crn.UserID]
+		if !ok {
+			index[crn.UserID] = len(picked)
+			picked = append(picked, crn)
+			continue
+		}
+		picked[i].SnoozedUntil = max(picked[i].SnoozedUntil, crn.SnoozedUntil)
+	}
+	return picked
+}
+
+// alertKey identifies an alert to one user about one transition of a section
+type alertKey struct {
+	userID    int64
+	kind      string
+	changedAt int64
+}
+
+// deliveredAlerts loads the alerts already delivered for a section's transitions since the given time, in one query
+func (c *Checker) deliveredAlerts(key sectionKey, since int64) (map[alertKey]bool, error) {
+	notifications, err := c.db.GetDeliveredNotifications(key.term, key.crn, since)
+	if err != nil {
+		return nil, err
+	}
+
+	delivered := make(map[alertKey]bool, len(notifications))
+	for _, n := range notifications {
+		delivered[alertKey{userID: n.UserID, kind: n.Kind, changedAt: n.ChangedAt}] = true
+	}
+	return delivered, nil
+}
+
+// recordObservation adds the outcome of a fetch to the section's history.
+// Searches cut short by a shutdown say nothing about the section and aren't recorded.
+func (c *Checker) recordObservation(ctx context.Context, key sectionKey, class *ndparser.Class, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	term := key.term
+	if err == nil && class.Term != "" {
+		term = class.Term
+	}
+	observation := &database.SectionObservation{
+		Term:       term,
+		CRN:        key.crn,
+		Success:    err == nil,
+		ObservedAt: time.Now().Unix(),
+	}
+	if err != nil {
+		observation.Error = err.Error()
+	} else {
+		observation.Seats = class.Seats
+		observation.Enrollment = class.Enrollment
+		observation.Capacity = class.Capacity
+		observation.WaitlistCapacity = class.WaitlistCapacity
+		observation.WaitlistCount = class.WaitlistCount
+	}
+
+	if err := c.db.RecordObservation(observation); err != nil {
+		c.logger.With("crn", key.crn, "term", key.term).Error("Error recording observation of class: %v", err)
+	}
+}
+
+// pruneHistory deletes observations older than the retention period, at most once per pruneInterval
+func (c *Checker) pruneHistory() {
+	if c.retention == 0 {
+		return
+	}
+
+	now := time.Now()
+	last := c.lastPrune.Load()
+	if now.Sub(time.Unix(last, 0)) < pruneInterval || !c.lastPrune.CompareAndSwap(last, now.Unix()) {
+		return
+	}
+
+	deleted, err := c.db.PruneObservations(now.Add(-c.retention).Unix())
+	if err != nil {
+		c.logger.Error("Error pruning section history: %v", err)
+		return
+	}
+	c.logger.With("deleted", deleted).Debug("Pruned old section observations")
+}
+
+// updateState stores the observed seat count and moves the transition timestamps when the section opens or closes
+func (c *Checker) updateState(key sectionKey, seats int) (*database.SectionState, error) {
+	now := time.Now().Unix()
+
+	state, err := c.db.GetSectionState(key.term, key.crn)
+	if errors.Is(err, database.ErrNotFound) {
+		state = &database.SectionState{Term: key.term, CRN: key.crn}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// A section seen for the first time counts as a transition, so open seats aren't missed
+	open := seats > 0
+	if state.ID == 0 || state.Open != open {
+		// Transition timestamps identify alerts, so they must never repeat
+		if now <= state.ChangedAt {
+			now = state.ChangedAt + 1
+		}
+		state.ChangedAt = now
+		if open {
+			state.OpenedAt = now
+		}
+	}
+
+	state.Open = open
+	state.Seats = seats
+	state.CheckedAt = now
+
+	if err := c.db.SaveSectionState(state); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// notifyWatcher sends the alert for the section's latest transition unless the user already has it.
+// delivered holds the alerts already delivered for the section's recent transitions.
+func (c *Checker) notifyWatcher(ctx context.Context, state *database.SectionState, crn database.TrackedCRN, class *ndparser.Class, delivered map[alertKey]bool) {
+	kind := database.NotificationOpened
+	message := fmt.Sprintf("Good news! Class %s (%s) now has %d seat(s) available.",
+		crn.CRN, crn.Title, class.Seats)
+
+	if !state.Open {
+		if !c.notifyOnClose {
+			return
+		}
+
+		// Only users who heard about the opening need to know it's gone
+		if !delivered[alertKey{userID: crn.UserID, kind: database.NotificationOpened, changedAt: state.OpenedAt}] {
+			return
+		}
+
+		kind = database.NotificationClosed
+		message = fmt.Sprintf("Class %s (%s) is full again.", crn.CRN, crn.Title)
+	}
+
+	// Snoozed alerts aren't recorded, so they still go out if the section stays that way past the snooze
+	if crn.SnoozedUntil > time.Now().Unix() {
+		return
+	}
+
+	if delivered[alertKey{userID: crn.UserID, kind: kind, changedAt: state.ChangedAt}] {
+		return
+	}
+
+	log := c.logger.With("user_id", crn.UserID, "crn", state.CRN, "term", state.Term)
+
+	// Get user by ID
+	user, err := c.db.GetUserByID(crn.UserID)
+	if err != nil {
+		log.Error("Error getting user: %v", err)
+		return
+	}
+
+	// Send notification and record the outcome, so failed alerts are retried next cycle
+	log = log.With("chat_id", user.TelegramID)
+	_, err = c.client.SendMessageWithKeyboard(ctx, user.TelegramID, message, telegram.AlertKeyboard(crn.ID))
+	if err != nil {
+		log.Error("Error sending %s alert: %v", kind, err)
+	} else {
+		log.Info("Sent %s alert", kind)
+	}
+
+	if err := c.db.RecordNotification(crn.UserID, state.Term, state.CRN, kind, state.ChangedAt, err == nil); err != nil {
+		log.Error("Error recording notification: %v", err)
+	}
 }
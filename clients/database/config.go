@@ -0,0 +1,257 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"NDClasses/clients/logger"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// Supported database drivers
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory" // Nothing is saved; see NewStore
+)
+
+// defaultSQLitePath is the database file used when DB_DRIVER=sqlite names no file
+const defaultSQLitePath = "ndclasses.db"
+
+// Connection pool and startup defaults
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultConnectTimeout  = 30 * time.Second
+)
+
+// sslModes are the sslmode values Postgres accepts
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Config selects the database driver and how to reach the database.
+// Zero pool settings leave database/sql's defaults in place.
+type Config struct {
+	Driver string // DriverPostgres or DriverSQLite
+	DSN    string // Postgres connection string, or the SQLite file (":memory:" for a throwaway database)
+
+	MaxOpenConns    int           // Connections open at once, 0 for no limit
+	MaxIdleConns    int           // Connections kept open while idle
+	ConnMaxLifetime time.Duration // Connections are replaced after this long, 0 keeps them
+	ConnMaxIdleTime time.Duration // Idle connections are closed after this long, 0 keeps them
+
+	ConnectTimeout time.Duration // How long to keep retrying while the database isn't up yet, 0 tries once
+}
+
+// ConfigFromEnv reads the database settings from environment variables.
+// DATABASE_URL takes precedence: postgres://... or sqlite:path/to/file.db.
+// Otherwise DB_DRIVER picks the driver (postgres by default); Postgres is configured by
+// DB_HOST, DB_PORT, DB_USER, DB_PASSWORD, DB_NAME, DB_SSLMODE and DB_SSLROOTCERT, SQLite by DB_PATH.
+// DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME, DB_CONN_MAX_IDLE_TIME and
+// DB_CONNECT_TIMEOUT apply to both drivers.
+func ConfigFromEnv() (Config, error) {
+	config, err := connectionFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	if config.MaxOpenConns, err = intFromEnv("DB_MAX_OPEN_CONNS", defaultMaxOpenConns); err != nil {
+		return Config{}, err
+	}
+	if config.MaxIdleConns, err = intFromEnv("DB_MAX_IDLE_CONNS", defaultMaxIdleConns); err != nil {
+		return Config{}, err
+	}
+	if config.ConnMaxLifetime, err = durationFromEnv("DB_CONN_MAX_LIFETIME", defaultConnMaxLifetime); err != nil {
+		return Config{}, err
+	}
+	if config.ConnMaxIdleTime, err = durationFromEnv("DB_CONN_MAX_IDLE_TIME", defaultConnMaxIdleTime); err != nil {
+		return Config{}, err
+	}
+	if config.ConnectTimeout, err = durationFromEnv("DB_CONNECT_TIMEOUT", defaultConnectTimeout); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// connectionFromEnv reads the driver and the connection string
+func connectionFromEnv() (Config, error) {
+	if rawURL := os.Getenv("DATABASE_URL"); rawURL != "" {
+		return parseDatabaseURL(rawURL)
+	}
+
+	driver := strings.ToLower(os.Getenv("DB_DRIVER"))
+	switch driver {
+	case "", DriverPostgres, "postgresql":
+		return postgresConfigFromEnv()
+	case DriverSQLite, "sqlite3":
+		path := os.Getenv("DB_PATH")
+		if path == "" {
+			path = defaultSQLitePath
+		}
+		return Config{Driver: DriverSQLite, DSN: path}, nil
+	case DriverMemory:
+		return Config{Driver: DriverMemory}, nil
+	default:
+		return Config{}, fmt.Errorf("unknown DB_DRIVER %q, use %q, %q or %q", driver, DriverPostgres, DriverSQLite, DriverMemory)
+	}
+}
+
+// intFromEnv reads a non-negative number, or returns def if the variable isn't set
+func intFromEnv(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, got %q", key, value)
+	}
+	return n, nil
+}
+
+// durationFromEnv reads a duration like "30s" or "5m", or returns def if the variable isn't set
+func durationFromEnv(key string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a duration like 30s or 5m, got %q", key, value)
+	}
+	return d, nil
+}
+
+// parseDatabaseURL picks the driver from the scheme of DATABASE_URL
+func parseDatabaseURL(rawURL string) (Config, error) {
+	scheme, rest, ok := strings.Cut(rawURL, ":")
+	if !ok {
+		return Config{}, fmt.Errorf("DATABASE_URL must start with postgres://, sqlite: or memory:")
+	}
+
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		// Keep the password out of logs, including errors that quote the URL
+		if u, err := url.Parse(rawURL); err == nil {
+			if password, ok := u.User.Password(); ok {
+				logger.AddSecret(password)
+			}
+		}
+		return Config{Driver: DriverPostgres, DSN: rawURL}, nil
+	case "sqlite", "sqlite3":
+		// sqlite:///abs/path.db and sqlite://rel/path.db are accepted as well as sqlite:path.db
+		path := strings.TrimPrefix(rest, "//")
+		if path == "" {
+			return Config{}, fmt.Errorf("DATABASE_URL names no SQLite file")
+		}
+		return Config{Driver: DriverSQLite, DSN: path}, nil
+	case DriverMemory:
+		return Config{Driver: DriverMemory}, nil
+	default:
+		return Config{}, fmt.Errorf("unsupported DATABASE_URL scheme %q, use postgres://, sqlite: or memory:", scheme)
+	}
+}
+
+// postgresConfigFromEnv builds a Postgres connection string from the DB_* variables
+func postgresConfigFromEnv() (Config, error) {
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+
+	port := os.Getenv("DB_PORT")
+	if port == "" {
+		port = "5432"
+	}
+
+	user := os.Getenv("DB_USER")
+	if user == "" {
+		return Config{}, fmt.Errorf("DB_USER not set in environment variables")
+	}
+
+	password := os.Getenv("DB_PASSWORD")
+	if password == "" {
+		return Config{}, fmt.Errorf("DB_PASSWORD not set in environment variables")
+	}
+	logger.AddSecret(password)
+
+	dbname := os.Getenv("DB_NAME")
+	if dbname == "" {
+		return Config{}, fmt.Errorf("DB_NAME not set in environment variables")
+	}
+
+	// Connections stay unencrypted unless asked otherwise, as they always have
+	sslMode := os.Getenv("DB_SSLMODE")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	if !slices.Contains(sslModes, sslMode) {
+		return Config{}, fmt.Errorf("unknown DB_SSLMODE %q, use one of %s", sslMode, strings.Join(sslModes, ", "))
+	}
+
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=%s TimeZone=UTC",
+		dsnValue(host), dsnValue(user), dsnValue(password), dsnValue(dbname), dsnValue(port), sslMode)
+
+	// The root certificate verifies the server with sslmode=verify-ca or verify-full
+	if rootCert := os.Getenv("DB_SSLROOTCERT"); rootCert != "" {
+		dsn += " sslrootcert=" + dsnValue(rootCert)
+	}
+
+	return Config{Driver: DriverPostgres, DSN: dsn}, nil
+}
+
+// dsnValue quotes a value for a keyword/value connection string if it needs it
+func dsnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, ` '\`) {
+		return value
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// dialector returns the GORM dialector for the configured driver
+func (c Config) dialector() (gorm.Dialector, error) {
+	switch c.Driver {
+	case DriverPostgres:
+		return postgres.Open(c.DSN), nil
+	case DriverSQLite:
+		return sqlite.Open(sqliteDSN(c.DSN)), nil
+	case DriverMemory:
+		return nil, fmt.Errorf("the memory driver has no SQL database")
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", c.Driver)
+	}
+}
+
+// inMemory reports whether the config names a throwaway SQLite database
+func (c Config) inMemory() bool {
+	return c.Driver == DriverSQLite && (c.DSN == ":memory:" || strings.Contains(c.DSN, "mode=memory"))
+}
+
+// sqliteDSN adds the connection settings the bot relies on to a SQLite file name:
+// foreign keys, and waiting on locks held by other connections instead of failing at once.
+// File databases also use write-ahead logging, so the checker's writes don't block reads.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	if path != ":memory:" && !strings.Contains(path, "mode=memory") {
+		params.Add("_pragma", "journal_mode(WAL)")
+	}
+
+	if strings.Contains(path, "?") {
+		return path + "&" + params.Encode()
+	}
+	return path + "?" + params.Encode()
+}
@@ -1,12 +1,14 @@
 package database
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"strings"
 	"time"
 
-	"gorm.io/driver/postgres"
+	"NDClasses/clients/logger"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
@@ -14,77 +16,136 @@ type Database struct {
 	DB *gorm.DB
 }
 
-// New creates a new database connection
-func New() (*Database, error) {
-	// Get database connection details from environment variables
-	host := os.Getenv("DB_HOST")
-	if host == "" {
-		host = "localhost"
-	}
+// Delays between attempts to reach a database that isn't up yet
+const (
+	connectRetryBase = 500 * time.Millisecond
+	connectRetryMax  = 8 * time.Second
+)
 
-	port := os.Getenv("DB_PORT")
-	if port == "" {
-		port = "5432"
+// New connects to the database and applies pending migrations
+func New(ctx context.Context) (*Database, error) {
+	d, err := Connect(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	user := os.Getenv("DB_USER")
-	if user == "" {
-		return nil, fmt.Errorf("DB_USER not set in environment variables")
+	if _, err := d.MigrateUp(); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	password := os.Getenv("DB_PASSWORD")
-	if password == "" {
-		return nil, fmt.Errorf("DB_PASSWORD not set in environment variables")
-	}
+	return d, nil
+}
 
-	dbname := os.Getenv("DB_NAME")
-	if dbname == "" {
-		return nil, fmt.Errorf("DB_NAME not set in environment variables")
+// Connect opens the database configured by environment variables without touching the schema
+func Connect(ctx context.Context) (*Database, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
 	}
 
-	// Create connection string for database connection
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=UTC",
-		host, user, password, dbname, port)
+	return Open(ctx, config)
+}
 
-	// Try to connect to the database
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Open connects to a database without touching the schema.
+// A database that can't be reached yet, e.g. one still starting next to the bot,
+// is retried with backoff until config.ConnectTimeout runs out or ctx is cancelled.
+func Open(ctx context.Context, config Config) (*Database, error) {
+	dialector, err := config.dialector()
 	if err != nil {
-		// If database doesn't exist, try to create it
-		if strings.Contains(err.Error(), "does not exist") {
-			// Connect to PostgreSQL without specifying database name
-			adminDSN := fmt.Sprintf("host=%s user=%s password=%s port=%s sslmode=disable TimeZone=UTC",
-				host, user, password, port)
-			adminDB, adminErr := gorm.Open(postgres.Open(adminDSN), &gorm.Config{})
-			if adminErr != nil {
-				return nil, fmt.Errorf("failed to connect to PostgreSQL server: %w", adminErr)
-			}
-
-			// Create the database
-			sqlDB, _ := adminDB.DB()
-			_, createErr := sqlDB.Exec(fmt.Sprintf("CREATE DATABASE \"%s\"", dbname))
-			if createErr != nil {
-				return nil, fmt.Errorf("failed to create database: %w", createErr)
-			}
-
-			// Close admin connection
-			sqlDB.Close()
-
-			// Now connect to the newly created database
-			db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
-			if err != nil {
-				return nil, fmt.Errorf("failed to connect to newly created database: %w", err)
-			}
-		} else {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(config.ConnectTimeout)
+	delay := connectRetryBase
+
+	for {
+		d, err := open(ctx, dialector, config)
+		if err == nil {
+			return d, nil
+		}
+
+		// Older versions created a missing Postgres database; now it has to exist, and waiting won't make it appear
+		if missingDatabase(err) {
+			return nil, fmt.Errorf("failed to connect to database: %w (create it first, e.g. with createdb)", err)
+		}
+
+		if time.Now().Add(delay).After(deadline) {
 			return nil, fmt.Errorf("failed to connect to database: %w", err)
 		}
+
+		logger.Default().Component("database").With("retry_in", delay).Warn("Database isn't reachable: %v", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to connect to database: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, connectRetryMax)
 	}
+}
 
-	// Run migrations
-	if err := db.AutoMigrate(&User{}, &TrackedCRN{}); err != nil {
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
+// missingDatabase reports whether Postgres refused the connection because the database doesn't exist
+func missingDatabase(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "3D000"
+}
+
+// open makes one attempt to connect and sets up the connection pool
+func open(ctx context.Context, dialector gorm.Dialector, config Config) (*Database, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true, Logger: gormLogger{}})
+	if err != nil {
+		return nil, err
 	}
 
-	return &Database{DB: db}, nil
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("can't get database connection: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	// Every connection to an in-memory SQLite database is a separate database that's gone once it's closed,
+	// so all work must share one connection that's never let go
+	if config.inMemory() {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		sqlDB.SetConnMaxIdleTime(0)
+	}
+
+	d := &Database{DB: db}
+	if err := d.Ping(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Ping checks that the database can be reached
+func (d *Database) Ping(ctx context.Context) error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return fmt.Errorf("can't get database connection: %w", err)
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("can't reach database: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the underlying database connection
+func (d *Database) Close() error {
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return fmt.Errorf("can't get database connection: %w", err)
+	}
+	return sqlDB.Close()
 }
 
 // CreateUser creates a new user in the database
@@ -123,36 +184,71 @@ func (d *Database) GetUserByID(id int64) (*User, error) {
 	return &user, nil
 }
 
-// AddTrackedCRN adds a CRN to track for a user
-func (d *Database) AddTrackedCRN(userID int64, crn string, title string) (*TrackedCRN, error) {
+// GetAllUsers retrieves every user, in the order they joined
+func (d *Database) GetAllUsers() ([]User, error) {
+	var users []User
+	result := d.DB.Order("id").Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
+// AddTrackedCRN adds a CRN in the given term to track for a user
+func (d *Database) AddTrackedCRN(userID int64, crn string, term string, title string) (*TrackedCRN, error) {
 	trackedCRN := &TrackedCRN{
 		UserID:    userID,
 		CRN:       crn,
+		Term:      term,
 		Title:     title,
 		Active:    true,
 		CreatedAt: time.Now().Unix(),
 	}
 
-	result := d.DB.FirstOrCreate(trackedCRN, TrackedCRN{UserID: userID, CRN: crn})
+	// Conditions are given explicitly so that an empty term is matched as well
+	result := d.DB.Where("user_id = ? AND crn = ? AND term = ?", userID, crn, term).FirstOrCreate(trackedCRN)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 
-	// If the record already existed but was inactive, reactivate it
+	// If the record already existed but was inactive, reactivate it without the snooze it was removed with
 	if !trackedCRN.Active {
-		result = d.DB.Model(trackedCRN).Update("active", true)
+		result = d.DB.Model(trackedCRN).Updates(map[string]any{"active": true, "snoozed_until": 0})
 		if result.Error != nil {
 			return nil, result.Error
 		}
 		trackedCRN.Active = true
+		trackedCRN.SnoozedUntil = 0
 	}
 
 	return trackedCRN, nil
 }
 
-// RemoveTrackedCRN removes a CRN from tracking for a user
-func (d *Database) RemoveTrackedCRN(userID int64, crn string) error {
-	result := d.DB.Model(&TrackedCRN{}).Where("user_id = ? AND crn = ?", userID, crn).Update("active", false)
+// RemoveTrackedCRN removes a CRN in the given term from tracking for a user, leaving the same CRN in other terms alone
+func (d *Database) RemoveTrackedCRN(userID int64, crn string, term string) error {
+	result := d.DB.Model(&TrackedCRN{}).Where("user_id = ? AND crn = ? AND term = ?", userID, crn, term).Update("active", false)
+	return result.Error
+}
+
+// GetTrackedCRN retrieves one of the user's tracked CRNs by its ID
+func (d *Database) GetTrackedCRN(userID int64, id int64) (*TrackedCRN, error) {
+	var crn TrackedCRN
+	result := d.DB.Where("id = ? AND user_id = ?", id, userID).First(&crn)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &crn, nil
+}
+
+// RemoveTrackedCRNByID removes one of the user's tracked CRNs by its ID, leaving the same CRN in other terms alone
+func (d *Database) RemoveTrackedCRNByID(userID int64, id int64) error {
+	result := d.DB.Model(&TrackedCRN{}).Where("id = ? AND user_id = ?", id, userID).Update("active", false)
+	return result.Error
+}
+
+// SnoozeTrackedCRN holds back seat alerts for one of the user's tracked CRNs until the given Unix timestamp
+func (d *Database) SnoozeTrackedCRN(userID int64, id int64, until int64) error {
+	result := d.DB.Model(&TrackedCRN{}).Where("id = ? AND user_id = ?", id, userID).Update("snoozed_until", until)
 	return result.Error
 }
 
@@ -176,8 +272,80 @@ func (d *Database) GetAllTrackedCRNs() ([]TrackedCRN, error) {
 	return crns, nil
 }
 
-// UpdateCRNTitle updates the title of a tracked CRN
-func (d *Database) UpdateCRNTitle(userID int64, crn string, title string) error {
-	result := d.DB.Model(&TrackedCRN{}).Where("user_id = ? AND crn = ?", userID, crn).Update("title", title)
+// UpdateCRNTitle updates the title of a CRN the user tracks in the given term
+func (d *Database) UpdateCRNTitle(userID int64, crn string, term string, title string) error {
+	result := d.DB.Model(&TrackedCRN{}).Where("user_id = ? AND crn = ? AND term = ?", userID, crn, term).Update("title", title)
 	return result.Error
 }
+
+// GetSectionState retrieves the last observed state of a section
+func (d *Database) GetSectionState(term string, crn string) (*SectionState, error) {
+	var state SectionState
+	result := d.DB.Where("term = ? AND crn = ?", term, crn).First(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &state, nil
+}
+
+// SaveSectionState creates or updates the observed state of a section
+func (d *Database) SaveSectionState(state *SectionState) error {
+	if state.ID == 0 {
+		return d.DB.Create(state).Error
+	}
+	return d.DB.Save(state).Error
+}
+
+// GetDeliveredNotifications retrieves the alerts delivered to any user for a section's transitions at or after since
+func (d *Database) GetDeliveredNotifications(term string, crn string, since int64) ([]Notification, error) {
+	var notifications []Notification
+	result := d.DB.Where("term = ? AND crn = ? AND changed_at >= ? AND delivered = ?", term, crn, since, true).Find(&notifications)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return notifications, nil
+}
+
+// RecordNotification stores the outcome of sending an alert for a section transition
+func (d *Database) RecordNotification(userID int64, term string, crn string, kind string, changedAt int64, delivered bool) error {
+	notification := &Notification{
+		UserID:    userID,
+		Term:      term,
+		CRN:       crn,
+		Kind:      kind,
+		ChangedAt: changedAt,
+	}
+
+	result := d.DB.Where("user_id = ? AND term = ? AND crn = ? AND kind = ? AND changed_at = ?",
+		userID, term, crn, kind, changedAt).FirstOrCreate(notification)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	return d.DB.Model(notification).Updates(map[string]interface{}{
+		"delivered": delivered,
+		"sent_at":   time.Now().Unix(),
+	}).Error
+}
+
+// RecordObservation stores the result of fetching a section
+func (d *Database) RecordObservation(observation *SectionObservation) error {
+	result := d.DB.Create(observation)
+	return result.Error
+}
+
+// GetObservations retrieves the observations of a section made at or after since, oldest first
+func (d *Database) GetObservations(term string, crn string, since int64) ([]SectionObservation, error) {
+	var observations []SectionObservation
+	result := d.DB.Where("term = ? AND crn = ? AND observed_at >= ?", term, crn, since).Order("observed_at, id").Find(&observations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return observations, nil
+}
+
+// PruneObservations deletes observations made before the given Unix timestamp and returns how many were deleted
+func (d *Database) PruneObservations(before int64) (int64, error) {
+	result := d.DB.Where("observed_at < ?", before).Delete(&SectionObservation{})
+	return result.RowsAffected, result.Error
+}
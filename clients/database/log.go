@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"NDClasses/clients/logger"
+
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// slowQuery is how long a query may take before it's logged as a warning
+const slowQuery = 200 * time.Millisecond
+
+// gormLogger routes GORM's messages through the bot's logger: failed and slow queries are reported,
+// other queries aren't. Missing records are expected and left to the caller.
+type gormLogger struct{}
+
+// log returns the logger for database messages
+func (gormLogger) log() *logger.Logger {
+	return logger.Default().Component("database")
+}
+
+func (g gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return g
+}
+
+func (g gormLogger) Info(ctx context.Context, format string, args ...interface{}) {
+	g.log().Debug(format, args...)
+}
+
+func (g gormLogger) Warn(ctx context.Context, format string, args ...interface{}) {
+	g.log().Warn(format, args...)
+}
+
+func (g gormLogger) Error(ctx context.Context, format string, args ...interface{}) {
+	g.log().Error(format, args...)
+}
+
+func (g gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && !errors.Is(err, gormlogger.ErrRecordNotFound) && !errors.Is(err, context.Canceled):
+		sql, rows := fc()
+		g.log().With("sql", sql, "rows", rows, "duration", elapsed).Error("Query failed: %v", err)
+	case elapsed > slowQuery:
+		sql, rows := fc()
+		g.log().With("sql", sql, "rows", rows, "duration", elapsed).Warn("Slow query")
+	}
+}
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory is a Store that keeps everything in memory, for tests and running the bot without a database.
+// It behaves like Database, including returning ErrNotFound, but forgets everything when the process exits.
+type Memory struct {
+	mu     sync.Mutex
+	closed bool
+
+	users         map[int64]*User
+	crns          map[int64]*TrackedCRN
+	states        map[int64]*SectionState
+	notifications []*Notification
+	observations  []SectionObservation
+
+	// Last IDs handed out, per table
+	lastUserID         int64
+	lastCRNID          int64
+	lastStateID        int64
+	lastNotificationID int64
+	lastObservationID  int64
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{
+		users:  make(map[int64]*User),
+		crns:   make(map[int64]*TrackedCRN),
+		states: make(map[int64]*SectionState),
+	}
+}
+
+// lock locks the store, failing if it has been closed
+func (m *Memory) lock() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+// Ping reports whether the store is still open
+func (m *Memory) Ping(ctx context.Context) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// Close closes the store; later calls fail with ErrClosed
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// CreateUser returns the user with the Telegram ID, creating it if needed
+func (m *Memory) CreateUser(telegramID int64, username string) (*User, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.TelegramID == telegramID {
+			c := *user
+			return &c, nil
+		}
+	}
+
+	m.lastUserID++
+	user := &User{ID: m.lastUserID, TelegramID: telegramID, Username: username, CreatedAt: time.Now().Unix()}
+	m.users[user.ID] = user
+
+	c := *user
+	return &c, nil
+}
+
+// GetUserByTelegramID retrieves a user by their Telegram ID
+func (m *Memory) GetUserByTelegramID(telegramID int64) (*User, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.TelegramID == telegramID {
+			c := *user
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetUserByID retrieves a user by their ID
+func (m *Memory) GetUserByID(id int64) (*User, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *user
+	return &c, nil
+}
+
+// GetAllUsers retrieves every user, in the order they joined
+func (m *Memory) GetAllUsers() ([]User, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	users := make([]User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users, nil
+}
+
+// AddTrackedCRN adds a CRN in the given term to track for a user, reactivating it if it was removed
+func (m *Memory) AddTrackedCRN(userID int64, crn string, term string, title string) (*TrackedCRN, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for _, tracked := range m.crns {
+		if tracked.UserID == userID && tracked.CRN == crn && tracked.Term == term {
+			// Reactivating drops the snooze the row was removed with
+			if !tracked.Active {
+				tracked.Active = true
+				tracked.SnoozedUntil = 0
+			}
+			c := *tracked
+			return &c, nil
+		}
+	}
+
+	m.lastCRNID++
+	tracked := &TrackedCRN{
+		ID:        m.lastCRNID,
+		UserID:    userID,
+		CRN:       crn,
+		Term:      term,
+		Title:     title,
+		Active:    true,
+		CreatedAt: time.Now().Unix(),
+	}
+	m.crns[tracked.ID] = tracked
+
+	c := *tracked
+	return &c, nil
+}
+
+// GetTrackedCRN retrieves one of the user's tracked CRNs by its ID
+func (m *Memory) GetTrackedCRN(userID int64, id int64) (*TrackedCRN, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	tracked, ok := m.crns[id]
+	if !ok || tracked.UserID != userID {
+		return nil, ErrNotFound
+	}
+	c := *tracked
+	return &c, nil
+}
+
+// RemoveTrackedCRNByID removes one of the user's tracked CRNs by its ID
+func (m *Memory) RemoveTrackedCRNByID(userID int64, id int64) error {
+	return m.updateCRNs(func(tracked *TrackedCRN) bool {
+		return tracked.ID == id && tracked.UserID == userID
+	}, func(tracked *TrackedCRN) {
+		tracked.Active = false
+	})
+}
+
+// SnoozeTrackedCRN holds back seat alerts for one of the user's tracked CRNs until the given Unix timestamp
+func (m *Memory) SnoozeTrackedCRN(userID int64, id int64, until int64) error {
+	return m.updateCRNs(func(tracked *TrackedCRN) bool {
+		return tracked.ID == id && tracked.UserID == userID
+	}, func(tracked *TrackedCRN) {
+		tracked.SnoozedUntil = until
+	})
+}
+
+// GetUserTrackedCRNs retrieves all active CRNs tracked by a user
+func (m *Memory) GetUserTrackedCRNs(userID int64) ([]TrackedCRN, error) {
+	return m.findCRNs(func(tracked *TrackedCRN) bool {
+		return tracked.UserID == userID && tracked.Active
+	})
+}
+
+// GetAllTrackedCRNs retrieves all active CRNs tracked by all users
+func (m *Memory) GetAllTrackedCRNs() ([]TrackedCRN, error) {
+	return m.findCRNs(func(tracked *TrackedCRN) bool {
+		return tracked.Active
+	})
+}
+
+// UpdateCRNTitle updates the title of a CRN the user tracks in the given term
+func (m *Memory) UpdateCRNTitle(userID int64, crn string, term string, title string) error {
+	return m.updateCRNs(func(tracked *TrackedCRN) bool {
+		return tracked.UserID == userID && tracked.CRN == crn && tracked.Term == term
+	}, func(tracked *TrackedCRN) {
+		tracked.Title = title
+	})
+}
+
+// findCRNs returns copies of the tracked CRNs matching a condition, in the order they were added
+func (m *Memory) findCRNs(match func(*TrackedCRN) bool) ([]TrackedCRN, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var crns []TrackedCRN
+	for _, tracked := range m.crns {
+		if match(tracked) {
+			crns = append(crns, *tracked)
+		}
+	}
+	sort.Slice(crns, func(i, j int) bool { return crns[i].ID < crns[j].ID })
+
+	return crns, nil
+}
+
+// updateCRNs applies a change to the tracked CRNs matching a condition
+func (m *Memory) updateCRNs(match func(*TrackedCRN) bool, update func(*TrackedCRN)) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	for _, tracked := range m.crns {
+		if match(tracked) {
+			update(tracked)
+		}
+	}
+	return nil
+}
+
+// GetSectionState retrieves the last observed state of a section
+func (m *Memory) GetSectionState(term string, crn string) (*SectionState, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	for _, state := range m.states {
+		if state.Term == term && state.CRN == crn {
+			c := *state
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// SaveSectionState creates or updates the observed state of a section
+func (m *Memory) SaveSectionState(state *SectionState) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	if state.ID == 0 {
+		// Like the unique index in the database, a section has one state
+		for _, existing := range m.states {
+			if existing.Term == state.Term && existing.CRN == state.CRN {
+				return fmt.Errorf("section state for CRN %s in term %q already exists", state.CRN, state.Term)
+			}
+		}
+		m.lastStateID++
+		state.ID = m.lastStateID
+	}
+
+	c := *state
+	m.states[state.ID] = &c
+	return nil
+}
+
+// GetDeliveredNotifications retrieves the alerts delivered to any user for a section's transitions at or after since
+func (m *Memory) GetDeliveredNotifications(term string, crn string, since int64) ([]Notification, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var notifications []Notification
+	for _, n := range m.notifications {
+		if n.Term == term && n.CRN == crn && n.ChangedAt >= since && n.Delivered {
+			notifications = append(notifications, *n)
+		}
+	}
+	return notifications, nil
+}
+
+// RecordNotification stores the outcome of sending an alert for a section transition
+func (m *Memory) RecordNotification(userID int64, term string, crn string, kind string, changedAt int64, delivered bool) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	n := m.findNotification(userID, term, crn, kind, changedAt)
+	if n == nil {
+		m.lastNotificationID++
+		n = &Notification{ID: m.lastNotificationID, UserID: userID, Term: term, CRN: crn, Kind: kind, ChangedAt: changedAt}
+		m.notifications = append(m.notifications, n)
+	}
+
+	n.Delivered = delivered
+	n.SentAt = time.Now().Unix()
+	return nil
+}
+
+// findNotification finds the record of an alert; the caller must hold the lock
+func (m *Memory) findNotification(userID int64, term string, crn string, kind string, changedAt int64) *Notification {
+	for _, n := range m.notifications {
+		if n.UserID == userID && n.Term == term && n.CRN == crn && n.Kind == kind && n.ChangedAt == changedAt {
+			return n
+		}
+	}
+	return nil
+}
+
+// RecordObservation stores the result of fetching a section
+func (m *Memory) RecordObservation(observation *SectionObservation) error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.mu.Unlock()
+
+	m.lastObservationID++
+	observation.ID = m.lastObservationID
+	m.observations = append(m.observations, *observation)
+	return nil
+}
+
+// GetObservations retrieves the observations of a section made at or after since, oldest first
+func (m *Memory) GetObservations(term string, crn string, since int64) ([]SectionObservation, error) {
+	if err := m.lock(); err != nil {
+		return nil, err
+	}
+	defer m.mu.Unlock()
+
+	var observations []SectionObservation
+	for _, obs := range m.observations {
+		if obs.Term == term && obs.CRN == crn && obs.ObservedAt >= since {
+			observations = append(observations, obs)
+		}
+	}
+	sort.SliceStable(observations, func(i, j int) bool {
+		if observations[i].ObservedAt != observations[j].ObservedAt {
+			return observations[i].ObservedAt < observations[j].ObservedAt
+		}
+		return observations[i].ID < observations[j].ID
+	})
+
+	return observations, nil
+}
+
+// PruneObservations deletes observations made before the given Unix timestamp and returns how many were deleted
+func (m *Memory) PruneObservations(before int64) (int64, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.mu.Unlock()
+
+	kept := m.observations[:0]
+	for _, obs := range m.observations {
+		if obs.ObservedAt >= before {
+			kept = append(kept, obs)
+		}
+	}
+	deleted := int64(len(m.observations) - len(kept))
+	m.observations = kept
+
+	return deleted, nil
+}
@@ -0,0 +1,287 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one versioned step of the database schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int    `json:"version" gorm:"primaryKey;autoIncrement:false"`
+	Name      string `json:"name"`
+	AppliedAt int64  `json:"applied_at"`
+}
+
+// MigrationStatus tells whether a migration has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt int64 // Unix timestamp, 0 if not applied
+}
+
+// migrations is the schema history in order.
+// New migrations go at the end; migrations that may have been applied somewhere must never change.
+// Each one spells out its DDL, so later changes to models.go don't alter it.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users and tracked_crns",
+		Up: func(tx *gorm.DB) error {
+			// Databases from before migrations were versioned were created by AutoMigrate; bring them to this shape instead
+			if tx.Migrator().HasTable("users") || tx.Migrator().HasTable("tracked_crns") {
+				return tx.AutoMigrate(&userV1{}, &trackedCRNV1{})
+			}
+			return exec(tx,
+				"CREATE TABLE users ("+idColumn(tx)+", telegram_id bigint, username text, created_at bigint)",
+				"CREATE UNIQUE INDEX idx_users_telegram_id ON users (telegram_id)",
+				"CREATE TABLE tracked_crns ("+idColumn(tx)+", user_id bigint, crn text, title text, active boolean DEFAULT true, created_at bigint)",
+				"CREATE INDEX idx_tracked_crns_user_id ON tracked_crns (user_id)",
+				"CREATE INDEX idx_tracked_crns_crn ON tracked_crns (crn)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return exec(tx, "DROP TABLE tracked_crns", "DROP TABLE users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "add term to tracked_crns",
+		Up: func(tx *gorm.DB) error {
+			// Rows tracked before terms were stored get an empty term, meaning the current one
+			if err := addColumn(tx, "tracked_crns", "term", "text NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+			return exec(tx, "CREATE INDEX IF NOT EXISTS idx_tracked_crns_term ON tracked_crns (term)")
+		},
+		Down: func(tx *gorm.DB) error {
+			return exec(tx, "DROP INDEX idx_tracked_crns_term", "ALTER TABLE tracked_crns DROP COLUMN term")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create section_states and notifications",
+		Up: func(tx *gorm.DB) error {
+			return exec(tx,
+				"CREATE TABLE IF NOT EXISTS section_states ("+idColumn(tx)+", term text, crn text, seats bigint, open boolean, opened_at bigint, changed_at bigint, checked_at bigint)",
+				"CREATE UNIQUE INDEX IF NOT EXISTS idx_section_state ON section_states (term, crn)",
+				"CREATE TABLE IF NOT EXISTS notifications ("+idColumn(tx)+", user_id bigint, term text, crn text, kind text, changed_at bigint, delivered boolean, sent_at bigint)",
+				"CREATE UNIQUE INDEX IF NOT EXISTS idx_notification ON notifications (user_id, term, crn, kind, changed_at)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return exec(tx, "DROP TABLE notifications", "DROP TABLE section_states")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add snoozed_until to tracked_crns",
+		Up: func(tx *gorm.DB) error {
+			return addColumn(tx, "tracked_crns", "snoozed_until", "bigint NOT NULL DEFAULT 0")
+		},
+		Down: func(tx *gorm.DB) error {
+			return exec(tx, "ALTER TABLE tracked_crns DROP COLUMN snoozed_until")
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create section_observations",
+		Up: func(tx *gorm.DB) error {
+			return exec(tx,
+				"CREATE TABLE IF NOT EXISTS section_observations ("+idColumn(tx)+", term text, crn text, success boolean, error text, "+
+					"seats bigint, enrollment bigint, capacity bigint, waitlist_capacity bigint, waitlist_count bigint, observed_at bigint)",
+				"CREATE INDEX IF NOT EXISTS idx_observation ON section_observations (term, crn)",
+				"CREATE INDEX IF NOT EXISTS idx_section_observations_observed_at ON section_observations (observed_at)",
+			)
+		},
+		Down: func(tx *gorm.DB) error {
+			return exec(tx, "DROP TABLE section_observations")
+		},
+	},
+}
+
+// Migrations returns the known migrations in order
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+// exec runs DDL statements in order, stopping at the first that fails
+func exec(tx *gorm.DB, statements ...string) error {
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// idColumn returns the DDL of an auto-incrementing primary key, which SQLite and PostgreSQL spell differently
+func idColumn(tx *gorm.DB) string {
+	if tx.Dialector.Name() == DriverPostgres {
+		return "id bigserial PRIMARY KEY"
+	}
+	return "id integer PRIMARY KEY AUTOINCREMENT"
+}
+
+// addColumn adds a column to a table.
+// Tables AutoMigrate brought up to date before migrations were versioned may have it already,
+// without its default; their empty values are filled in instead.
+func addColumn(tx *gorm.DB, table string, column string, definition string) error {
+	if !tx.Migrator().HasColumn(table, column) {
+		return exec(tx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	}
+
+	_, value, _ := strings.Cut(definition, "DEFAULT ")
+	return exec(tx, fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s IS NULL", table, column, value, column))
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func (d *Database) MigrationStatus() ([]MigrationStatus, error) {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if record, ok := applied[m.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = record.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// MigrateUp applies all pending migrations in order and returns the ones it applied.
+// Each migration runs in its own transaction together with its schema_migrations record.
+func (d *Database) MigrateUp() ([]Migration, error) {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err := d.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().Unix()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("can't apply migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first, and returns the ones it rolled back
+func (d *Database) MigrateDown(steps int) ([]Migration, error) {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+
+	var done []Migration
+	for _, version := range versions[:min(steps, len(versions))] {
+		m, ok := findMigration(version)
+		if !ok {
+			return done, fmt.Errorf("can't roll back migration %d: it's unknown to this version of the bot", version)
+		}
+
+		err := d.DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("can't roll back migration %d (%s): %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// appliedMigrations returns the applied migrations by version, creating the schema_migrations table if needed
+func (d *Database) appliedMigrations() (map[int]SchemaMigration, error) {
+	if !d.DB.Migrator().HasTable(&SchemaMigration{}) {
+		if err := d.DB.Migrator().CreateTable(&SchemaMigration{}); err != nil {
+			return nil, fmt.Errorf("can't create schema_migrations table: %w", err)
+		}
+	}
+
+	var records []SchemaMigration
+	if err := d.DB.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("can't get applied migrations: %w", err)
+	}
+
+	applied := make(map[int]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+// findMigration finds a known migration by version
+func findMigration(version int) (Migration, bool) {
+	for _, m := range migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Models as they were when migration 1 was written, for bringing tables made by AutoMigrate to its shape
+
+type userV1 struct {
+	ID         int64 `gorm:"primaryKey"`
+	TelegramID int64 `gorm:"uniqueIndex"`
+	Username   string
+	CreatedAt  int64
+}
+
+func (userV1) TableName() string { return "users" }
+
+type trackedCRNV1 struct {
+	ID        int64  `gorm:"primaryKey"`
+	UserID    int64  `gorm:"index"`
+	CRN       string `gorm:"index"`
+	Title     string
+	Active    bool `gorm:"default:true"`
+	CreatedAt int64
+}
+
+func (trackedCRNV1) TableName() string { return "tracked_crns" }
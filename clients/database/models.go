@@ -13,7 +13,55 @@ type TrackedCRN struct {
 	ID        int64  `json:"id" gorm:"primaryKey"`
 	UserID    int64  `json:"user_id" gorm:"index"`
 	CRN       string `json:"crn" gorm:"index"`
+	Term      string `json:"term" gorm:"index"` // Term code; empty means the current term
 	Title     string `json:"title"`
 	Active    bool   `json:"active" gorm:"default:true"`
 	CreatedAt int64  `json:"created_at"`
+
+	SnoozedUntil int64 `json:"snoozed_until"` // Unix timestamp until which seat alerts are held back
+}
+
+// SectionState represents the last observed seat state of a section
+type SectionState struct {
+	ID        int64  `json:"id" gorm:"primaryKey"`
+	Term      string `json:"term" gorm:"uniqueIndex:idx_section_state"`
+	CRN       string `json:"crn" gorm:"uniqueIndex:idx_section_state"`
+	Seats     int    `json:"seats"`
+	Open      bool   `json:"open"`
+	OpenedAt  int64  `json:"opened_at"`  // Unix timestamp of the last closed→open transition
+	ChangedAt int64  `json:"changed_at"` // Unix timestamp of the last transition in either direction
+	CheckedAt int64  `json:"checked_at"`
+}
+
+// Notification kinds
+const (
+	NotificationOpened = "opened"
+	NotificationClosed = "closed"
+)
+
+// Notification records a seat alert for one user about one section transition
+type Notification struct {
+	ID        int64  `json:"id" gorm:"primaryKey"`
+	UserID    int64  `json:"user_id" gorm:"uniqueIndex:idx_notification"`
+	Term      string `json:"term" gorm:"uniqueIndex:idx_notification"`
+	CRN       string `json:"crn" gorm:"uniqueIndex:idx_notification"`
+	Kind      string `json:"kind" gorm:"uniqueIndex:idx_notification"`
+	ChangedAt int64  `json:"changed_at" gorm:"uniqueIndex:idx_notification"` // Transition the alert is about
+	Delivered bool   `json:"delivered"`
+	SentAt    int64  `json:"sent_at"`
+}
+
+// SectionObservation records one fetch of a section by the checker, successful or not
+type SectionObservation struct {
+	ID               int64  `json:"id" gorm:"primaryKey"`
+	Term             string `json:"term" gorm:"index:idx_observation"`
+	CRN              string `json:"crn" gorm:"index:idx_observation"`
+	Success          bool   `json:"success"`
+	Error            string `json:"error"`
+	Seats            int    `json:"seats"`
+	Enrollment       int    `json:"enrollment"`
+	Capacity         int    `json:"capacity"`
+	WaitlistCapacity int    `json:"waitlist_capacity"`
+	WaitlistCount    int    `json:"waitlist_count"`
+	ObservedAt       int64  `json:"observed_at" gorm:"index"`
 }
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned when a requested record doesn't exist.
+// It's the same error GORM returns, so either can be checked with errors.Is.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// ErrClosed is returned by a store that has been closed
+var ErrClosed = errors.New("database is closed")
+
+// Users stores the bot's Telegram users
+type Users interface {
+	// CreateUser returns the user with the Telegram ID, creating it if needed
+	CreateUser(telegramID int64, username string) (*User, error)
+	GetUserByTelegramID(telegramID int64) (*User, error)
+	GetUserByID(id int64) (*User, error)
+	// GetAllUsers returns every user, in the order they joined
+	GetAllUsers() ([]User, error)
+}
+
+// Tracking stores the CRNs users track
+type Tracking interface {
+	AddTrackedCRN(userID int64, crn string, term string, title string) (*TrackedCRN, error)
+	GetTrackedCRN(userID int64, id int64) (*TrackedCRN, error)
+	RemoveTrackedCRNByID(userID int64, id int64) error
+	SnoozeTrackedCRN(userID int64, id int64, until int64) error
+	GetUserTrackedCRNs(userID int64) ([]TrackedCRN, error)
+	GetAllTrackedCRNs() ([]TrackedCRN, error)
+	UpdateCRNTitle(userID int64, crn string, term string, title string) error
+}
+
+// Sections stores what the checker learns about sections: their state, the alerts sent and their history
+type Sections interface {
+	GetSectionState(term string, crn string) (*SectionState, error)
+	SaveSectionState(state *SectionState) error
+	// GetDeliveredNotifications returns the alerts delivered for a section's transitions at or after since
+	GetDeliveredNotifications(term string, crn string, since int64) ([]Notification, error)
+	RecordNotification(userID int64, term string, crn string, kind string, changedAt int64, delivered bool) error
+	RecordObservation(observation *SectionObservation) error
+	GetObservations(term string, crn string, since int64) ([]SectionObservation, error)
+	PruneObservations(before int64) (int64, error)
+}
+
+// Store is everything the bot keeps: the GORM Database, or Memory when nothing needs to outlive the process
+type Store interface {
+	Users
+	Tracking
+	Sections
+
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Store = (*Database)(nil)
+	_ Store = (*Memory)(nil)
+)
+
+// NewStore opens the store configured by environment variables, applying pending migrations.
+// DB_DRIVER=memory (or DATABASE_URL=memory:) keeps everything in memory, for demos without a database.
+func NewStore(ctx context.Context) (Store, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Driver == DriverMemory {
+		return NewMemory(), nil
+	}
+
+	return New(ctx)
+}
@@ -0,0 +1,229 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"NDClasses/clients/logger"
+)
+
+// Check statuses, from best to worst
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn" // Worth a look, but the bot still works
+	StatusFail = "fail"
+)
+
+// Defaults for the thresholds
+const (
+	defaultStallAfter     = 15 * time.Minute
+	defaultMinSuccessRate = 0.5
+	pingTimeout           = 2 * time.Second
+)
+
+// minParserSamples is how many recent searches the parser's success rate needs before it's judged
+const minParserSamples = 10
+
+// Pinger reports whether the database is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// CycleTracker reports when the checker last went through all tracked CRNs, and whether an operator paused it
+type CycleTracker interface {
+	LastCycle() time.Time
+	Paused() (bool, time.Time)
+}
+
+// PollTracker reports when updates were last fetched from Telegram
+type PollTracker interface {
+	LastPoll() time.Time
+}
+
+// SuccessRater reports the share of recent searches that succeeded, and how many searches that is
+type SuccessRater interface {
+	SuccessRate() (float64, int)
+}
+
+// Health reports the state of the bot's parts for liveness and readiness probes
+type Health struct {
+	db      Pinger
+	checker CycleTracker
+	updates PollTracker // nil when updates arrive by webhook
+	parser  SuccessRater
+
+	stallAfter     time.Duration
+	minSuccessRate float64
+	started        time.Time
+}
+
+// Report is the body of a health response
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Check is the state of one part of the bot
+type Check struct {
+	Status      string    `json:"status"`
+	Message     string    `json:"message,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitzero"`
+	SuccessRate *float64  `json:"success_rate,omitempty"`
+	Samples     int       `json:"samples,omitempty"`
+}
+
+// New creates a health reporter. Pass a nil updates when the bot receives updates by webhook.
+// HEALTH_STALL_AFTER sets how long the checker and polling may go without succeeding (default 15m),
+// and HEALTH_MIN_PARSER_SUCCESS_RATE the share of recent searches that must succeed (default 0.5).
+func New(db Pinger, checker CycleTracker, updates PollTracker, parser SuccessRater) *Health {
+	stallAfter, err := time.ParseDuration(os.Getenv("HEALTH_STALL_AFTER"))
+	if err != nil || stallAfter <= 0 {
+		stallAfter = defaultStallAfter
+	}
+
+	minSuccessRate, err := strconv.ParseFloat(os.Getenv("HEALTH_MIN_PARSER_SUCCESS_RATE"), 64)
+	if err != nil || minSuccessRate < 0 || minSuccessRate > 1 {
+		minSuccessRate = defaultMinSuccessRate
+	}
+
+	return &Health{
+		db:             db,
+		checker:        checker,
+		updates:        updates,
+		parser:         parser,
+		stallAfter:     stallAfter,
+		minSuccessRate: minSuccessRate,
+		started:        time.Now(),
+	}
+}
+
+// Liveness reports whether the bot is still doing its job, which is when the checker isn't stalled.
+// Restarting won't fix an unreachable database or registration site, so those don't count.
+func (h *Health) Liveness(ctx context.Context) Report {
+	return newReport(map[string]Check{
+		"checker": h.checkChecker(),
+	})
+}
+
+// Readiness reports the state of every part; the bot is ready when none of them fails
+func (h *Health) Readiness(ctx context.Context) Report {
+	checks := map[string]Check{
+		"database": h.checkDatabase(ctx),
+		"checker":  h.checkChecker(),
+		"parser":   h.checkParser(),
+	}
+	if h.updates != nil {
+		checks["updates"] = h.checkUpdates()
+	}
+	return newReport(checks)
+}
+
+// LivenessHandler serves Liveness, for /healthz
+func (h *Health) LivenessHandler() http.Handler {
+	return reportHandler(h.Liveness)
+}
+
+// ReadinessHandler serves Readiness, for /readyz
+func (h *Health) ReadinessHandler() http.Handler {
+	return reportHandler(h.Readiness)
+}
+
+// checkDatabase pings the database
+func (h *Health) checkDatabase(ctx context.Context) Check {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := h.db.Ping(ctx); err != nil {
+		return Check{Status: StatusFail, Message: logger.Redact(err.Error())}
+	}
+	return Check{Status: StatusOK}
+}
+
+// checkChecker fails when no check of all tracked CRNs has finished for longer than stallAfter.
+// Before the first one, the time is counted from startup, and after a pause from when the checker was resumed.
+// A paused checker isn't stalled, since it's been told to stop.
+func (h *Health) checkChecker() Check {
+	last := h.checker.LastCycle()
+	paused, changed := h.checker.Paused()
+	if paused {
+		return Check{Status: StatusOK, LastSuccess: last, Message: fmt.Sprintf("paused since %s", changed.Format(time.RFC3339))}
+	}
+
+	since := h.since(last)
+	if !changed.IsZero() {
+		since = min(since, time.Since(changed))
+	}
+	if since > h.stallAfter {
+		return Check{Status: StatusFail, LastSuccess: last, Message: fmt.Sprintf("no complete check for %v", since.Round(time.Second))}
+	}
+	return Check{Status: StatusOK, LastSuccess: last}
+}
+
+// checkUpdates warns when getUpdates hasn't succeeded for longer than stallAfter.
+// Polling recovers on its own once Telegram is reachable, so this doesn't fail.
+func (h *Health) checkUpdates() Check {
+	last := h.updates.LastPoll()
+	if since := h.since(last); since > h.stallAfter {
+		return Check{Status: StatusWarn, LastSuccess: last, Message: fmt.Sprintf("no updates fetched for %v", since.Round(time.Second))}
+	}
+	return Check{Status: StatusOK, LastSuccess: last}
+}
+
+// checkParser warns when too many recent searches on the registration site failed
+func (h *Health) checkParser() Check {
+	rate, samples := h.parser.SuccessRate()
+	check := Check{Status: StatusOK, SuccessRate: &rate, Samples: samples}
+	if samples >= minParserSamples && rate < h.minSuccessRate {
+		check.Status = StatusWarn
+		check.Message = fmt.Sprintf("%.0f%% of recent searches succeeded", rate*100)
+	}
+	return check
+}
+
+// since returns how long ago last was, counting from startup if it's earlier or zero
+func (h *Health) since(last time.Time) time.Duration {
+	if last.Before(h.started) {
+		last = h.started
+	}
+	return time.Since(last)
+}
+
+// newReport sums up checks: the report fails if any check fails, and warns if any warns
+func newReport(checks map[string]Check) Report {
+	status := StatusOK
+	for _, check := range checks {
+		switch {
+		case check.Status == StatusFail:
+			status = StatusFail
+		case check.Status == StatusWarn && status == StatusOK:
+			status = StatusWarn
+		}
+	}
+	return Report{Status: status, Checks: checks}
+}
+
+// reportHandler serves a report as JSON, with 503 Service Unavailable when it fails
+func reportHandler(report func(ctx context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rep := report(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if rep.Status == StatusFail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(rep)
+		}
+	})
+}
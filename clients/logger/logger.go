@@ -1,40 +1,201 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync"
 )
 
-// Logger represents a simple logger with debug mode capability
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Logger writes leveled messages through log/slog.
+// Messages are formatted printf-style; key-value fields are attached with With and Component.
+// Secrets in messages and fields are masked, see Redact.
 type Logger struct {
+	slog      *slog.Logger
 	debugMode bool
 }
 
-// New creates a new logger instance
+// Config selects how much is logged and how
+type Config struct {
+	Level     slog.Level
+	Format    string    // FormatText (default) or FormatJSON
+	Stdout    io.Writer // Debug, info and warning messages; os.Stdout if nil
+	Stderr    io.Writer // Error messages; os.Stderr if nil
+	DebugMode bool      // Set by the -debug flag; also shows the parser's browser, which LOG_LEVEL alone doesn't
+}
+
+// New creates a new logger instance writing text, including debug messages if debugMode is set
 func New(debugMode bool) *Logger {
+	config := Config{Level: slog.LevelInfo, DebugMode: debugMode}
+	if debugMode {
+		config.Level = slog.LevelDebug
+	}
+	return NewWithConfig(config)
+}
+
+// NewWithConfig creates a logger with the given settings
+func NewWithConfig(config Config) *Logger {
+	stdout, stderr := config.Stdout, config.Stderr
+	if stdout == nil {
+		stdout = stdStream{stderr: false}
+	}
+	if stderr == nil {
+		stderr = stdStream{stderr: true}
+	}
+
+	options := &slog.HandlerOptions{Level: config.Level}
+	var low, high slog.Handler
+	if config.Format == FormatJSON {
+		low, high = slog.NewJSONHandler(stdout, options), slog.NewJSONHandler(stderr, options)
+	} else {
+		mu := &sync.Mutex{}
+		low, high = newTextHandler(stdout, options, mu), newTextHandler(stderr, options, mu)
+	}
+
 	return &Logger{
-		debugMode: debugMode,
+		slog:      slog.New(redactHandler{next: splitHandler{low: low, high: high}}),
+		debugMode: config.DebugMode,
+	}
+}
+
+// ConfigFromEnv reads LOG_LEVEL (debug, info, warn or error) and LOG_FORMAT (text or json).
+// debugMode turns on debug mode, and with it debug messages whatever LOG_LEVEL says.
+func ConfigFromEnv(debugMode bool) (Config, error) {
+	config := Config{Level: slog.LevelInfo, Format: FormatText, DebugMode: debugMode}
+
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		if err := config.Level.UnmarshalText([]byte(level)); err != nil {
+			return Config{}, fmt.Errorf("unknown LOG_LEVEL %q, use debug, info, warn or error", level)
+		}
 	}
+	if debugMode {
+		config.Level = slog.LevelDebug
+	}
+
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", FormatText:
+	case FormatJSON:
+		config.Format = FormatJSON
+	default:
+		return Config{}, fmt.Errorf("unknown LOG_FORMAT %q, use %s or %s", format, FormatText, FormatJSON)
+	}
+
+	return config, nil
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger = New(false)
+)
+
+// Default returns the logger used by code that isn't handed one, as set by SetDefault
+func Default() *Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defaultLogger
+}
+
+// SetDefault makes l the logger returned by Default and used by the slog package functions
+func SetDefault(l *Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+	slog.SetDefault(l.slog)
+}
+
+// With returns a logger that adds key-value fields to every message, e.g. With("chat_id", 42)
+func (l *Logger) With(args ...interface{}) *Logger {
+	return &Logger{slog: l.slog.With(args...), debugMode: l.debugMode}
+}
+
+// Component returns a logger for one part of the bot, tagging its messages with component=name
+func (l *Logger) Component(name string) *Logger {
+	return l.With("component", name)
+}
+
+// Slog returns the underlying slog logger
+func (l *Logger) Slog() *slog.Logger {
+	return l.slog
 }
 
 // Debug prints debug messages when debug mode is enabled
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.debugMode {
-		fmt.Printf("[DEBUG] "+format+"\n", args...)
-	}
+	l.log(slog.LevelDebug, format, args)
 }
 
 // Info prints informational messages
 func (l *Logger) Info(format string, args ...interface{}) {
-	fmt.Printf("[INFO] "+format+"\n", args...)
+	l.log(slog.LevelInfo, format, args)
+}
+
+// Warn prints messages about problems the bot recovers from
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, format, args)
 }
 
 // Error prints error messages
 func (l *Logger) Error(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "[ERROR] "+format+"\n", args...)
+	l.log(slog.LevelError, format, args)
 }
 
-// IsDebugMode returns whether debug mode is enabled
+// log formats and writes a message, skipping the formatting if the level is disabled
+func (l *Logger) log(level slog.Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if !l.slog.Enabled(ctx, level) {
+		return
+	}
+	l.slog.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// IsDebugMode returns whether debug mode was enabled with the -debug flag, independently of the log level
 func (l *Logger) IsDebugMode() bool {
 	return l.debugMode
 }
+
+// stdStream writes to the process's current stdout or stderr, so redirecting them later still takes effect
+type stdStream struct {
+	stderr bool
+}
+
+func (s stdStream) Write(p []byte) (int, error) {
+	if s.stderr {
+		return os.Stderr.Write(p)
+	}
+	return os.Stdout.Write(p)
+}
+
+// splitHandler sends errors to one handler and everything else to another
+type splitHandler struct {
+	low, high slog.Handler
+}
+
+func (h splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return h.high.Enabled(ctx, level)
+	}
+	return h.low.Enabled(ctx, level)
+}
+
+func (h splitHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		return h.high.Handle(ctx, r)
+	}
+	return h.low.Handle(ctx, r)
+}
+
+func (h splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return splitHandler{low: h.low.WithAttrs(attrs), high: h.high.WithAttrs(attrs)}
+}
+
+func (h splitHandler) WithGroup(name string) slog.Handler {
+	return splitHandler{low: h.low.WithGroup(name), high: h.high.WithGroup(name)}
+}
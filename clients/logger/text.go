@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// textHandler writes one human-readable line per message:
+//
+//	2025/10/16 20:13:24 [INFO] Checking 3 sections component=checker workers=4
+type textHandler struct {
+	w       io.Writer
+	mu      *sync.Mutex // Shared with the handler for the other stream, so lines don't interleave
+	options *slog.HandlerOptions
+	fields  string // Fields added with WithAttrs, already formatted
+	prefix  string // Group names, e.g. "request."
+}
+
+func newTextHandler(w io.Writer, options *slog.HandlerOptions, mu *sync.Mutex) *textHandler {
+	return &textHandler{w: w, mu: mu, options: options}
+}
+
+func (h *textHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	minLevel := slog.LevelInfo
+	if h.options.Level != nil {
+		minLevel = h.options.Level.Level()
+	}
+	return level >= minLevel
+}
+
+func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	if !r.Time.IsZero() {
+		b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	}
+	b.WriteString("[")
+	b.WriteString(levelName(r.Level))
+	b.WriteString("] ")
+	b.WriteString(r.Message)
+	b.WriteString(h.fields)
+	r.Attrs(func(attr slog.Attr) bool {
+		writeAttr(&b, h.prefix, attr)
+		return true
+	})
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	b.WriteString(h.fields)
+	for _, attr := range attrs {
+		writeAttr(&b, h.prefix, attr)
+	}
+
+	h2 := *h
+	h2.fields = b.String()
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "."
+	return &h2
+}
+
+// levelName names a level the way the bot always has, e.g. "INFO"
+func levelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "DEBUG"
+	case level < slog.LevelWarn:
+		return "INFO"
+	case level < slog.LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// writeAttr appends " key=value", quoting values that would be ambiguous
+func writeAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, a := range attr.Value.Group() {
+			writeAttr(b, prefix, a)
+		}
+		return
+	}
+
+	var value string
+	switch attr.Value.Kind() {
+	case slog.KindDuration:
+		value = attr.Value.Duration().Round(time.Millisecond).String()
+	case slog.KindTime:
+		value = attr.Value.Time().Format(time.RFC3339)
+	default:
+		value = attr.Value.String()
+	}
+
+	b.WriteString(" ")
+	b.WriteString(prefix)
+	b.WriteString(attr.Key)
+	b.WriteString("=")
+	if needsQuoting(value) {
+		b.WriteString(strconv.Quote(value))
+	} else {
+		b.WriteString(value)
+	}
+}
+
+// needsQuoting reports whether a value has to be quoted to be read back unambiguously
+func needsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+	for _, r := range value {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
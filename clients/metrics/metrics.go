@@ -0,0 +1,377 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family is a named group of series of one metric type
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+var defaultRegistry = NewRegistry()
+
+// Default returns the registry the bot's own metrics are registered with
+func Default() *Registry {
+	return defaultRegistry
+}
+
+// register adds a family, panicking if the name is taken, as that's a programming error
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[f.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", f.name()))
+	}
+	r.names[f.name()] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo writes all metrics in the text exposition format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := make([]family, len(r.families))
+	copy(families, r.families)
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool { return families[i].name() < families[j].name() })
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics to Prometheus scrapes
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if req.Method == http.MethodGet {
+			r.WriteTo(w)
+		}
+	})
+}
+
+// NewCounter registers a counter without labels
+func (r *Registry) NewCounter(name string, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter with one series per combination of label values
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(v)
+	return v
+}
+
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name string, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// NewGaugeVec registers a gauge with one series per combination of label values
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(v)
+	return v
+}
+
+// NewHistogram registers a histogram without labels. buckets are the upper bounds, in increasing order.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec registers a histogram with one series per combination of label values
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := make([]float64, len(buckets))
+	copy(bounds, buckets)
+	sort.Float64s(bounds)
+
+	v := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+	})}
+	r.register(v)
+	return v
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v to the counter; negative values are ignored, as counters never go down
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+func (c *Counter) writeSeries(w *bufio.Writer, name string, labels string) {
+	writeSample(w, name, labels, c.Value())
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Add adds v, which may be negative, to the gauge
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+func (g *Gauge) writeSeries(w *bufio.Writer, name string, labels string) {
+	writeSample(w, name, labels, g.Value())
+}
+
+// Histogram counts observations in buckets, e.g. request durations in seconds
+type Histogram struct {
+	bounds []float64
+
+	mu     sync.Mutex
+	counts []uint64 // Observations per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Observe adds one observation
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count returns the number of observations and their sum
+func (h *Histogram) Count() (uint64, float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count, h.sum
+}
+
+func (h *Histogram) writeSeries(w *bufio.Writer, name string, labels string) {
+	h.mu.Lock()
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	count, sum := h.count, h.sum
+	h.mu.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		writeSample(w, name+"_bucket", joinLabels(labels, `le="`+formatFloat(bound)+`"`), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", joinLabels(labels, `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ *vec[*Counter] }
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ *vec[*Gauge] }
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct{ *vec[*Histogram] }
+
+// series is what a vec needs from the metrics it holds
+type series interface {
+	writeSeries(w *bufio.Writer, name string, labels string)
+}
+
+// vec holds one metric per combination of label values
+type vec[M series] struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+	newMetric  func() M
+
+	mu      sync.Mutex
+	metrics map[string]M // By formatted labels
+}
+
+func newVec[M series](name string, help string, kind string, labels []string, newMetric func() M) *vec[M] {
+	return &vec[M]{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		newMetric:  newMetric,
+		metrics:    make(map[string]M),
+	}
+}
+
+// With returns the metric for the given label values, in the order the labels were registered
+func (v *vec[M]) With(values ...string) M {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.metricName, len(v.labels), len(values)))
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + `="` + escapeLabel(value) + `"`
+	}
+	key := strings.Join(pairs, ",")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	m, ok := v.metrics[key]
+	if !ok {
+		m = v.newMetric()
+		v.metrics[key] = m
+	}
+	return m
+}
+
+func (v *vec[M]) name() string {
+	return v.metricName
+}
+
+func (v *vec[M]) write(w *bufio.Writer) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.metrics))
+	for key := range v.metrics {
+		keys = append(keys, key)
+	}
+	metrics := make(map[string]M, len(v.metrics))
+	for key, m := range v.metrics {
+		metrics[key] = m
+	}
+	v.mu.Unlock()
+
+	sort.Strings(keys)
+
+	writeHeader(w, v.metricName, v.help, v.kind)
+	for _, key := range keys {
+		metrics[key].writeSeries(w, v.metricName, key)
+	}
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeSample writes one line like name{label="value"} 42
+func writeSample(w *bufio.Writer, name string, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{")
+		w.WriteString(labels)
+		w.WriteString("}")
+	}
+	w.WriteString(" ")
+	w.WriteString(formatFloat(value))
+	w.WriteString("\n")
+}
+
+// joinLabels appends a label pair to formatted labels
+func joinLabels(labels string, pair string) string {
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatFloat formats a value the way Prometheus expects, e.g. 0.5, 1e+06 or +Inf
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts the bytes written through it, for WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
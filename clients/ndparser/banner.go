@@ -0,0 +1,186 @@
+package ndparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"NDClasses/clients/logger"
+	"NDClasses/clients/web"
+)
+
+// DefaultBannerURL is the root of the Notre Dame StudentRegistration SSB application
+const DefaultBannerURL = "https://bxeregprod.oit.nd.edu/StudentRegistration"
+
+// ErrClassNotFound is returned when the registration site has no section with the requested CRN
+var ErrClassNotFound = errors.New("class not found")
+
+// Banner is a class parser that talks to the Banner 9 StudentRegistration JSON endpoints directly
+type Banner struct {
+	client  web.Client
+	baseURL string
+	terms   *Terms
+	logger  *logger.Logger
+
+	// mu serializes searches, since Banner keeps the search form state in the server-side session
+	mu        sync.Mutex
+	sessionID string
+	termCode  string
+}
+
+// NewBanner creates a new Banner parser for the given StudentRegistration base URL.
+// If term is set, it overrides the automatically picked current term.
+func NewBanner(logger *logger.Logger, baseURL string, term string) *Banner {
+	return &Banner{
+		client:  web.New(),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		terms:   NewTerms(baseURL, term),
+		logger:  logger,
+	}
+}
+
+// Terms returns all terms offered by the registration site
+func (b *Banner) Terms(ctx context.Context) ([]Term, error) {
+	return b.terms.List(ctx)
+}
+
+// CurrentTerm returns the term searched when no term is given
+func (b *Banner) CurrentTerm(ctx context.Context) (Term, error) {
+	return b.terms.Current(ctx)
+}
+
+// SearchClass searches for a class by CRN in the given term code, or in the current term if it's empty
+func (b *Banner) SearchClass(ctx context.Context, term string, crn string) (*Class, error) {
+	t, err := b.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class information: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	results, err := b.searchWithRetry(ctx, t.Code, SectionQuery{Keyword: crn}, 0, crnPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class information: %w", err)
+	}
+
+	return findSection(results.Data, t.Code, crn)
+}
+
+// SearchSections returns the sections matching the query in the given term code, or in the current term if it's empty
+func (b *Banner) SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error) {
+	t, err := b.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	results, err := b.searchWithRetry(ctx, t.Code, query, 0, sectionPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	return toClasses(results.Data, t.Code), nil
+}
+
+// SearchPage returns up to limit sections matching the query, starting at offset, and how many match in total
+func (b *Banner) SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error) {
+	t, err := b.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	results, err := b.searchWithRetry(ctx, t.Code, query, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	return &SectionPage{Classes: toClasses(results.Data, t.Code), Offset: offset, Total: results.TotalCount}, nil
+}
+
+// searchWithRetry runs a search, starting a new session and trying once more if Banner rejects it
+func (b *Banner) searchWithRetry(ctx context.Context, termCode string, query SectionQuery, offset int, pageSize int) (*bannerSearchResponse, error) {
+	results, err := b.search(ctx, termCode, query, offset, pageSize)
+	if err != nil {
+		// Only a rejected search means the session expired; anything else would fail again
+		if ctx.Err() != nil || !errors.Is(err, errSearchRejected) {
+			return nil, err
+		}
+		b.logger.With("term", termCode).Debug("Banner search for %+v failed, restarting session: %v", query, err)
+		b.sessionID = ""
+		return b.search(ctx, termCode, query, offset, pageSize)
+	}
+
+	return results, nil
+}
+
+// search runs a section search in the given term, starting a session for it if needed
+func (b *Banner) search(ctx context.Context, termCode string, query SectionQuery, offset int, pageSize int) (*bannerSearchResponse, error) {
+	if b.sessionID == "" || b.termCode != termCode {
+		if err := b.startSession(ctx, termCode); err != nil {
+			return nil, err
+		}
+	}
+
+	// Clear the previous search criteria stored in the session
+	if _, err := b.client.PostForm(ctx, b.baseURL+"/ssb/classSearch/resetDataForm", url.Values{}); err != nil {
+		return nil, fmt.Errorf("can't reset search form: %w", err)
+	}
+
+	q := searchResultsQuery(b.termCode, query, offset, pageSize)
+	q.Add("uniqueSessionId", b.sessionID)
+
+	data, err := b.client.Get(ctx, b.baseURL+"/ssb/searchResults/searchResults", q)
+	if err != nil {
+		return nil, fmt.Errorf("can't get search results: %w", err)
+	}
+
+	return parseSearchResults(data)
+}
+
+// startSession registers the term with a fresh cookie session
+func (b *Banner) startSession(ctx context.Context, code string) error {
+	b.client.ResetSession()
+
+	sessionID := newSessionID()
+
+	form := url.Values{}
+	form.Add("term", code)
+	form.Add("studyPath", "")
+	form.Add("studyPathText", "")
+	form.Add("startDatepicker", "")
+	form.Add("endDatepicker", "")
+	form.Add("uniqueSessionId", sessionID)
+
+	if _, err := b.client.PostForm(ctx, b.baseURL+"/ssb/term/search?mode=search", form); err != nil {
+		return fmt.Errorf("can't select term: %w", err)
+	}
+
+	b.logger.With("term", code).Debug("Started Banner session")
+	b.sessionID = sessionID
+	b.termCode = code
+
+	return nil
+}
+
+// newSessionID builds a unique session ID the same way the Banner web UI does
+func newSessionID() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	prefix := make([]byte, 5)
+	for i := range prefix {
+		prefix[i] = letters[rand.Intn(len(letters))]
+	}
+
+	return fmt.Sprintf("%s%d", prefix, time.Now().UnixMilli())
+}
@@ -0,0 +1,185 @@
+package ndparser
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeTerm is the current term of a fake class source unless SetTerms is called
+var FakeTerm = Term{Code: "202510", Description: "Fall Semester 2025"}
+
+// errNoTerms is returned by a fake whose terms were all removed with SetTerms
+var errNoTerms = errors.New("no terms")
+
+// Fake is an in-memory class source for tests and local development
+type Fake struct {
+	mu      sync.Mutex
+	terms   []Term
+	classes map[string]Class
+	errors  map[string]error
+	calls   map[string]int
+}
+
+// NewFake creates a new fake class source that knows about the given classes.
+// Classes without a term belong to the current term.
+func NewFake(classes ...Class) *Fake {
+	f := &Fake{
+		terms:   []Term{FakeTerm},
+		classes: make(map[string]Class),
+		errors:  make(map[string]error),
+		calls:   make(map[string]int),
+	}
+
+	for _, class := range classes {
+		f.setLocked(class)
+	}
+
+	return f
+}
+
+// Terms returns the terms known to the fake
+func (f *Fake) Terms(ctx context.Context) ([]Term, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Term(nil), f.terms...), nil
+}
+
+// CurrentTerm returns the first term known to the fake
+func (f *Fake) CurrentTerm(ctx context.Context) (Term, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.currentLocked()
+}
+
+// currentLocked returns the current term, or errNoTerms if the fake has none
+func (f *Fake) currentLocked() (Term, error) {
+	if len(f.terms) == 0 {
+		return Term{}, errNoTerms
+	}
+	return f.terms[0], nil
+}
+
+// SearchClass searches for a class by CRN in the given term code, or in the current term if it's empty
+func (f *Fake) SearchClass(ctx context.Context, term string, crn string) (*Class, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls[crn]++
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err, ok := f.errors[crn]; ok {
+		return nil, err
+	}
+
+	if term == "" {
+		current, err := f.currentLocked()
+		if err != nil {
+			return nil, err
+		}
+		term = current.Code
+	}
+
+	class, ok := f.classes[term+"/"+crn]
+	if !ok {
+		return nil, fmt.Errorf("CRN %s: %w", crn, ErrClassNotFound)
+	}
+
+	return &class, nil
+}
+
+// SearchSections returns the classes matching the query in the given term code, or in the current term if it's empty
+func (f *Fake) SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if term == "" {
+		current, err := f.currentLocked()
+		if err != nil {
+			return nil, err
+		}
+		term = current.Code
+	}
+
+	var classes []Class
+	for _, class := range f.classes {
+		if class.Term == term && query.matches(class) {
+			classes = append(classes, class)
+		}
+	}
+
+	sort.Slice(classes, func(i, j int) bool { return classes[i].CRN < classes[j].CRN })
+
+	return classes, nil
+}
+
+// SearchPage returns up to limit classes matching the query, starting at offset, and how many match in total
+func (f *Fake) SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error) {
+	classes, err := f.SearchSections(ctx, term, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &SectionPage{Offset: offset, Total: len(classes)}
+	if offset < len(classes) {
+		page.Classes = classes[offset:min(offset+limit, len(classes))]
+	}
+
+	return page, nil
+}
+
+// SetTerms replaces the terms known to the fake; the first one becomes the current term.
+// Without terms, lookups of the current term fail.
+func (f *Fake) SetTerms(terms ...Term) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.terms = terms
+}
+
+// Set adds or replaces a class
+func (f *Fake) Set(class Class) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.setLocked(class)
+}
+
+// setLocked stores a class under its term, defaulting to the current term if there is one
+func (f *Fake) setLocked(class Class) {
+	if class.Term == "" && len(f.terms) > 0 {
+		class.Term = f.terms[0].Code
+	}
+	f.classes[class.Term+"/"+class.CRN] = class
+}
+
+// SetError makes every search for the CRN fail with err, or clears the failure when err is nil
+func (f *Fake) SetError(crn string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, crn)
+		return
+	}
+	f.errors[crn] = err
+}
+
+// Calls returns how many times the CRN has been searched for
+func (f *Fake) Calls(crn string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls[crn]
+}
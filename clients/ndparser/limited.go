@@ -0,0 +1,202 @@
+package ndparser
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimiterStats describes the searches waiting for and holding a slot in a Limited source
+type LimiterStats struct {
+	Queued   int64 `json:"queued"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// Limited wraps a class source with a concurrency cap and a requests-per-minute limit.
+// Searches over the limits wait in line until a slot is free or their context is done.
+type Limited struct {
+	source   ClassSource
+	slots    chan struct{}
+	interval time.Duration
+
+	mu    sync.Mutex
+	next  time.Time   // Earliest start of the next search
+	freed []time.Time // Start times given back by cancelled searches, in order
+
+	queued   atomic.Int64
+	inFlight atomic.Int64
+}
+
+// NewLimited creates a class source that runs at most concurrency searches at once
+// and starts at most perMinute searches a minute. A perMinute of 0 disables the rate limit.
+func NewLimited(source ClassSource, concurrency int, perMinute int) *Limited {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	l := &Limited{
+		source: source,
+		slots:  make(chan struct{}, concurrency),
+	}
+	if perMinute > 0 {
+		l.interval = time.Minute / time.Duration(perMinute)
+	}
+
+	return l
+}
+
+// SearchClass searches for a class by CRN once a slot is available
+func (l *Limited) SearchClass(ctx context.Context, term string, crn string) (*Class, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return l.source.SearchClass(ctx, term, crn)
+}
+
+// SearchSections searches for sections once a slot is available
+func (l *Limited) SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return l.source.SearchSections(ctx, term, query)
+}
+
+// SearchPage searches for a page of sections once a slot is available
+func (l *Limited) SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error) {
+	release, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return l.source.SearchPage(ctx, term, query, offset, limit)
+}
+
+// acquire waits for a free slot and for the search's turn under the rate limit.
+// The returned function gives the slot back.
+func (l *Limited) acquire(ctx context.Context) (func(), error) {
+	l.queued.Add(1)
+
+	// Wait for a free slot
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.queued.Add(-1)
+		return nil, ctx.Err()
+	}
+
+	// Wait for our turn under the rate limit
+	if err := l.wait(ctx); err != nil {
+		l.queued.Add(-1)
+		<-l.slots
+		return nil, err
+	}
+
+	l.queued.Add(-1)
+	l.inFlight.Add(1)
+
+	return func() {
+		l.inFlight.Add(-1)
+		<-l.slots
+	}, nil
+}
+
+// Terms returns all terms offered by the registration site.
+// Term lookups skip the limiter, as the sources cache the terms and rarely ask the site for them.
+func (l *Limited) Terms(ctx context.Context) ([]Term, error) {
+	return l.source.Terms(ctx)
+}
+
+// CurrentTerm returns the term searched when no term is given, skipping the limiter like Terms
+func (l *Limited) CurrentTerm(ctx context.Context) (Term, error) {
+	return l.source.CurrentTerm(ctx)
+}
+
+// Stats returns the current queue depth and number of searches in flight
+func (l *Limited) Stats() LimiterStats {
+	return LimiterStats{
+		Queued:   l.queued.Load(),
+		InFlight: l.inFlight.Load(),
+	}
+}
+
+// SuccessRate returns the success rate of the wrapped source's latest searches if it keeps track of them,
+// and 1 from no searches otherwise
+func (l *Limited) SuccessRate() (float64, int) {
+	if rater, ok := l.source.(SuccessRater); ok {
+		return rater.SuccessRate()
+	}
+	return 1, 0
+}
+
+// wait reserves the next start time under the rate limit and sleeps until it comes.
+// If ctx is done first, the start time is given back for another search to use.
+func (l *Limited) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+
+	start := l.reserve()
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.giveBack(start)
+		return ctx.Err()
+	}
+}
+
+// reserve picks a start time under the rate limit, preferring one given back by a cancelled search
+func (l *Limited) reserve() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for len(l.freed) > 0 {
+		start := l.freed[0]
+		l.freed = l.freed[1:]
+		if !start.Before(now) {
+			return start
+		}
+		// Start times that went by unused are lost, so searches stay spaced out
+	}
+
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	return start
+}
+
+// giveBack returns an unused start time, so cancelled searches don't use up the rate limit
+func (l *Limited) giveBack(start time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The latest reservation simply moves the next start back
+	if l.next.Equal(start.Add(l.interval)) {
+		l.next = start
+		return
+	}
+
+	i := sort.Search(len(l.freed), func(i int) bool { return l.freed[i].After(start) })
+	l.freed = slices.Insert(l.freed, i, start)
+}
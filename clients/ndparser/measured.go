@@ -0,0 +1,138 @@
+package ndparser
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"NDClasses/clients/metrics"
+)
+
+// Outcomes of a search, as reported in metrics
+const (
+	outcomeOK       = "ok"
+	outcomeNotFound = "not_found"
+	outcomeCanceled = "canceled"
+	outcomeError    = "error"
+)
+
+var (
+	searchDuration = metrics.Default().NewHistogramVec(
+		"ndclasses_parser_search_duration_seconds",
+		"Time taken by searches on the registration site, by backend and operation.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+		"backend", "operation",
+	)
+	searchesTotal = metrics.Default().NewCounterVec(
+		"ndclasses_parser_searches_total",
+		"Searches on the registration site, by backend, operation and outcome.",
+		"backend", "operation", "outcome",
+	)
+)
+
+// recentSearches is how many of the latest searches SuccessRate looks at
+const recentSearches = 50
+
+// Measured wraps a class source, recording how long its searches take and how they end.
+// It sits below Limited, so time spent waiting for a slot isn't counted.
+type Measured struct {
+	source  ClassSource
+	backend string
+
+	// Whether each of the latest searches succeeded, as a ring buffer
+	mu     sync.Mutex
+	recent [recentSearches]bool
+	next   int
+	filled int
+}
+
+// NewMeasured creates a class source that records metrics for the given backend name
+func NewMeasured(source ClassSource, backend string) *Measured {
+	return &Measured{source: source, backend: backend}
+}
+
+// SearchClass searches for a class by CRN
+func (m *Measured) SearchClass(ctx context.Context, term string, crn string) (*Class, error) {
+	start := time.Now()
+	class, err := m.source.SearchClass(ctx, term, crn)
+	m.record("search_class", start, err)
+	return class, err
+}
+
+// SearchSections searches for sections matching the query
+func (m *Measured) SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error) {
+	start := time.Now()
+	classes, err := m.source.SearchSections(ctx, term, query)
+	m.record("search_sections", start, err)
+	return classes, err
+}
+
+// SearchPage searches for a page of sections matching the query
+func (m *Measured) SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error) {
+	start := time.Now()
+	page, err := m.source.SearchPage(ctx, term, query, offset, limit)
+	m.record("search_page", start, err)
+	return page, err
+}
+
+// Terms returns all terms offered by the registration site
+func (m *Measured) Terms(ctx context.Context) ([]Term, error) {
+	return m.source.Terms(ctx)
+}
+
+// CurrentTerm returns the term searched when no term is given
+func (m *Measured) CurrentTerm(ctx context.Context) (Term, error) {
+	return m.source.CurrentTerm(ctx)
+}
+
+// record adds the duration and outcome of an operation started at start
+func (m *Measured) record(operation string, start time.Time, err error) {
+	result := outcome(err)
+	searchDuration.With(m.backend, operation).Observe(time.Since(start).Seconds())
+	searchesTotal.With(m.backend, operation, result).Inc()
+
+	// Cancelled searches say nothing about the site
+	if result == outcomeCanceled {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recent[m.next] = result != outcomeError
+	m.next = (m.next + 1) % recentSearches
+	m.filled = min(m.filled+1, recentSearches)
+}
+
+// SuccessRate returns the share of the latest searches that succeeded, and how many searches that is.
+// Searches for sections that don't exist succeed; cancelled ones aren't counted. It's 1 before any search.
+func (m *Measured) SuccessRate() (float64, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.filled == 0 {
+		return 1, 0
+	}
+
+	succeeded := 0
+	for _, ok := range m.recent[:m.filled] {
+		if ok {
+			succeeded++
+		}
+	}
+	return float64(succeeded) / float64(m.filled), m.filled
+}
+
+// outcome classifies the result of a search
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, ErrClassNotFound):
+		return outcomeNotFound
+	case errors.Is(err, context.Canceled):
+		return outcomeCanceled
+	default:
+		return outcomeError
+	}
+}
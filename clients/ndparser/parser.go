@@ -3,32 +3,95 @@ package ndparser
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"NDClasses/clients/logger"
 	"NDClasses/clients/web"
 
+	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 )
 
 // Parser represents a parser for ND class information
 type Parser struct {
 	client  web.Client
+	baseURL string
 	timeout time.Duration
 	logger  *logger.Logger
+	terms   *Terms
 }
 
-// New creates a new ND class parser
-func New(logger *logger.Logger) Parser {
+// New creates a new ND class parser for the given StudentRegistration base URL.
+// If term is set, it overrides the automatically picked current term.
+func New(logger *logger.Logger, baseURL string, term string) Parser {
 	return Parser{
 		client:  web.New(),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
 		timeout: 30 * time.Second, // Default timeout of 30 seconds
 		logger:  logger,
+		terms:   NewTerms(baseURL, term),
 	}
 }
 
-// SearchClass searches for a class by CRN
-func (p *Parser) SearchClass(ctx context.Context, crn string) (*Class, error) {
+// Terms returns all terms offered by the registration site
+func (p *Parser) Terms(ctx context.Context) ([]Term, error) {
+	return p.terms.List(ctx)
+}
+
+// CurrentTerm returns the term searched when no term is given
+func (p *Parser) CurrentTerm(ctx context.Context) (Term, error) {
+	return p.terms.Current(ctx)
+}
+
+// SearchClass searches for a class by CRN in the given term code, or in the current term if it's empty
+func (p *Parser) SearchClass(ctx context.Context, term string, crn string) (*Class, error) {
+	// The term picker is filled in by description, so resolve the code first
+	t, err := p.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class information: %w", err)
+	}
+
+	results, err := p.search(ctx, t, SectionQuery{Keyword: crn}, 0, crnPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse class information: %w", err)
+	}
+
+	return findSection(results.Data, t.Code, crn)
+}
+
+// SearchSections returns the sections matching the query in the given term code, or in the current term if it's empty
+func (p *Parser) SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error) {
+	t, err := p.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	results, err := p.search(ctx, t, query, 0, sectionPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	return toClasses(results.Data, t.Code), nil
+}
+
+// SearchPage returns up to limit sections matching the query, starting at offset, and how many match in total
+func (p *Parser) SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error) {
+	t, err := p.terms.Lookup(ctx, term)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	results, err := p.search(ctx, t, query, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search sections: %w", err)
+	}
+
+	return &SectionPage{Classes: toClasses(results.Data, t.Code), Offset: offset, Total: results.TotalCount}, nil
+}
+
+// search walks through the registration site in a browser and returns the search results for the query
+func (p *Parser) search(ctx context.Context, t Term, query SectionQuery, offset int, pageSize int) (*bannerSearchResponse, error) {
 	headless := !p.logger.IsDebugMode() // Headless in normal mode, visible in debug mode
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", headless),                                                // Show browser in debug mode
@@ -59,11 +122,15 @@ func (p *Parser) SearchClass(ctx context.Context, crn string) (*Class, error) {
 	defer cancel()
 
 	// Navigate to the term selection page
-	termURL := "https://bxeregprod.oit.nd.edu/StudentRegistration/ssb/term/termSelection?mode=search"
+	termURL := p.baseURL + "/ssb/term/termSelection?mode=search"
+
+	// The results grid is filled from the searchResults JSON, so fetch the same
+	// JSON inside the page's session instead of scraping the rendered cells
+	resultsURL := p.baseURL + "/ssb/searchResults/searchResults?" + searchResultsQuery(t.Code, query, offset, pageSize).Encode()
+	fetchResults := fmt.Sprintf(`fetch(%q, {headers: {"X-Requested-With": "XMLHttpRequest"}}).then(r => r.text())`, resultsURL)
 
 	// Execute the chromedp tasks
-	var class Class
-	var seatsStr string
+	var results string
 
 	p.logger.Debug("Before chromedp.Run, ctx is done: %v", ctx.Err() != nil)
 	err := chromedp.Run(ctx,
@@ -79,8 +146,8 @@ func (p *Parser) SearchClass(ctx context.Context, crn string) (*Class, error) {
 		// Wait for the term selection input to be ready
 		chromedp.Sleep(2*time.Second),
 
-		// Fill in "Fall Semester 2025" in the term selection field
-		chromedp.SendKeys(`#s2id_autogen1_search`, "Fall Semester 2025", chromedp.ByID),
+		// Fill in the term in the term selection field
+		chromedp.SendKeys(`#s2id_autogen1_search`, t.Description, chromedp.ByID),
 		chromedp.Sleep(1*time.Second),
 		chromedp.SendKeys(`#s2id_autogen1_search`, "\n", chromedp.ByID),
 
@@ -90,8 +157,8 @@ func (p *Parser) SearchClass(ctx context.Context, crn string) (*Class, error) {
 		// Wait for the keyword input to be ready
 		chromedp.WaitVisible(`#txt_keywordlike`, chromedp.ByID),
 
-		// Fill in the CRN in the keyword field
-		chromedp.SendKeys(`#txt_keywordlike`, crn, chromedp.ByID),
+		// Fill in the keyword field
+		chromedp.SendKeys(`#txt_keywordlike`, query.Keyword, chromedp.ByID),
 
 		// Wait a bit and click the search button again
 		chromedp.Sleep(1*time.Second),
@@ -101,19 +168,15 @@ func (p *Parser) SearchClass(ctx context.Context, crn string) (*Class, error) {
 		chromedp.Sleep(3*time.Second),
 
 		// Extract class information
-		chromedp.Text(`[data-content="Title"]`, &class.Title, chromedp.ByQuery),
-		chromedp.Text(`[data-content="Status"]`, &seatsStr, chromedp.ByQuery),
+		chromedp.Evaluate(fetchResults, &results, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}),
 	)
 	p.logger.Debug("After chromedp.Run, ctx is done: %v, err: %v", ctx.Err() != nil, err)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse class information: %w", err)
+		return nil, err
 	}
 
-	// Convert string values to integers
-	fmt.Sscanf(seatsStr, "%d", &class.Seats)
-
-	class.CRN = crn
-
-	return &class, nil
+	return parseSearchResults([]byte(results))
 }
@@ -0,0 +1,161 @@
+package ndparser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of searchResults requests; a CRN matches a handful of sections at most,
+// while a course can have dozens
+const (
+	crnPageSize     = 10
+	sectionPageSize = 100
+)
+
+// searchResultsQuery builds the searchResults query for a section search in a term
+func searchResultsQuery(termCode string, query SectionQuery, offset int, pageSize int) url.Values {
+	q := url.Values{}
+	q.Add("txt_keywordlike", query.Keyword)
+	if query.Subject != "" {
+		q.Add("txt_subject", strings.ToUpper(query.Subject))
+	}
+	if query.CourseNumber != "" {
+		q.Add("txt_courseNumber", query.CourseNumber)
+	}
+	q.Add("txt_term", termCode)
+	q.Add("startDatepicker", "")
+	q.Add("endDatepicker", "")
+	q.Add("pageOffset", strconv.Itoa(offset))
+	q.Add("pageMaxSize", strconv.Itoa(pageSize))
+	q.Add("sortColumn", "subjectDescription")
+	q.Add("sortDirection", "asc")
+	return q
+}
+
+// errSearchRejected is returned when Banner refuses a search, which it does once the session has expired
+var errSearchRejected = errors.New("search was rejected by the registration site")
+
+// parseSearchResults decodes a searchResults response
+func parseSearchResults(data []byte) (*bannerSearchResponse, error) {
+	var resp bannerSearchResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("can't parse json: %w", err)
+	}
+
+	if !resp.Success {
+		return nil, errSearchRejected
+	}
+
+	return &resp, nil
+}
+
+// findSection picks the section with the CRN out of search results
+func findSection(sections []bannerSection, term string, crn string) (*Class, error) {
+	for _, section := range sections {
+		if section.CRN == crn {
+			class := section.toClass()
+			class.Term = term
+			return &class, nil
+		}
+	}
+
+	return nil, fmt.Errorf("CRN %s: %w", crn, ErrClassNotFound)
+}
+
+// toClasses converts search results into classes of the term
+func toClasses(sections []bannerSection, term string) []Class {
+	classes := make([]Class, 0, len(sections))
+	for _, section := range sections {
+		class := section.toClass()
+		class.Term = term
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// toClass converts a Banner search result into a Class
+func (s bannerSection) toClass() Class {
+	class := Class{
+		CRN:              s.CRN,
+		Term:             s.Term,
+		Subject:          s.Subject,
+		CourseNumber:     s.CourseNumber,
+		Section:          s.SequenceNumber,
+		Title:            s.CourseTitle,
+		Seats:            s.SeatsAvailable,
+		Enrollment:       s.Enrollment,
+		Capacity:         s.MaximumEnrollment,
+		WaitlistCapacity: s.WaitCapacity,
+		WaitlistCount:    s.WaitCount,
+		Campus:           s.Campus,
+		ScheduleType:     s.ScheduleType,
+	}
+
+	// Variable credit sections only report the lower bound
+	if s.CreditHours != nil {
+		class.CreditHours = *s.CreditHours
+	} else if s.CreditHourLow != nil {
+		class.CreditHours = *s.CreditHourLow
+	}
+
+	// List the primary instructor first
+	for _, faculty := range s.Faculty {
+		if faculty.PrimaryIndicator {
+			class.Instructors = append([]string{faculty.DisplayName}, class.Instructors...)
+		} else {
+			class.Instructors = append(class.Instructors, faculty.DisplayName)
+		}
+	}
+
+	for _, meeting := range s.MeetingsFaculty {
+		class.Meetings = append(class.Meetings, meeting.MeetingTime.toMeeting())
+	}
+
+	return class
+}
+
+// toMeeting converts a Banner meeting time into a Meeting
+func (t bannerMeetingTime) toMeeting() Meeting {
+	days := ""
+	for _, day := range []struct {
+		on     bool
+		letter string
+	}{
+		{t.Monday, "M"},
+		{t.Tuesday, "T"},
+		{t.Wednesday, "W"},
+		{t.Thursday, "R"},
+		{t.Friday, "F"},
+		{t.Saturday, "S"},
+		{t.Sunday, "U"},
+	} {
+		if day.on {
+			days += day.letter
+		}
+	}
+
+	building := t.BuildingDescription
+	if building == "" {
+		building = t.Building
+	}
+
+	return Meeting{
+		Days:      days,
+		BeginTime: formatBannerTime(t.BeginTime),
+		EndTime:   formatBannerTime(t.EndTime),
+		Building:  building,
+		Room:      t.Room,
+	}
+}
+
+// formatBannerTime turns Banner's "0930" into "09:30"
+func formatBannerTime(value string) string {
+	if len(value) != 4 {
+		return value
+	}
+	return value[:2] + ":" + value[2:]
+}
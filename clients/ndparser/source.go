@@ -0,0 +1,99 @@
+package ndparser
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"NDClasses/clients/logger"
+)
+
+// Backend names accepted in the PARSER_BACKEND environment variable
+const (
+	BackendBrowser = "browser"
+	BackendBanner  = "banner"
+)
+
+// ClassSource looks up class information by CRN or course
+type ClassSource interface {
+	// SearchClass searches for a class by CRN in the given term code, or in the current term if it's empty
+	SearchClass(ctx context.Context, term string, crn string) (*Class, error)
+
+	// SearchSections returns the sections matching the query in the given term code, or in the current term if it's empty
+	SearchSections(ctx context.Context, term string, query SectionQuery) ([]Class, error)
+
+	// SearchPage returns up to limit sections matching the query, starting at offset, and how many match in total
+	SearchPage(ctx context.Context, term string, query SectionQuery, offset int, limit int) (*SectionPage, error)
+
+	// Terms returns all terms offered by the registration site
+	Terms(ctx context.Context) ([]Term, error)
+
+	// CurrentTerm returns the term searched when no term is given
+	CurrentTerm(ctx context.Context) (Term, error)
+}
+
+// SuccessRater is implemented by sources that keep track of how their latest searches went
+type SuccessRater interface {
+	// SuccessRate returns the share of the latest searches that succeeded, and how many searches that is
+	SuccessRate() (float64, int)
+}
+
+// QueueReporter is implemented by sources that make searches wait in line
+type QueueReporter interface {
+	// Stats returns the current queue depth and number of searches in flight
+	Stats() LimiterStats
+}
+
+var (
+	_ SuccessRater  = (*Measured)(nil)
+	_ SuccessRater  = (*Limited)(nil)
+	_ QueueReporter = (*Limited)(nil)
+)
+
+var (
+	_ ClassSource = (*Parser)(nil)
+	_ ClassSource = (*Banner)(nil)
+	_ ClassSource = (*Fake)(nil)
+	_ ClassSource = (*Limited)(nil)
+	_ ClassSource = (*Measured)(nil)
+)
+
+// Default limits toward the registration site, shared by everything using one source
+const (
+	defaultConcurrency = 2
+	defaultPerMinute   = 30
+)
+
+// NewSource creates the class source selected by PARSER_BACKEND for the registration site at BANNER_URL,
+// limited by PARSER_CONCURRENCY parallel searches and PARSER_RATE_PER_MINUTE searches a minute.
+// Its searches are measured in the ndclasses_parser_* metrics.
+// The term override is taken from the argument or, if it's empty, from ACADEMIC_TERM.
+func NewSource(logger *logger.Logger, term string) *Limited {
+	if term == "" {
+		term = os.Getenv("ACADEMIC_TERM")
+	}
+
+	concurrency := envInt("PARSER_CONCURRENCY", defaultConcurrency)
+	perMinute := envInt("PARSER_RATE_PER_MINUTE", defaultPerMinute)
+
+	baseURL := os.Getenv("BANNER_URL")
+	if baseURL == "" {
+		baseURL = DefaultBannerURL
+	}
+
+	if os.Getenv("PARSER_BACKEND") == BackendBanner {
+		return NewLimited(NewMeasured(NewBanner(logger, baseURL, term), BackendBanner), concurrency, perMinute)
+	}
+
+	parser := New(logger, baseURL, term)
+	return NewLimited(NewMeasured(&parser, BackendBrowser), concurrency, perMinute)
+}
+
+// envInt reads a non-negative integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < 0 {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,195 @@
+package ndparser
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"NDClasses/clients/web"
+)
+
+// termLeadTime is how long before a term starts it becomes the current registration term
+const termLeadTime = 150 * 24 * time.Hour
+
+// termCacheTTL is how long the list of terms is reused before asking the registration site again
+const termCacheTTL = time.Hour
+
+// termPattern extracts the season and year from a term description like "Fall Semester 2025"
+var termPattern = regexp.MustCompile(`(?i)\b(spring|summer|fall)\b.*\b(\d{4})\b`)
+
+// Terms lists the academic terms offered by the registration site and picks the one to search
+type Terms struct {
+	client   web.Client
+	baseURL  string
+	override string
+
+	mu        sync.Mutex
+	cached    []Term
+	fetchedAt time.Time
+}
+
+// NewTerms creates a new term list for the given StudentRegistration base URL.
+// If override is set, it is used as the current term instead of picking one automatically.
+func NewTerms(baseURL string, override string) *Terms {
+	return &Terms{
+		client:   web.New(),
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		override: override,
+	}
+}
+
+// List returns all terms offered by the registration site, newest first.
+// Callers arriving while the list is being refreshed wait for that request rather than sending their own.
+func (t *Terms) List(ctx context.Context) ([]Term, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cached != nil && time.Since(t.fetchedAt) < termCacheTTL {
+		return t.cached, nil
+	}
+
+	q := url.Values{}
+	q.Add("searchTerm", "")
+	q.Add("offset", "1")
+	q.Add("max", "100")
+
+	data, err := t.client.Get(ctx, t.baseURL+"/ssb/classSearch/getTerms", q)
+	if err != nil {
+		return nil, fmt.Errorf("can't get terms: %w", err)
+	}
+
+	var terms []Term
+	if err := json.Unmarshal(data, &terms); err != nil {
+		return nil, fmt.Errorf("can't parse json: %w", err)
+	}
+
+	t.cached = terms
+	t.fetchedAt = time.Now()
+
+	return terms, nil
+}
+
+// Current returns the configured term override or the current registration term
+func (t *Terms) Current(ctx context.Context) (Term, error) {
+	terms, err := t.List(ctx)
+	if err != nil {
+		return Term{}, err
+	}
+
+	if t.override != "" {
+		term, ok := FindTerm(terms, t.override)
+		if !ok {
+			return Term{}, fmt.Errorf("term %q is not offered by the registration site", t.override)
+		}
+		return term, nil
+	}
+
+	return PickTerm(terms, time.Now())
+}
+
+// Lookup resolves a term code, falling back to the current term when code is empty
+func (t *Terms) Lookup(ctx context.Context, code string) (Term, error) {
+	if code == "" {
+		return t.Current(ctx)
+	}
+
+	terms, err := t.List(ctx)
+	if err != nil {
+		return Term{}, err
+	}
+
+	for _, term := range terms {
+		if term.Code == code {
+			return term, nil
+		}
+	}
+
+	return Term{}, fmt.Errorf("term %q is not offered by the registration site", code)
+}
+
+// FindTerm finds a term by its code or by a case-insensitive part of its description
+func FindTerm(terms []Term, query string) (Term, bool) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return Term{}, false
+	}
+
+	for _, term := range terms {
+		if term.Code == query {
+			return term, true
+		}
+	}
+
+	for _, term := range terms {
+		if strings.Contains(strings.ToLower(term.Description), strings.ToLower(query)) {
+			return term, true
+		}
+	}
+
+	return Term{}, false
+}
+
+// PickTerm picks the term students are registering for at the given time.
+// It is the latest semester that starts within termLeadTime; view-only terms and
+// summer sessions are skipped unless nothing else is available.
+func PickTerm(terms []Term, now time.Time) (Term, error) {
+	if len(terms) == 0 {
+		return Term{}, fmt.Errorf("no terms offered by the registration site")
+	}
+
+	var best Term
+	var bestStart time.Time
+	for _, term := range terms {
+		if strings.Contains(strings.ToLower(term.Description), "view only") {
+			continue
+		}
+
+		start, ok := termStart(term.Description)
+		if !ok || start.Month() == time.June {
+			continue
+		}
+
+		if now.Add(termLeadTime).Before(start) {
+			continue
+		}
+
+		if best.Code == "" || start.After(bestStart) {
+			best = term
+			bestStart = start
+		}
+	}
+
+	if best.Code == "" {
+		return terms[0], nil
+	}
+
+	return best, nil
+}
+
+// termStart approximates the first day of classes from a term description
+func termStart(description string) (time.Time, bool) {
+	match := termPattern.FindStringSubmatch(description)
+	if match == nil {
+		return time.Time{}, false
+	}
+
+	year, err := strconv.Atoi(match[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	switch strings.ToLower(match[1]) {
+	case "spring":
+		return time.Date(year, time.January, 15, 0, 0, 0, 0, time.UTC), true
+	case "summer":
+		return time.Date(year, time.June, 1, 0, 0, 0, 0, time.UTC), true
+	default:
+		return time.Date(year, time.August, 20, 0, 0, 0, 0, time.UTC), true
+	}
+}
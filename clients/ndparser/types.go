@@ -1,8 +1,148 @@
 package ndparser
 
+import (
+	"fmt"
+	"strings"
+)
+
 // Class represents information about a class
 type Class struct {
-	CRN   string `json:"crn"`
-	Title string `json:"title"`
-	Seats int    `json:"seats"`
+	CRN              string    `json:"crn"`
+	Term             string    `json:"term"`
+	Subject          string    `json:"subject"`
+	CourseNumber     string    `json:"course_number"`
+	Section          string    `json:"section"`
+	Title            string    `json:"title"`
+	Instructors      []string  `json:"instructors"`
+	Meetings         []Meeting `json:"meetings"`
+	CreditHours      float64   `json:"credit_hours"`
+	Seats            int       `json:"seats"`
+	Enrollment       int       `json:"enrollment"`
+	Capacity         int       `json:"capacity"`
+	WaitlistCapacity int       `json:"waitlist_capacity"`
+	WaitlistCount    int       `json:"waitlist_count"`
+	Campus           string    `json:"campus"`
+	ScheduleType     string    `json:"schedule_type"`
+}
+
+// Course returns the course code with the section, e.g. "CSE 20311-01"
+func (c Class) Course() string {
+	code := strings.TrimSpace(c.Subject + " " + c.CourseNumber)
+	if c.Section != "" {
+		code += "-" + c.Section
+	}
+	return code
+}
+
+// Meeting represents a weekly meeting of a class
+type Meeting struct {
+	Days      string `json:"days"`       // Day letters, e.g. "MWF" (R is Thursday, U is Sunday)
+	BeginTime string `json:"begin_time"` // 24-hour "HH:MM"
+	EndTime   string `json:"end_time"`   // 24-hour "HH:MM"
+	Building  string `json:"building"`
+	Room      string `json:"room"`
+}
+
+// String formats the meeting as "MWF 09:30-10:20, DeBartolo Hall 102"
+func (m Meeting) String() string {
+	when := "TBA"
+	if m.Days != "" && m.BeginTime != "" {
+		when = fmt.Sprintf("%s %s-%s", m.Days, m.BeginTime, m.EndTime)
+	}
+
+	where := strings.TrimSpace(m.Building + " " + m.Room)
+	if where == "" {
+		return when
+	}
+	return when + ", " + where
+}
+
+// Term represents an academic term offered by the registration site
+type Term struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// SectionQuery selects the sections returned by a section search.
+// Empty fields don't restrict the search.
+type SectionQuery struct {
+	Subject      string `json:"subject"`       // Subject code, e.g. "CSE"
+	CourseNumber string `json:"course_number"` // Course number, e.g. "20311"
+	Keyword      string `json:"keyword"`       // Matched against CRNs and titles
+}
+
+// SectionPage is one page of section search results
+type SectionPage struct {
+	Classes []Class `json:"classes"`
+	Offset  int     `json:"offset"` // Position of the first class among all results
+	Total   int     `json:"total"`  // Number of sections matching the query
+}
+
+// matches reports whether a class is selected by the query, roughly like the registration site does
+func (q SectionQuery) matches(class Class) bool {
+	if q.Subject != "" && !strings.EqualFold(q.Subject, class.Subject) {
+		return false
+	}
+	if q.CourseNumber != "" && q.CourseNumber != class.CourseNumber {
+		return false
+	}
+	if q.Keyword != "" && class.CRN != q.Keyword && !strings.Contains(strings.ToLower(class.Title), strings.ToLower(q.Keyword)) {
+		return false
+	}
+	return true
+}
+
+// bannerSearchResponse represents a response from the Banner searchResults endpoint
+type bannerSearchResponse struct {
+	Success    bool            `json:"success"`
+	TotalCount int             `json:"totalCount"`
+	Data       []bannerSection `json:"data"`
+}
+
+// bannerSection represents a single section in Banner search results
+type bannerSection struct {
+	CRN               string          `json:"courseReferenceNumber"`
+	Term              string          `json:"term"`
+	Subject           string          `json:"subject"`
+	CourseNumber      string          `json:"courseNumber"`
+	SequenceNumber    string          `json:"sequenceNumber"`
+	CourseTitle       string          `json:"courseTitle"`
+	CreditHours       *float64        `json:"creditHours"`
+	CreditHourLow     *float64        `json:"creditHourLow"`
+	MaximumEnrollment int             `json:"maximumEnrollment"`
+	Enrollment        int             `json:"enrollment"`
+	SeatsAvailable    int             `json:"seatsAvailable"`
+	WaitCapacity      int             `json:"waitCapacity"`
+	WaitCount         int             `json:"waitCount"`
+	Campus            string          `json:"campusDescription"`
+	ScheduleType      string          `json:"scheduleTypeDescription"`
+	Faculty           []bannerFaculty `json:"faculty"`
+	MeetingsFaculty   []bannerMeeting `json:"meetingsFaculty"`
+}
+
+// bannerFaculty represents an instructor of a Banner section
+type bannerFaculty struct {
+	DisplayName      string `json:"displayName"`
+	PrimaryIndicator bool   `json:"primaryIndicator"`
+}
+
+// bannerMeeting represents a meeting of a Banner section
+type bannerMeeting struct {
+	MeetingTime bannerMeetingTime `json:"meetingTime"`
+}
+
+// bannerMeetingTime represents when and where a Banner section meets
+type bannerMeetingTime struct {
+	BeginTime           string `json:"beginTime"`
+	EndTime             string `json:"endTime"`
+	Building            string `json:"building"`
+	BuildingDescription string `json:"buildingDescription"`
+	Room                string `json:"room"`
+	Monday              bool   `json:"monday"`
+	Tuesday             bool   `json:"tuesday"`
+	Wednesday           bool   `json:"wednesday"`
+	Thursday            bool   `json:"thursday"`
+	Friday              bool   `json:"friday"`
+	Saturday            bool   `json:"saturday"`
+	Sunday              bool   `json:"sunday"`
 }
@@ -0,0 +1,329 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"NDClasses/clients/database"
+	"NDClasses/clients/logger"
+	"NDClasses/clients/ndparser"
+)
+
+// CheckerControl is what the admin commands need from the background checker.
+// It's implemented by checker.Checker, which can't be imported here as it imports this package.
+type CheckerControl interface {
+	CheckNow(ctx context.Context) error
+	Pause() bool
+	Resume() bool
+	Paused() (bool, time.Time)
+	LastCycle() time.Time
+	LastCycleDuration() time.Duration
+	QueueDepth() int
+}
+
+// SetChecker gives the admin commands control over the background checker
+func (p *MessageProcessor) SetChecker(checker CheckerControl) {
+	p.checker = checker
+}
+
+// parseAdmins reads the comma-separated user IDs allowed to run admin commands, skipping invalid ones.
+// A user's ID is also the chat ID of their private chat with the bot.
+func parseAdmins(value string, log *logger.Logger) map[int64]bool {
+	admins := make(map[int64]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Warn("Ignoring invalid admin user ID %q in ADMIN_CHAT_IDS", field)
+			continue
+		}
+		admins[id] = true
+	}
+	return admins
+}
+
+// isAdmin reports whether a user may run admin commands
+func (p *MessageProcessor) isAdmin(userID int64) bool {
+	return userID != 0 && p.admins[userID]
+}
+
+// requireAdmin treats admin commands from other users as unknown, so they don't give away that admin commands exist.
+// The sender is checked rather than the chat, so members of a group an admin is in don't get admin rights.
+// The command then runs like any other, without loading a user for the senders it's denied to.
+func (p *MessageProcessor) requireAdmin(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		if req.Command == nil || !req.Command.Admin {
+			return next(ctx, req)
+		}
+
+		log := p.logger.With("chat_id", req.ChatID, "user_id", req.SenderID, "command", req.Name)
+		if !p.isAdmin(req.SenderID) {
+			log.Warn("Denied admin command /%s", req.Name)
+			req.Command = nil
+			return next(ctx, req)
+		}
+
+		log.Info("Admin command /%s", req.Name)
+		return next(ctx, req)
+	}
+}
+
+// adminCommands registers the commands operators use to watch over and control the bot
+func (p *MessageProcessor) adminCommands(r *Router) {
+	r.Handle(Command{
+		Name:        "stats",
+		Description: "Show users, tracked classes and how the checker is doing",
+		Admin:       true,
+		Handler: func(ctx context.Context, req *Request) error {
+			return p.showStats(ctx, req.ChatID)
+		},
+	})
+	r.Handle(Command{
+		Name:        "broadcast",
+		Usage:       "message",
+		Description: "Send a message to every user",
+		Admin:       true,
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.broadcast(ctx, req.ChatID, req.RawArgs)
+			})
+			return nil
+		},
+	})
+	r.Handle(Command{
+		Name:        "user",
+		Usage:       "telegram_id",
+		Description: "Look up a user and the classes they track",
+		Admin:       true,
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			return p.showUser(ctx, req.ChatID, req.Args[0])
+		},
+	})
+	r.Handle(Command{
+		Name:        "forcecheck",
+		Description: "Check all tracked classes now",
+		Admin:       true,
+		Handler: func(ctx context.Context, req *Request) error {
+			if p.checker == nil {
+				return p.client.SendMessage(req.ChatID, "The checker isn't running.")
+			}
+			p.acknowledge(req.ChatID, "Checking all tracked classes...")
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.forceCheck(ctx, req.ChatID)
+			})
+			return nil
+		},
+	})
+	r.Handle(Command{
+		Name:        "pause",
+		Description: "Pause the checker",
+		Admin:       true,
+		Handler: func(ctx context.Context, req *Request) error {
+			if p.checker == nil {
+				return p.client.SendMessage(req.ChatID, "The checker isn't running.")
+			}
+			if !p.checker.Pause() {
+				return p.client.SendMessage(req.ChatID, "The checker is already paused.")
+			}
+			return p.client.SendMessage(req.ChatID, "Checker paused. Tracked classes won't be checked until /resume; a check already running will finish.")
+		},
+	})
+	r.Handle(Command{
+		Name:        "resume",
+		Description: "Resume the checker",
+		Admin:       true,
+		Handler: func(ctx context.Context, req *Request) error {
+			if p.checker == nil {
+				return p.client.SendMessage(req.ChatID, "The checker isn't running.")
+			}
+			if !p.checker.Resume() {
+				return p.client.SendMessage(req.ChatID, "The checker isn't paused.")
+			}
+			return p.client.SendMessage(req.ChatID, "Checker resumed, checking tracked classes now.")
+		},
+	})
+}
+
+// showStats replies with the number of users and tracked classes, the state of the checker and recent error rates
+func (p *MessageProcessor) showStats(ctx context.Context, chatID int64) error {
+	users, err := p.db.GetAllUsers()
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving users: %s", userError(err)))
+	}
+	tracked, err := p.db.GetAllTrackedCRNs()
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %s", userError(err)))
+	}
+
+	// The same CRN in two terms is two sections, and rows without a term are in the current one, as the checker sees them
+	current := ""
+	if term, err := p.parser.CurrentTerm(ctx); err != nil {
+		p.logger.Warn("Error resolving current term: %v", err)
+	} else {
+		current = term.Code
+	}
+	type section struct{ term, crn string }
+	sections := make(map[section]bool)
+	watchers := make(map[int64]bool)
+	for _, crn := range tracked {
+		term := crn.Term
+		if term == "" {
+			term = current
+		}
+		sections[section{term, crn.CRN}] = true
+		watchers[crn.UserID] = true
+	}
+
+	var b strings.Builder
+	b.WriteString("Bot statistics:\n")
+	fmt.Fprintf(&b, "Users: %d (%d tracking classes)\n", len(users), len(watchers))
+	fmt.Fprintf(&b, "Tracked CRNs: %d\n", len(tracked))
+	fmt.Fprintf(&b, "Distinct CRNs: %d\n", len(sections))
+
+	if p.checker != nil {
+		if paused, since := p.checker.Paused(); paused {
+			fmt.Fprintf(&b, "Checker: paused since %s\n", since.Format("Jan 2 15:04"))
+		} else {
+			fmt.Fprintf(&b, "Checker: running, %d section(s) queued\n", p.checker.QueueDepth())
+		}
+		if last := p.checker.LastCycle(); last.IsZero() {
+			b.WriteString("Last cycle: none finished yet\n")
+		} else {
+			fmt.Fprintf(&b, "Last cycle: took %v, finished %v ago\n",
+				p.checker.LastCycleDuration().Round(100*time.Millisecond), time.Since(last).Round(time.Second))
+		}
+	}
+
+	if rater, ok := p.parser.(ndparser.SuccessRater); ok {
+		if rate, samples := rater.SuccessRate(); samples == 0 {
+			b.WriteString("Search errors: no searches yet\n")
+		} else {
+			fmt.Fprintf(&b, "Search errors: %.0f%% of the last %d searches\n", (1-rate)*100, samples)
+		}
+	}
+
+	stats := p.client.Outbox().Stats()
+	fmt.Fprintf(&b, "Messages: %d sent, %d failed", stats.Sent, stats.Failed)
+
+	return p.client.SendMessage(chatID, b.String())
+}
+
+// showUser replies with what's known about the user with a Telegram ID, including the classes they track
+func (p *MessageProcessor) showUser(ctx context.Context, chatID int64, arg string) error {
+	telegramID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Invalid Telegram ID: %s", arg))
+	}
+
+	user, err := p.db.GetUserByTelegramID(telegramID)
+	if errors.Is(err, database.ErrNotFound) {
+		return p.client.SendMessage(chatID, fmt.Sprintf("No user with Telegram ID %d.", telegramID))
+	}
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving user: %s", userError(err)))
+	}
+
+	crns, err := p.db.GetUserTrackedCRNs(user.ID)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %s", userError(err)))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "User %d (ID %d)\n", user.TelegramID, user.ID)
+	if user.Username != "" {
+		fmt.Fprintf(&b, "Username: @%s\n", user.Username)
+	}
+	fmt.Fprintf(&b, "Joined: %s\n", time.Unix(user.CreatedAt, 0).Format("Jan 2, 2006"))
+
+	if len(crns) == 0 {
+		b.WriteString("Not tracking any classes.")
+		return p.reply(chatID, b.String())
+	}
+
+	fmt.Fprintf(&b, "Tracking %d class(es):\n", len(crns))
+	now := time.Now().Unix()
+	for _, crn := range crns {
+		line := fmt.Sprintf("- %s (%s, %s)", crn.CRN, crn.Title, p.termName(ctx, crn.Term))
+		if crn.SnoozedUntil > now {
+			line += ", snoozed until " + time.Unix(crn.SnoozedUntil, 0).Format("Jan 2 15:04")
+		}
+		b.WriteString(line + "\n")
+	}
+
+	return p.reply(chatID, b.String())
+}
+
+// broadcastWorkers is how many broadcast messages are handed to the outbox at a time.
+// It only has to keep the outbox busy; Telegram's limit of 30 messages a second is applied there.
+const broadcastWorkers = 8
+
+// broadcast sends a message to every user and replies with how many received it.
+// If ctx is cancelled, e.g. by a shutdown, users who haven't got it yet are skipped.
+func (p *MessageProcessor) broadcast(ctx context.Context, chatID int64, text string) error {
+	users, err := p.db.GetAllUsers()
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving users: %s", userError(err)))
+	}
+
+	p.acknowledge(chatID, fmt.Sprintf("Sending the message to %d user(s)...", len(users)))
+
+	var delivered atomic.Int64
+	var wg sync.WaitGroup
+	queue := make(chan database.User)
+	for range broadcastWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range queue {
+				if _, err := p.client.SendMessageContext(ctx, user.TelegramID, text); err != nil {
+					p.logger.With("chat_id", user.TelegramID).Warn("Error sending broadcast: %v", err)
+					continue
+				}
+				delivered.Add(1)
+			}
+		}()
+	}
+
+	queued := 0
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case queue <- user:
+			queued++
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	log := p.logger.With("chat_id", chatID, "users", len(users), "delivered", delivered.Load())
+	if queued < len(users) {
+		log.Warn("Broadcast stopped after %d user(s): %v", queued, ctx.Err())
+		return p.client.SendMessage(chatID, fmt.Sprintf("Broadcast stopped, delivered to %d of %d user(s).", delivered.Load(), len(users)))
+	}
+
+	log.Info("Broadcast sent")
+	return p.client.SendMessage(chatID, fmt.Sprintf("Broadcast delivered to %d of %d user(s).", delivered.Load(), len(users)))
+}
+
+// forceCheck runs a check of all tracked classes right away, even if the checker is paused
+func (p *MessageProcessor) forceCheck(ctx context.Context, chatID int64) error {
+	start := time.Now()
+	if err := p.checker.CheckNow(ctx); err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error checking tracked classes: %s", userError(err)))
+	}
+	return p.client.SendMessage(chatID, fmt.Sprintf("Check finished in %v.", time.Since(start).Round(100*time.Millisecond)))
+}
@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"NDClasses/clients/database"
+)
+
+// Button actions on tracked CRNs, sent back as "action:trackedCRNID" in the callback data
+const (
+	callbackCheck   = "check"
+	callbackUntrack = "untrack"
+	callbackSnooze  = "snooze"
+)
+
+// Button actions on search results, sent back as "track:term:crn" and "page:offset"
+const (
+	callbackTrack = "track"
+	callbackPage  = "page"
+)
+
+// SnoozeDuration is how long the "Snooze" button on a seat alert holds back further alerts
+const SnoozeDuration = 24 * time.Hour
+
+// AlertKeyboard returns the buttons shown below a seat alert about a tracked CRN
+func AlertKeyboard(trackedCRNID int64) *InlineKeyboardMarkup {
+	return &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{
+		{Text: "Stop tracking", CallbackData: callbackData(callbackUntrack, trackedCRNID)},
+		{Text: "Snooze 24h", CallbackData: callbackData(callbackSnooze, trackedCRNID)},
+	}}}
+}
+
+// listKeyboard returns a row of buttons for each class shown by /list
+func listKeyboard(crns []database.TrackedCRN) *InlineKeyboardMarkup {
+	keyboard := &InlineKeyboardMarkup{}
+	for _, crn := range crns {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []InlineKeyboardButton{
+			{Text: "Check now " + crn.CRN, CallbackData: callbackData(callbackCheck, crn.ID)},
+			{Text: "Stop tracking " + crn.CRN, CallbackData: callbackData(callbackUntrack, crn.ID)},
+		})
+	}
+	return keyboard
+}
+
+// callbackData encodes a button action on a tracked CRN
+func callbackData(action string, trackedCRNID int64) string {
+	return action + ":" + strconv.FormatInt(trackedCRNID, 10)
+}
+
+// parseCallbackData decodes the data of a pressed button
+func parseCallbackData(data string) (string, int64, bool) {
+	action, id, ok := strings.Cut(data, ":")
+	if !ok {
+		return "", 0, false
+	}
+
+	trackedCRNID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return action, trackedCRNID, true
+}
+
+// processCallback handles a pressed inline keyboard button.
+// Like commands, failures and panics are logged and the user gets an apology.
+func (p *MessageProcessor) processCallback(query *CallbackQuery) (err error) {
+	log := p.logger.With("callback", query.Data)
+	if query.Message != nil {
+		log = log.With("chat_id", query.Message.Chat.ID)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(log, "button", r)
+		}
+		if err == nil || query.Message == nil {
+			return
+		}
+		log.Error("Error handling button: %v", err)
+		err = p.apologize(query.Message.Chat.ID)
+	}()
+
+	return p.handleCallback(query)
+}
+
+// handleCallback acts on a pressed button.
+// Every callback query is answered, so the button stops showing a loading indicator.
+func (p *MessageProcessor) handleCallback(query *CallbackQuery) error {
+	ctx := context.Background()
+
+	if query.Message == nil {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "This button has expired.")
+	}
+	chatID := query.Message.Chat.ID
+
+	// Search result buttons don't refer to tracked CRNs
+	switch action, arg, _ := strings.Cut(query.Data, ":"); action {
+	case callbackTrack:
+		return p.trackSearchResult(ctx, query, arg)
+	case callbackPage:
+		return p.turnSearchPage(ctx, query, arg)
+	}
+
+	action, trackedCRNID, ok := parseCallbackData(query.Data)
+	if !ok {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "Unknown button.")
+	}
+
+	// Users are stored by chat ID, and buttons only act on the user's own classes
+	user, err := p.db.GetUserByTelegramID(chatID)
+	if err != nil {
+		// Failed buttons are still answered so their loading indicator stops; both errors are reported
+		return errors.Join(fmt.Errorf("can't get user: %w", err), p.client.AnswerCallbackQuery(ctx, query.ID, ""))
+	}
+
+	crn, err := p.db.GetTrackedCRN(user.ID, trackedCRNID)
+	if errors.Is(err, database.ErrNotFound) {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "You are not tracking this class.")
+	} else if err != nil {
+		return errors.Join(fmt.Errorf("can't get tracked CRN: %w", err), p.client.AnswerCallbackQuery(ctx, query.ID, ""))
+	}
+
+	switch action {
+	case callbackCheck:
+		if err := p.client.AnswerCallbackQuery(ctx, query.ID, ""); err != nil {
+			return err
+		}
+		p.acknowledge(chatID, p.checkingMessage())
+		p.spawn(chatID, func(ctx context.Context) error {
+			return p.checkClassAvailability(ctx, chatID, crn.CRN, crn.Term)
+		})
+		return nil
+	case callbackUntrack:
+		if !crn.Active {
+			return p.client.AnswerCallbackQuery(ctx, query.ID, "You are not tracking this class.")
+		}
+		if err := p.db.RemoveTrackedCRNByID(user.ID, crn.ID); err != nil {
+			answerErr := p.client.AnswerCallbackQuery(ctx, query.ID, "")
+			return errors.Join(answerErr, p.client.SendMessage(chatID, fmt.Sprintf("Error removing CRN from tracking list: %s", userError(err))))
+		}
+		if err := p.client.AnswerCallbackQuery(ctx, query.ID, "Stopped tracking CRN "+crn.CRN); err != nil {
+			return err
+		}
+		return p.client.SendMessage(chatID, fmt.Sprintf("Removed CRN %s from your tracking list.", crn.CRN))
+	case callbackSnooze:
+		until := time.Now().Add(SnoozeDuration)
+		if err := p.db.SnoozeTrackedCRN(user.ID, crn.ID, until.Unix()); err != nil {
+			answerErr := p.client.AnswerCallbackQuery(ctx, query.ID, "")
+			return errors.Join(answerErr, p.client.SendMessage(chatID, fmt.Sprintf("Error snoozing alerts: %s", userError(err))))
+		}
+		return p.client.AnswerCallbackQuery(ctx, query.ID, fmt.Sprintf("Alerts for CRN %s snoozed until %s", crn.CRN, until.Format("Jan 2 15:04")))
+	default:
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "Unknown button.")
+	}
+}
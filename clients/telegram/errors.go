@@ -0,0 +1,110 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"NDClasses/clients/logger"
+)
+
+// Error is an error returned by the Bot API in an ok:false response
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  time.Duration // How long Telegram asks us to wait before retrying, if it does
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s failed with %d: %s (retry after %v)", e.Method, e.Code, e.Description, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s failed with %d: %s", e.Method, e.Code, e.Description)
+}
+
+// Temporary reports whether the request may succeed if it's repeated later
+func (e *Error) Temporary() bool {
+	return e.RetryAfter > 0 || e.Code == http.StatusTooManyRequests || e.Code == http.StatusConflict || e.Code >= 500
+}
+
+// apiError builds an Error from an ok:false response and counts it in the metrics
+func apiError(method string, resp APIResponse) *Error {
+	e := &Error{
+		Method:      method,
+		Code:        resp.ErrorCode,
+		Description: resp.Description,
+	}
+	if resp.Parameters != nil && resp.Parameters.RetryAfter > 0 {
+		e.RetryAfter = time.Duration(resp.Parameters.RetryAfter) * time.Second
+	}
+	countAPIError(e)
+	return e
+}
+
+// isInvalidToken reports whether Telegram rejected the bot itself, which no retry will fix
+func isInvalidToken(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusNotFound)
+}
+
+// redactURL masks the bot token in errors that quote the request URL, as http.Client's errors do.
+// The query is dropped as well, since it may hold the webhook secret or the text of a message.
+// The error it wraps is kept, so errors.Is still sees timeouts and cancellations.
+func (c *Client) redactURL(err error) error {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+
+	redacted := urlErr.URL
+	if i := strings.IndexByte(redacted, '?'); i >= 0 {
+		redacted = redacted[:i]
+	}
+	if c.token != "" {
+		redacted = strings.ReplaceAll(redacted, c.token, logger.Redacted)
+	}
+
+	return &url.Error{Op: urlErr.Op, URL: redacted, Err: urlErr.Err}
+}
+
+// retryDelay returns how long to wait before the given retry attempt (starting at 0).
+// Telegram's retry_after wins; otherwise the delay grows exponentially up to max, with full jitter.
+func retryDelay(err error, attempt int, base time.Duration, max time.Duration) time.Duration {
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+
+	delay := base
+	for i := 0; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// userError formats an error to be shown in a chat.
+// URLs quoted by HTTP errors lose their query, which may hold session IDs, and secrets are redacted.
+func userError(err error) string {
+	msg := err.Error()
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		stripped := urlErr.URL
+		if i := strings.IndexByte(stripped, '?'); i >= 0 {
+			stripped = stripped[:i]
+		}
+		msg = strings.ReplaceAll(msg, fmt.Sprintf("%q", urlErr.URL), fmt.Sprintf("%q", stripped))
+	}
+
+	return logger.Redact(msg)
+}
@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"NDClasses/clients/database"
+)
+
+// historyTimeFormat is how times are shown in /history
+const historyTimeFormat = "Jan 2 15:04"
+
+// historyWindow is how far back /history looks, so a section checked for years doesn't load every observation
+const historyWindow = 30 * 24 * time.Hour
+
+// opening is a stretch of checks during which a section had open seats
+type opening struct {
+	start int64
+	end   int64 // Check that found it full again, or the latest check if it's still open
+}
+
+// historySummary is what the checker's observations say about a section
+type historySummary struct {
+	checks    int
+	succeeded int
+	since     int64
+	last      *database.SectionObservation // Latest successful check
+	openings  []opening
+}
+
+// summarizeHistory walks a section's observations, oldest first, and finds when it had open seats
+func summarizeHistory(observations []database.SectionObservation) historySummary {
+	var s historySummary
+	var current *opening
+
+	for i := range observations {
+		obs := &observations[i]
+		s.checks++
+		if s.since == 0 {
+			s.since = obs.ObservedAt
+		}
+		if !obs.Success {
+			continue
+		}
+		s.succeeded++
+		s.last = obs
+
+		switch {
+		case obs.Seats > 0 && current == nil:
+			s.openings = append(s.openings, opening{start: obs.ObservedAt, end: obs.ObservedAt})
+			current = &s.openings[len(s.openings)-1]
+		case obs.Seats > 0:
+			current.end = obs.ObservedAt
+		case current != nil:
+			current.end = obs.ObservedAt
+			current = nil
+		}
+	}
+
+	return s
+}
+
+// showHistory sends a summary of a section's seat availability recorded by the checker
+func (p *MessageProcessor) showHistory(ctx context.Context, chatID int64, crn string, termQuery string) error {
+	term, err := p.resolveTerm(ctx, termQuery)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error resolving term: %s", userError(err)))
+	}
+
+	observations, err := p.db.GetObservations(term.Code, crn, time.Now().Add(-historyWindow).Unix())
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving history: %s", userError(err)))
+	}
+
+	if len(observations) == 0 {
+		return p.client.SendMessage(chatID, fmt.Sprintf("No history for CRN %s in %s yet. History is recorded while someone tracks the class.", crn, term.Description))
+	}
+
+	return p.client.SendMessage(chatID, formatHistory(crn, term.Description, summarizeHistory(observations)))
+}
+
+// formatHistory formats a history summary
+func formatHistory(crn string, termName string, s historySummary) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Seat history for CRN %s in %s\n", crn, termName)
+	fmt.Fprintf(&b, "Checked %d times since %s (%d%% successful)\n",
+		s.checks, time.Unix(s.since, 0).Format(historyTimeFormat), s.succeeded*100/s.checks)
+
+	if s.last == nil {
+		b.WriteString("No check has succeeded yet.\n")
+		return b.String()
+	}
+
+	if s.last.Seats > 0 {
+		fmt.Fprintf(&b, "Currently: open, %d seat(s) as of %s\n", s.last.Seats, time.Unix(s.last.ObservedAt, 0).Format(historyTimeFormat))
+	} else {
+		fmt.Fprintf(&b, "Currently: full as of %s\n", time.Unix(s.last.ObservedAt, 0).Format(historyTimeFormat))
+	}
+
+	if len(s.openings) == 0 {
+		b.WriteString("No open seats have been seen.\n")
+		return b.String()
+	}
+
+	latest := s.openings[len(s.openings)-1]
+	fmt.Fprintf(&b, "Last opened: %s\n", time.Unix(latest.start, 0).Format(historyTimeFormat))
+
+	fmt.Fprintf(&b, "Openings: %d", len(s.openings))
+	if days := float64(s.last.ObservedAt-s.since) / float64(24*time.Hour/time.Second); days >= 1 {
+		fmt.Fprintf(&b, " (%.1f per day)", float64(len(s.openings))/days)
+	}
+	b.WriteString("\n")
+
+	var total, longest int64
+	for _, o := range s.openings {
+		total += o.end - o.start
+		longest = max(longest, o.end-o.start)
+	}
+	fmt.Fprintf(&b, "Seats stayed open %s on average, %s at most\n",
+		formatSpan(total/int64(len(s.openings))), formatSpan(longest))
+
+	return b.String()
+}
+
+// formatSpan formats a number of seconds to the minute, e.g. "2h10m" or "8h"
+func formatSpan(seconds int64) string {
+	d := (time.Duration(seconds) * time.Second).Round(time.Minute)
+	if d < time.Minute {
+		return "under a minute"
+	}
+	span := strings.TrimSuffix(d.String(), "0s")
+	if strings.HasSuffix(span, "h0m") {
+		span = strings.TrimSuffix(span, "0m")
+	}
+	return span
+}
@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"strconv"
+
+	"NDClasses/clients/metrics"
+)
+
+// Outcomes of outgoing messages, as reported in metrics
+const (
+	messageSent   = "sent"
+	messageFailed = "failed"
+)
+
+var (
+	messagesTotal = metrics.Default().NewCounterVec(
+		"ndclasses_telegram_messages_total",
+		"Outgoing messages, by whether they were delivered or finally failed.",
+		"outcome",
+	)
+	apiErrorsTotal = metrics.Default().NewCounterVec(
+		"ndclasses_telegram_api_errors_total",
+		"Error responses from the Bot API, by method and error code.",
+		"method", "code",
+	)
+)
+
+func init() {
+	// Start at zero, so rates work from the first scrape
+	messagesTotal.With(messageSent)
+	messagesTotal.With(messageFailed)
+}
+
+// countAPIError records an error response from the Bot API
+func countAPIError(e *Error) {
+	apiErrorsTotal.With(e.Method, strconv.Itoa(e.Code)).Inc()
+}
@@ -0,0 +1,73 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"NDClasses/clients/logger"
+)
+
+// logCommands logs every command with how long it took
+func (p *MessageProcessor) logCommands(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		start := time.Now()
+		err := next(ctx, req)
+		p.logger.With("chat_id", req.ChatID, "command", req.Name, "duration", time.Since(start)).Debug("Command /%s handled", req.Name)
+		return err
+	}
+}
+
+// reportErrors logs failed commands and apologizes to the user instead of leaving them without an answer
+func (p *MessageProcessor) reportErrors(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		err := next(ctx, req)
+		if err == nil {
+			return nil
+		}
+
+		p.logger.With("chat_id", req.ChatID, "command", req.Name).Error("Error running /%s: %v", req.Name, err)
+		return p.apologize(req.ChatID)
+	}
+}
+
+// apologize tells a user that what they asked for failed
+func (p *MessageProcessor) apologize(chatID int64) error {
+	return p.client.SendMessage(chatID, "Sorry, something went wrong. Please try again later.")
+}
+
+// recoverPanics turns a panicking command into an error, so one bad command doesn't take the bot down
+func (p *MessageProcessor) recoverPanics(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(p.logger.With("chat_id", req.ChatID, "command", req.Name), "/"+req.Name, r)
+			}
+		}()
+		return next(ctx, req)
+	}
+}
+
+// recovered logs a recovered panic with its stack and turns it into an error
+func recovered(log *logger.Logger, what string, r any) error {
+	log.Error("Panic in %s: %v\n%s", what, r, debug.Stack())
+	return fmt.Errorf("panic: %v", r)
+}
+
+// loadUser creates or loads the user sending the command.
+// Unknown commands are answered without one, so typos don't leave users behind.
+func (p *MessageProcessor) loadUser(next HandlerFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		if req.Command == nil {
+			return next(ctx, req)
+		}
+
+		user, err := p.db.CreateUser(req.ChatID, "")
+		if err != nil {
+			return fmt.Errorf("can't load user: %w", err)
+		}
+		req.User = user
+		return next(ctx, req)
+	}
+}
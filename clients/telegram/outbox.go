@@ -0,0 +1,229 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Telegram's limits for bots: about 30 messages a second overall and one a second per chat
+const (
+	globalInterval  = time.Second / 30
+	perChatInterval = time.Second
+	maxSendAttempts = 5
+
+	// chatIdleTimeout is how often queues of chats that have nothing waiting are dropped
+	chatIdleTimeout = time.Minute
+)
+
+// OutboxStats counts the messages handled by an outbox
+type OutboxStats struct {
+	Queued int64 `json:"queued"`
+	Sent   int64 `json:"sent"`
+	Failed int64 `json:"failed"`
+}
+
+// Outbox delivers outgoing messages within Telegram's global and per-chat rate limits.
+// Messages to the same chat are sent one at a time, and callers wait until their message is
+// delivered or has finally failed, so a caller's messages arrive in the order it sent them.
+// Messages from different callers to the same chat may go out in any order.
+type Outbox struct {
+	global *gate
+
+	mu        sync.Mutex
+	chats     map[int64]*chatQueue
+	lastSweep time.Time
+
+	queued atomic.Int64
+	sent   atomic.Int64
+	failed atomic.Int64
+}
+
+// chatQueue serializes the messages to one chat.
+// users counts the messages holding the queue, under Outbox.mu, so it's only dropped when unused.
+type chatQueue struct {
+	mu    sync.Mutex
+	gate  *gate
+	users int
+}
+
+// newOutbox creates an outbox with Telegram's default limits
+func newOutbox() *Outbox {
+	return &Outbox{
+		global:    &gate{interval: globalInterval},
+		chats:     make(map[int64]*chatQueue),
+		lastSweep: time.Now(),
+	}
+}
+
+// Stats returns how many messages are waiting and how many were sent or failed so far
+func (o *Outbox) Stats() OutboxStats {
+	return OutboxStats{
+		Queued: o.queued.Load(),
+		Sent:   o.sent.Load(),
+		Failed: o.failed.Load(),
+	}
+}
+
+// deliver runs send for a chat once the limits allow it, retrying transient failures.
+// A 429 pauses the chat and the whole outbox for as long as Telegram asks.
+func (o *Outbox) deliver(ctx context.Context, chatID int64, backoff func(err error, attempt int) time.Duration, send func(ctx context.Context) (Message, error)) (Message, error) {
+	o.queued.Add(1)
+	defer o.queued.Add(-1)
+
+	chat := o.chat(chatID)
+	defer o.release(chat)
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+
+	// Messages that failed never reached the chat, so only the first attempt takes a chat slot
+	if err := chat.gate.wait(ctx); err != nil {
+		o.fail()
+		return Message{}, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := o.global.wait(ctx); err != nil {
+			o.fail()
+			return Message{}, err
+		}
+
+		msg, err := send(ctx)
+		if err == nil {
+			o.sent.Add(1)
+			messagesTotal.With(messageSent).Inc()
+			return msg, nil
+		}
+
+		if !retryable(err) || attempt+1 >= maxSendAttempts || ctx.Err() != nil {
+			o.fail()
+			return Message{}, err
+		}
+
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			chat.gate.pause(apiErr.RetryAfter)
+			o.global.pause(apiErr.RetryAfter)
+			if err := chat.gate.wait(ctx); err != nil {
+				o.fail()
+				return Message{}, err
+			}
+			continue
+		}
+
+		timer := time.NewTimer(backoff(err, attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			o.fail()
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// fail counts a message that won't be delivered
+func (o *Outbox) fail() {
+	o.failed.Add(1)
+	messagesTotal.With(messageFailed).Inc()
+}
+
+// chat returns the queue of a chat, creating it on first use, and holds it until release.
+// Now and then queues of chats that are idle are dropped, so the map doesn't grow with every chat ever seen.
+func (o *Outbox) chat(chatID int64) *chatQueue {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if now := time.Now(); now.Sub(o.lastSweep) >= chatIdleTimeout {
+		o.lastSweep = now
+		for id, chat := range o.chats {
+			// A queue whose gate is still closed has to stay, or the next message would skip the per-chat limit
+			if chat.users == 0 && !chat.gate.closed(now) {
+				delete(o.chats, id)
+			}
+		}
+	}
+
+	chat, ok := o.chats[chatID]
+	if !ok {
+		chat = &chatQueue{gate: &gate{interval: perChatInterval}}
+		o.chats[chatID] = chat
+	}
+	chat.users++
+	return chat
+}
+
+// release gives back a queue taken with chat
+func (o *Outbox) release(chat *chatQueue) {
+	o.mu.Lock()
+	chat.users--
+	o.mu.Unlock()
+}
+
+// retryable reports whether a failed send may succeed later without sending the message twice.
+// Bot API errors say so themselves. Other failures are only retried if the request never left,
+// as Telegram may have delivered a message whose response was lost.
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// gate spaces events out so that they start at least interval apart
+type gate struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait reserves the next start time and sleeps until it comes
+func (g *gate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	now := time.Now()
+	start := g.next
+	if start.Before(now) {
+		start = now
+	}
+	g.next = start.Add(g.interval)
+	g.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closed reports whether the next event would have to wait
+func (g *gate) closed(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.next.After(now)
+}
+
+// pause keeps the gate closed for at least d from now
+func (g *gate) pause(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if until := time.Now().Add(d); g.next.Before(until) {
+		g.next = until
+	}
+}
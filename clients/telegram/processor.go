@@ -3,7 +3,10 @@ package telegram
 import (
 	"context"
 	"fmt"
+	"os"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"NDClasses/clients/database"
 	"NDClasses/clients/logger"
@@ -13,23 +16,180 @@ import (
 // MessageProcessor handles processing of Telegram messages and commands
 type MessageProcessor struct {
 	client *Client
-	parser ndparser.Parser
-	db     *database.Database
+	parser ndparser.ClassSource
+	db     database.Store
 	logger *logger.Logger
+	router *Router
+
+	// Chats allowed to run admin commands, and the checker they control
+	admins  map[int64]bool
+	checker CheckerControl
+
+	// Commands that search the registration site run in the background under ctx.
+	// Once closing is set no new ones start, so wg.Add never races Shutdown's wg.Wait.
+	ctx     context.Context
+	abort   context.CancelFunc
+	wg      sync.WaitGroup
+	spawnMu sync.Mutex
+	closing bool
+
+	// Updates waiting for each chat's worker, under spawnMu; a chat is in the map while its worker runs
+	queues map[int64][]Update
+
+	// Latest /search of each chat
+	searchMu sync.Mutex
+	searches map[int64]*searchSession
 }
 
-// NewMessageProcessor creates a new message processor
-func NewMessageProcessor(client *Client, db *database.Database, logger *logger.Logger) *MessageProcessor {
-	return &MessageProcessor{
+// NewMessageProcessor creates a new message processor.
+// ADMIN_CHAT_IDS lists, separated by commas, the users allowed to run admin commands.
+func NewMessageProcessor(client *Client, db database.Store, parser ndparser.ClassSource, logger *logger.Logger) *MessageProcessor {
+	ctx, abort := context.WithCancel(context.Background())
+
+	p := &MessageProcessor{
 		client: client,
-		parser: ndparser.New(logger),
+		parser: parser,
 		db:     db,
 		logger: logger,
+		ctx:    ctx,
+		abort:  abort,
+		admins: parseAdmins(os.Getenv("ADMIN_CHAT_IDS"), logger),
+
+		searches: make(map[int64]*searchSession),
+		queues:   make(map[int64][]Update),
+	}
+	p.router = p.commands()
+
+	return p
+}
+
+// Shutdown stops new background commands from starting and waits for those still running.
+// If ctx is done first, their searches are cancelled and ctx's error is returned.
+func (p *MessageProcessor) Shutdown(ctx context.Context) error {
+	p.spawnMu.Lock()
+	p.closing = true
+	p.spawnMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		p.abort()
+		return ctx.Err()
+	}
+}
+
+// spawn runs a command for a chat in the background so slow searches don't block other updates.
+// Like commands run by the router, failures and panics are logged and the user gets an apology.
+func (p *MessageProcessor) spawn(chatID int64, fn func(ctx context.Context) error) {
+	p.spawnMu.Lock()
+	defer p.spawnMu.Unlock()
+	if p.closing {
+		p.logger.With("chat_id", chatID).Warn("Dropping command received during shutdown")
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		log := p.logger.With("chat_id", chatID)
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = recovered(log, "background command", r)
+				}
+			}()
+			return fn(p.ctx)
+		}()
+		if err == nil {
+			return
+		}
+
+		log.Error("Error processing command: %v", err)
+		if p.ctx.Err() == nil {
+			p.apologize(chatID)
+		}
+	}()
+}
+
+// Enqueue processes an update in the background, like the commands started by spawn.
+// Updates of a chat are processed one at a time and in order, but chats don't wait for each other,
+// so a chat held up by Telegram's per-chat limit doesn't delay replies to the rest.
+func (p *MessageProcessor) Enqueue(update Update) {
+	chatID := updateChat(update)
+
+	p.spawnMu.Lock()
+	defer p.spawnMu.Unlock()
+	if p.closing {
+		p.logger.With("chat_id", chatID, "update_id", update.ID).Warn("Dropping update received during shutdown")
+		return
+	}
+
+	queued, running := p.queues[chatID]
+	p.queues[chatID] = append(queued, update)
+	if running {
+		return
+	}
+
+	p.wg.Add(1)
+	go p.drain(chatID)
+}
+
+// drain processes the updates queued for a chat until there are none left
+func (p *MessageProcessor) drain(chatID int64) {
+	defer p.wg.Done()
+
+	for {
+		p.spawnMu.Lock()
+		queued := p.queues[chatID]
+		if len(queued) == 0 {
+			delete(p.queues, chatID)
+			p.spawnMu.Unlock()
+			return
+		}
+		update := queued[0]
+		p.queues[chatID] = queued[1:]
+		p.spawnMu.Unlock()
+
+		if err := p.ProcessUpdate(update); err != nil {
+			p.logger.With("chat_id", chatID, "update_id", update.ID).Error("Error processing update: %v", err)
+		}
 	}
 }
 
-// ProcessUpdate processes a single update
+// updateChat returns the chat an update comes from
+func updateChat(update Update) int64 {
+	if query := update.CallbackQuery; query != nil {
+		if query.Message != nil {
+			return query.Message.Chat.ID
+		}
+		return query.From.ID
+	}
+	return update.Message.Chat.ID
+}
+
+// acknowledge tells a user that a command started in the background is under way.
+// The command runs either way, so a failed acknowledgement is only logged.
+func (p *MessageProcessor) acknowledge(chatID int64, text string) {
+	if err := p.client.SendMessage(chatID, text); err != nil {
+		p.logger.With("chat_id", chatID).Warn("Error acknowledging command: %v", err)
+	}
+}
+
+// ProcessUpdate processes a single update and waits for its replies, but not for commands it starts in the background
 func (p *MessageProcessor) ProcessUpdate(update Update) error {
+	// Check if a button was pressed
+	if update.CallbackQuery != nil {
+		return p.processCallback(update.CallbackQuery)
+	}
+
 	// Check if the update contains a message
 	if update.Message.Text == "" {
 		return nil // No text message to process
@@ -41,83 +201,409 @@ func (p *MessageProcessor) ProcessUpdate(update Update) error {
 
 	// Check if it's a command (starts with /)
 	if strings.HasPrefix(text, "/") {
-		return p.processCommand(chatID, text)
+		var senderID int64
+		if update.Message.From != nil {
+			senderID = update.Message.From.ID
+		}
+		return p.processCommand(chatID, senderID, text)
 	}
 
 	// Process regular message
 	return p.processMessage(chatID, text)
 }
 
-// processCommand processes a command message
-func (p *MessageProcessor) processCommand(chatID int64, command string) error {
-	// Create or get user in database
-	user, err := p.db.CreateUser(chatID, "")
-	if err != nil {
-		fmt.Printf("Error creating/getting user: %v\n", err)
-	}
-
-	switch command {
-	case "/start":
-		return p.client.SendMessage(chatID, "Hello! I'm the ND Classes bot. I can help you track class availability.\n\nUse /add CRN to add a class to track\nUse /remove CRN to stop tracking a class\nUse /list to see all classes you're tracking\nUse /check CRN to check a class availability now")
-	case "/help":
-		return p.client.SendMessage(chatID, "Available commands:\n/start - Start the bot\n/help - Show this help message\n/add CRN - Add a class to track\n/remove CRN - Stop tracking a class\n/list - List all tracked classes\n/check CRN - Check class availability now")
-	case "/list":
-		return p.listTrackedCRNs(chatID, user.ID)
-	default:
-		// Check if it's a command with arguments
-		if strings.HasPrefix(command, "/add ") {
-			crn := strings.TrimSpace(strings.TrimPrefix(command, "/add "))
-			go p.addTrackedCRN(chatID, user.ID, crn)
+// processCommand routes a command message to its handler
+func (p *MessageProcessor) processCommand(chatID int64, senderID int64, text string) error {
+	req, ok := p.router.Route(chatID, text)
+	if !ok {
+		return nil // Addressed to another bot
+	}
+	req.SenderID = senderID
+
+	return p.router.Dispatch(context.Background(), req, func(text string) error {
+		return p.client.SendMessage(chatID, text)
+	})
+}
+
+// commands registers the bot's commands
+func (p *MessageProcessor) commands() *Router {
+	r := NewRouter()
+
+	r.Handle(Command{
+		Name:        "start",
+		Description: "Start the bot",
+		Handler: func(ctx context.Context, req *Request) error {
+			return p.client.SendMessage(req.ChatID, "Hello! I'm the ND Classes bot. I can help you track class availability.\n\n"+p.router.Help())
+		},
+	})
+	r.Handle(Command{
+		Name:        "help",
+		Description: "Show this help message",
+		Handler: func(ctx context.Context, req *Request) error {
+			text := "Available commands:\n" + p.router.Help() + "\n\nThe term defaults to the current registration term and can be given as a code or a name, e.g. \"Spring 2026\"."
+			if p.isAdmin(req.SenderID) {
+				text += "\n\nAdmin commands:\n" + p.router.AdminHelp()
+			}
+			return p.client.SendMessage(req.ChatID, text)
+		},
+	})
+	r.Handle(Command{
+		Name:        "add",
+		Aliases:     []string{"track"},
+		Usage:       "CRN... [term]",
+		Description: "Add classes to track by CRN or course, e.g. CSE 20311",
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			args := parseTargets(req.RawArgs)
+			if msg := validateTargets(args, req.Name); msg != "" {
+				return p.client.SendMessage(req.ChatID, msg)
+			}
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.addTrackedCRNs(ctx, req.ChatID, req.User.ID, args)
+			})
+			return nil
+		},
+	})
+	r.Handle(Command{
+		Name:        "remove",
+		Aliases:     []string{"untrack"},
+		Usage:       "CRN... [term]",
+		Description: "Stop tracking classes by CRN or course",
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			args := parseTargets(req.RawArgs)
+			if msg := validateTargets(args, req.Name); msg != "" {
+				return p.client.SendMessage(req.ChatID, msg)
+			}
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.removeTrackedCRNs(ctx, req.ChatID, req.User.ID, args)
+			})
+			return nil
+		},
+	})
+	r.Handle(Command{
+		Name:        "list",
+		Description: "List all tracked classes",
+		Handler: func(ctx context.Context, req *Request) error {
+			return p.listTrackedCRNs(req.ChatID, req.User.ID)
+		},
+	})
+	r.Handle(Command{
+		Name:        "check",
+		Usage:       "CRN... [term]",
+		Description: "Check class availability now by CRN or course",
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			args := parseTargets(req.RawArgs)
+			if msg := validateTargets(args, req.Name); msg != "" {
+				return p.client.SendMessage(req.ChatID, msg)
+			}
+			p.acknowledge(req.ChatID, p.checkingMessage())
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.checkClasses(ctx, req.ChatID, args)
+			})
 			return nil
-		} else if strings.HasPrefix(command, "/remove ") {
-			crn := strings.TrimSpace(strings.TrimPrefix(command, "/remove "))
-			return p.removeTrackedCRN(chatID, user.ID, crn)
-		} else if strings.HasPrefix(command, "/check ") {
-			crn := strings.TrimSpace(strings.TrimPrefix(command, "/check "))
-			p.client.SendMessage(chatID, "Checking...")
-			go p.checkClassAvailability(chatID, crn)
+		},
+	})
+	r.Handle(Command{
+		Name:        "search",
+		Aliases:     []string{"find"},
+		Usage:       "SUBJECT [NUMBER] | keyword",
+		Description: "Find sections in the current term, e.g. CSE 20311 or algorithms",
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			query, ok := parseSearchQuery(req.RawArgs)
+			if !ok {
+				return p.client.SendMessage(req.ChatID, fmt.Sprintf("Please search for a course like CSE 20311, a subject like CSE, or a keyword of at least %d characters.", minKeywordLength))
+			}
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.search(ctx, req.ChatID, query)
+			})
 			return nil
-		} else if strings.HasPrefix(command, "/check_") {
-			crn := strings.TrimPrefix(command, "/check_")
-			p.client.SendMessage(chatID, "Checking...")
-			go p.checkClassAvailability(chatID, crn)
+		},
+	})
+	r.Handle(Command{
+		Name:        "history",
+		Usage:       "CRN [term]",
+		Description: "Show how often a class has had open seats",
+		MinArgs:     1,
+		Handler: func(ctx context.Context, req *Request) error {
+			crn := req.Args[0]
+			if !crnPattern.MatchString(crn) {
+				return p.client.SendMessage(req.ChatID, fmt.Sprintf("Invalid CRN: %s. CRNs are 5-digit numbers.", crn))
+			}
+			termQuery := strings.Join(req.Args[1:], " ")
+			p.spawn(req.ChatID, func(ctx context.Context) error {
+				return p.showHistory(ctx, req.ChatID, crn, termQuery)
+			})
 			return nil
+		},
+	})
+	r.Handle(Command{
+		Name:        "terms",
+		Description: "List available terms",
+		Handler: func(ctx context.Context, req *Request) error {
+			return p.listTerms(req.ChatID)
+		},
+	})
+
+	p.adminCommands(r)
+
+	r.Use(p.logCommands, p.reportErrors, p.recoverPanics, p.requireAdmin, p.loadUser)
+
+	return r
+}
+
+// SyncCommands looks up the bot's username, so commands addressed to other bots in group chats are ignored,
+// and publishes the command list shown in Telegram's command menu
+func (p *MessageProcessor) SyncCommands(ctx context.Context) error {
+	me, err := p.client.GetMe(ctx)
+	if err != nil {
+		return err
+	}
+	p.router.SetBotName(me.Username)
+
+	var commands []BotCommand
+	for _, c := range p.router.Commands() {
+		commands = append(commands, BotCommand{Command: c.Name, Description: c.Description})
+	}
+
+	return p.client.SetMyCommands(ctx, commands)
+}
+
+// resolveTerm finds the term matching the user's query, or the current term if the query is empty
+func (p *MessageProcessor) resolveTerm(ctx context.Context, query string) (ndparser.Term, error) {
+	if query == "" {
+		return p.parser.CurrentTerm(ctx)
+	}
+
+	terms, err := p.parser.Terms(ctx)
+	if err != nil {
+		return ndparser.Term{}, err
+	}
+
+	term, ok := ndparser.FindTerm(terms, query)
+	if !ok {
+		return ndparser.Term{}, fmt.Errorf("unknown term %q, use /terms to see available terms", query)
+	}
+
+	return term, nil
+}
+
+// termName returns the description of a term code, falling back to the code itself
+func (p *MessageProcessor) termName(ctx context.Context, code string) string {
+	if code == "" {
+		term, err := p.parser.CurrentTerm(ctx)
+		if err != nil {
+			return "current term"
+		}
+		return term.Description
+	}
+
+	terms, err := p.parser.Terms(ctx)
+	if err != nil {
+		return code
+	}
+
+	for _, term := range terms {
+		if term.Code == code {
+			return term.Description
+		}
+	}
+
+	return code
+}
+
+// checkingMessage acknowledges a check, telling the user how many searches are ahead of theirs
+func (p *MessageProcessor) checkingMessage() string {
+	if queue, ok := p.parser.(ndparser.QueueReporter); ok {
+		stats := queue.Stats()
+		if ahead := stats.Queued + stats.InFlight; ahead > 0 {
+			return fmt.Sprintf("Checking... (%d request(s) ahead of you)", ahead)
 		}
-		return p.client.SendMessage(chatID, "Unknown command. Type /help for available commands.")
 	}
+	return "Checking..."
 }
 
-// addTrackedCRN adds a CRN to the user's tracking list
-func (p *MessageProcessor) addTrackedCRN(chatID int64, userID int64, crn string) error {
-	// Check class availability to get the title
-	class, err := p.parser.SearchClass(context.Background(), crn)
+// addTrackedCRNs adds classes to the user's tracking list and replies with what was added, already tracked or not found
+func (p *MessageProcessor) addTrackedCRNs(ctx context.Context, chatID int64, userID int64, args targetArgs) error {
+	term, err := p.resolveTerm(ctx, args.term)
 	if err != nil {
-		return p.client.SendMessage(chatID, fmt.Sprintf("Error checking class: %v", err))
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error resolving term: %s", userError(err)))
 	}
 
-	// Add CRN to database
-	trackedCRN, err := p.db.AddTrackedCRN(userID, crn, class.Title)
+	// Search the classes to get their titles
+	result, err := p.lookupTargets(ctx, term.Code, args.targets)
 	if err != nil {
-		return p.client.SendMessage(chatID, fmt.Sprintf("Error adding CRN to tracking list: %v", err))
+		return err
+	}
+
+	// Find what the user already tracks in this term, including rows from before terms were stored
+	crns, err := p.db.GetUserTrackedCRNs(userID)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %s", userError(err)))
+	}
+	tracked := make(map[string]bool)
+	for _, crn := range crns {
+		if p.sameTerm(ctx, crn.Term, term.Code) {
+			tracked[crn.CRN] = true
+		}
 	}
 
-	// Update the title in case it changed
-	if trackedCRN.Title != class.Title {
-		p.db.UpdateCRNTitle(userID, crn, class.Title)
+	var added, already strings.Builder
+	failed := result.failed
+	for _, class := range result.classes {
+		if tracked[class.CRN] {
+			already.WriteString(summaryLine(class.CRN, class.Course(), class.Title))
+			continue
+		}
+
+		// Add CRN to database
+		trackedCRN, err := p.db.AddTrackedCRN(userID, class.CRN, term.Code, class.Title)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", class.CRN, userError(err)))
+			continue
+		}
+
+		// Update the title in case it changed; the class is tracked either way, so a failure is only logged
+		if trackedCRN.Title != class.Title {
+			if err := p.db.UpdateCRNTitle(userID, class.CRN, term.Code, class.Title); err != nil {
+				p.logger.With("chat_id", chatID, "crn", class.CRN, "term", term.Code).Warn("Error updating class title: %v", err)
+			}
+		}
+
+		added.WriteString(summaryLine(class.CRN, class.Course(), class.Title))
 	}
 
-	return p.client.SendMessage(chatID, fmt.Sprintf("Added CRN %s (%s) to your tracking list.", crn, class.Title))
+	var b strings.Builder
+	if added.Len() > 0 {
+		fmt.Fprintf(&b, "Added to your tracking list (%s):\n%s", term.Description, added.String())
+	}
+	if already.Len() > 0 {
+		fmt.Fprintf(&b, "Already tracking:\n%s", already.String())
+	}
+	writeList(&b, fmt.Sprintf("Not found in %s:", term.Description), result.notFound)
+	writeList(&b, "Failed:", failed)
+
+	return p.reply(chatID, b.String())
 }
 
-// removeTrackedCRN removes a CRN from the user's tracking list
-func (p *MessageProcessor) removeTrackedCRN(chatID int64, userID int64, crn string) error {
-	// Remove CRN from database
-	err := p.db.RemoveTrackedCRN(userID, crn)
+// removeTrackedCRNs removes classes from the user's tracking list, in one term if a term is given
+func (p *MessageProcessor) removeTrackedCRNs(ctx context.Context, chatID int64, userID int64, args targetArgs) error {
+	termCode := ""
+	if args.term != "" {
+		term, err := p.resolveTerm(ctx, args.term)
+		if err != nil {
+			return p.client.SendMessage(chatID, fmt.Sprintf("Error resolving term: %s", userError(err)))
+		}
+		termCode = term.Code
+	}
+
+	crns, err := p.db.GetUserTrackedCRNs(userID)
 	if err != nil {
-		return p.client.SendMessage(chatID, fmt.Sprintf("Error removing CRN from tracking list: %v", err))
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %s", userError(err)))
+	}
+
+	// Tracked classes by term, with the current term spelled out for rows that predate terms when it's needed.
+	// Plain CRNs are removed even if it can't be resolved; those rows then stay under the empty term.
+	current := ""
+	if needsCurrentTerm(crns, args, termCode) {
+		if term, err := p.parser.CurrentTerm(ctx); err != nil {
+			p.logger.With("chat_id", chatID).Warn("Error resolving current term for /remove: %v", err)
+		} else {
+			current = term.Code
+		}
+	}
+	byTerm := make(map[string][]database.TrackedCRN)
+	for _, crn := range crns {
+		code := crn.Term
+		if code == "" {
+			code = current
+		}
+		if termCode == "" || code == termCode {
+			byTerm[code] = append(byTerm[code], crn)
+		}
+	}
+
+	var removed strings.Builder
+	var notTracked, failed []string
+	for _, t := range args.targets {
+		// A course stands for the sections it has in each term the user tracks classes in.
+		// An empty code searches the current term, which fails here too if it can't be resolved.
+		matches := make(map[string]bool)
+		var searchErr error
+		if t.crn != "" {
+			matches[t.crn] = true
+		} else {
+			for code := range byTerm {
+				classes, err := p.parser.SearchSections(ctx, code, ndparser.SectionQuery{Subject: t.subject, CourseNumber: t.courseNumber})
+				if err != nil {
+					searchErr = err
+					continue
+				}
+				for _, class := range classes {
+					matches[class.CRN] = true
+				}
+			}
+		}
+
+		found := false
+		for code, rows := range byTerm {
+			for i, crn := range rows {
+				if !matches[crn.CRN] || !crn.Active {
+					continue
+				}
+				found = true
+
+				// Remove CRN from database
+				if err := p.db.RemoveTrackedCRNByID(userID, crn.ID); err != nil {
+					failed = append(failed, fmt.Sprintf("%s (%s)", crn.CRN, userError(err)))
+					continue
+				}
+				byTerm[code][i].Active = false
+				removed.WriteString(summaryLine(crn.CRN, "", fmt.Sprintf("%s, %s", crn.Title, p.termName(ctx, crn.Term))))
+			}
+		}
+		// A course whose search failed in some term is listed once, as failed rather than untracked
+		if searchErr != nil {
+			failed = append(failed, fmt.Sprintf("%s (%s)", t, userError(searchErr)))
+		} else if !found {
+			notTracked = append(notTracked, t.String())
+		}
+	}
+
+	var b strings.Builder
+	if removed.Len() > 0 {
+		fmt.Fprintf(&b, "Removed from your tracking list:\n%s", removed.String())
 	}
+	writeList(&b, "You are not tracking:", notTracked)
+	writeList(&b, "Failed:", failed)
 
-	return p.client.SendMessage(chatID, fmt.Sprintf("Removed CRN %s from your tracking list.", crn))
+	return p.reply(chatID, b.String())
+}
+
+// needsCurrentTerm reports whether /remove has to know the current term: only rows tracked before terms were
+// stored lack it, and only matching them against a given term or searching a course in their term needs it
+func needsCurrentTerm(crns []database.TrackedCRN, args targetArgs, termCode string) bool {
+	legacy := false
+	for _, crn := range crns {
+		if crn.Term == "" {
+			legacy = true
+			break
+		}
+	}
+	if !legacy {
+		return false
+	}
+	if termCode != "" {
+		return true
+	}
+	for _, t := range args.targets {
+		if t.crn == "" {
+			return true
+		}
+	}
+	return false
 }
 
 // listTrackedCRNs lists all CRNs tracked by the user
@@ -125,7 +611,7 @@ func (p *MessageProcessor) listTrackedCRNs(chatID int64, userID int64) error {
 	// Get all tracked CRNs for the user
 	crns, err := p.db.GetUserTrackedCRNs(userID)
 	if err != nil {
-		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %v", err))
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving tracked CRNs: %s", userError(err)))
 	}
 
 	if len(crns) == 0 {
@@ -135,29 +621,165 @@ func (p *MessageProcessor) listTrackedCRNs(chatID int64, userID int64) error {
 	// Format the response
 	response := "You are tracking the following classes:\n"
 	for _, crn := range crns {
-		response += fmt.Sprintf("- %s (%s)\n", crn.CRN, crn.Title)
+		response += fmt.Sprintf("- %s (%s, %s)\n", crn.CRN, crn.Title, p.termName(context.Background(), crn.Term))
+	}
+
+	_, err = p.client.SendMessageWithKeyboard(context.Background(), chatID, response, listKeyboard(crns))
+	return err
+}
+
+// listTerms lists the terms offered by the registration site
+func (p *MessageProcessor) listTerms(chatID int64) error {
+	ctx := context.Background()
+
+	terms, err := p.parser.Terms(ctx)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving terms: %s", userError(err)))
+	}
+
+	current, err := p.parser.CurrentTerm(ctx)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving current term: %s", userError(err)))
+	}
+
+	response := "Available terms:\n"
+	for _, term := range terms {
+		response += fmt.Sprintf("- %s (%s)", term.Description, term.Code)
+		if term.Code == current.Code {
+			response += " - current"
+		}
+		response += "\n"
 	}
 
 	return p.client.SendMessage(chatID, response)
 }
 
+// maxMessageLength is the longest text Telegram accepts in one message.
+// Telegram counts UTF-16 code units, and a text never has more of those than bytes, so it's applied to bytes.
+const maxMessageLength = 4096
+
+// reply sends a text that may be too long for one message, splitting it between lines,
+// or between runes when a line doesn't fit
+func (p *MessageProcessor) reply(chatID int64, text string) error {
+	for text != "" {
+		chunk := text
+		if len(chunk) > maxMessageLength {
+			cut := maxMessageLength
+			for cut > 0 && !utf8.RuneStart(chunk[cut]) {
+				cut--
+			}
+			chunk = chunk[:cut]
+			if i := strings.LastIndex(chunk, "\n"); i > 0 {
+				chunk = chunk[:i+1]
+			}
+		}
+		text = text[len(chunk):]
+
+		if err := p.client.SendMessage(chatID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // processMessage processes a regular message
 func (p *MessageProcessor) processMessage(chatID int64, text string) error {
 	// For now, just echo the message
 	return p.client.SendMessage(chatID, fmt.Sprintf("You said: %s", text))
 }
 
+// checkClasses checks the availability of the classes named in /check.
+// A single class gets the full details, several classes one line each.
+func (p *MessageProcessor) checkClasses(ctx context.Context, chatID int64, args targetArgs) error {
+	term, err := p.resolveTerm(ctx, args.term)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error resolving term: %s", userError(err)))
+	}
+
+	result, err := p.lookupTargets(ctx, term.Code, args.targets)
+	if err != nil {
+		return err
+	}
+
+	if len(result.classes) == 1 && len(result.notFound) == 0 && len(result.failed) == 0 {
+		return p.client.SendMessage(chatID, formatClass(result.classes[0], term.Description))
+	}
+
+	var b strings.Builder
+	if len(result.classes) > 0 {
+		fmt.Fprintf(&b, "Sections in %s:\n", term.Description)
+		for _, class := range result.classes {
+			fmt.Fprintf(&b, "%s\n", formatClassLine(class))
+		}
+	}
+	writeList(&b, fmt.Sprintf("Not found in %s:", term.Description), result.notFound)
+	writeList(&b, "Failed:", result.failed)
+
+	return p.reply(chatID, b.String())
+}
+
 // checkClassAvailability checks the availability of a class by CRN
-func (p *MessageProcessor) checkClassAvailability(chatID int64, crn string) error {
+func (p *MessageProcessor) checkClassAvailability(ctx context.Context, chatID int64, crn string, termQuery string) error {
+	term, err := p.resolveTerm(ctx, termQuery)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error resolving term: %s", userError(err)))
+	}
+
 	// Use the ND parser to check class availability
-	class, err := p.parser.SearchClass(context.Background(), crn)
+	class, err := p.parser.SearchClass(ctx, term.Code, crn)
 	if err != nil {
-		return p.client.SendMessage(chatID, fmt.Sprintf("Error checking class availability: %v", err))
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error checking class availability: %s", userError(err)))
 	}
 
-	// Format the response
-	response := fmt.Sprintf("Class CRN %s:\nTitle: %s\nSeats Available: %d",
-		class.CRN, class.Title, class.Seats)
+	return p.client.SendMessage(chatID, formatClass(class, term.Description))
+}
 
-	return p.client.SendMessage(chatID, response)
+// formatClassLine formats a section on one line, e.g. "12345 CSE 20311-01 Title: 3 seat(s) (27/30 enrolled), MWF 09:30-10:20"
+func formatClassLine(class *ndparser.Class) string {
+	line := class.CRN
+	if course := class.Course(); course != "" {
+		line += " " + course
+	}
+	line += fmt.Sprintf(" %s: %d seat(s)", class.Title, class.Seats)
+	if class.Capacity > 0 {
+		line += fmt.Sprintf(" (%d/%d enrolled)", class.Enrollment, class.Capacity)
+	}
+	for _, meeting := range class.Meetings {
+		line += ", " + meeting.String()
+	}
+
+	return line
+}
+
+// formatClass formats the section details shown by /check
+func formatClass(class *ndparser.Class, termName string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Class CRN %s:\n", class.CRN)
+	if course := class.Course(); course != "" {
+		fmt.Fprintf(&b, "Course: %s\n", course)
+	}
+	fmt.Fprintf(&b, "Term: %s\n", termName)
+	fmt.Fprintf(&b, "Title: %s\n", class.Title)
+	if len(class.Instructors) > 0 {
+		fmt.Fprintf(&b, "Instructor: %s\n", strings.Join(class.Instructors, "; "))
+	}
+	for _, meeting := range class.Meetings {
+		fmt.Fprintf(&b, "Meets: %s\n", meeting)
+	}
+	if class.CreditHours > 0 {
+		fmt.Fprintf(&b, "Credits: %g\n", class.CreditHours)
+	}
+	if class.ScheduleType != "" || class.Campus != "" {
+		fmt.Fprintf(&b, "Type: %s\n", strings.Trim(class.ScheduleType+", "+class.Campus, ", "))
+	}
+	fmt.Fprintf(&b, "Seats Available: %d", class.Seats)
+	if class.Capacity > 0 {
+		fmt.Fprintf(&b, " (%d/%d enrolled)", class.Enrollment, class.Capacity)
+	}
+	if class.WaitlistCapacity > 0 {
+		fmt.Fprintf(&b, "\nWaitlist: %d/%d", class.WaitlistCount, class.WaitlistCapacity)
+	}
+
+	return b.String()
 }
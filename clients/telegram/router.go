@@ -0,0 +1,194 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"NDClasses/clients/database"
+)
+
+// HandlerFunc runs a command
+type HandlerFunc func(ctx context.Context, req *Request) error
+
+// Middleware wraps a handler with behaviour shared by all commands
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Command describes a bot command and how it's invoked
+type Command struct {
+	Name        string   // Name without the slash, e.g. "add"
+	Aliases     []string // Other names the command answers to
+	Usage       string   // Arguments shown in the help, e.g. "CRN [term]"
+	Description string   // One line shown in the help and Telegram's command menu
+	MinArgs     int      // Fewer arguments get a usage hint instead of running the handler
+	Hidden      bool     // Hidden commands work but aren't listed
+	Admin       bool     // Admin commands only run in admin chats and are listed separately
+	Handler     HandlerFunc
+}
+
+// Request is a parsed command message
+type Request struct {
+	ChatID   int64
+	SenderID int64 // Telegram user who sent the command, 0 if unknown
+	Command  *Command
+	Name     string   // Name the command was invoked by, lowercased
+	Args     []string // Arguments split on whitespace
+	RawArgs  string   // Everything after the command name
+	User     *database.User
+}
+
+// Router dispatches command messages to the registered commands
+type Router struct {
+	commands   []*Command
+	byName     map[string]*Command
+	middleware []Middleware
+	botName    string
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{byName: make(map[string]*Command)}
+}
+
+// Handle registers a command under its name and aliases
+func (r *Router) Handle(cmd Command) {
+	c := &cmd
+	r.commands = append(r.commands, c)
+	r.byName[strings.ToLower(c.Name)] = c
+	for _, alias := range c.Aliases {
+		r.byName[strings.ToLower(alias)] = c
+	}
+}
+
+// Use adds middleware; the first one added runs outermost
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// SetBotName sets the bot's username, so that commands addressed to other bots (/cmd@OtherBot) are ignored
+func (r *Router) SetBotName(name string) {
+	r.botName = strings.TrimPrefix(name, "@")
+}
+
+// Commands returns the registered commands that are listed in the help
+func (r *Router) Commands() []Command {
+	var commands []Command
+	for _, c := range r.commands {
+		if !c.Hidden && !c.Admin {
+			commands = append(commands, *c)
+		}
+	}
+	return commands
+}
+
+// AdminCommands returns the registered admin commands that are listed in the admin help
+func (r *Router) AdminCommands() []Command {
+	var commands []Command
+	for _, c := range r.commands {
+		if !c.Hidden && c.Admin {
+			commands = append(commands, *c)
+		}
+	}
+	return commands
+}
+
+// Help returns the list of commands with their usage
+func (r *Router) Help() string {
+	return help(r.Commands())
+}
+
+// AdminHelp returns the list of admin commands with their usage
+func (r *Router) AdminHelp() string {
+	return help(r.AdminCommands())
+}
+
+// help formats one line per command, e.g. "/add CRN [term] - Add classes to track"
+func help(commands []Command) string {
+	var b strings.Builder
+	for _, c := range commands {
+		fmt.Fprintf(&b, "%s - %s\n", usage(&c), c.Description)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// usage formats how a command is invoked, e.g. "/add CRN [term]"
+func usage(c *Command) string {
+	if c.Usage == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Usage
+}
+
+// Route finds the command for a message and prepares its request.
+// It returns false if the message isn't a command for this bot.
+func (r *Router) Route(chatID int64, text string) (*Request, bool) {
+	head, botName, rawArgs, ok := parseCommand(text)
+	if !ok {
+		return nil, false
+	}
+
+	// In group chats commands can be addressed to a specific bot
+	if botName != "" && r.botName != "" && !strings.EqualFold(botName, r.botName) {
+		return nil, false
+	}
+
+	name := strings.ToLower(head)
+	cmd := r.byName[name]
+
+	// Telegram commands can't contain spaces, so tappable links like /check_12345 carry their argument after an underscore
+	if prefix, arg, found := strings.Cut(head, "_"); cmd == nil && found {
+		if cmd = r.byName[strings.ToLower(prefix)]; cmd != nil {
+			name = strings.ToLower(prefix)
+			rawArgs = strings.TrimSpace(arg + " " + rawArgs)
+		}
+	}
+
+	return &Request{
+		ChatID:  chatID,
+		Command: cmd,
+		Name:    name,
+		Args:    strings.Fields(rawArgs),
+		RawArgs: rawArgs,
+	}, true
+}
+
+// Dispatch runs the request's command through the middleware.
+// Unknown commands and missing arguments are answered through reply.
+func (r *Router) Dispatch(ctx context.Context, req *Request, reply func(text string) error) error {
+	handler := func(ctx context.Context, req *Request) error {
+		if req.Command == nil {
+			return reply("Unknown command. Type /help for available commands.")
+		}
+		if len(req.Args) < req.Command.MinArgs {
+			return reply("Usage: " + usage(req.Command))
+		}
+		return req.Command.Handler(ctx, req)
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+
+	return handler(ctx, req)
+}
+
+// parseCommand splits "/Name@Bot args" into the name, the bot name and the arguments
+func parseCommand(text string) (string, string, string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", "", "", false
+	}
+
+	head, rawArgs := text[1:], ""
+	if i := strings.IndexFunc(head, unicode.IsSpace); i >= 0 {
+		head, rawArgs = head[:i], head[i:]
+	}
+
+	name, botName, _ := strings.Cut(head, "@")
+	if name == "" {
+		return "", "", "", false
+	}
+
+	return name, botName, strings.TrimSpace(rawArgs), true
+}
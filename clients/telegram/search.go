@@ -0,0 +1,273 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"NDClasses/clients/ndparser"
+)
+
+// searchPageSize is how many sections one page of /search results shows
+const searchPageSize = 5
+
+// minKeywordLength keeps keyword searches from matching most of the catalog
+const minKeywordLength = 3
+
+// searchTTL is how long the buttons of a search keep working after it was last used
+const searchTTL = time.Hour
+
+// searchSession is the latest /search of a chat, kept so its result pages can be turned
+type searchSession struct {
+	query     ndparser.SectionQuery
+	term      ndparser.Term
+	messageID int              // Message showing the results
+	classes   []ndparser.Class // Sections on the page shown
+	used      time.Time        // When the search was run or a button last pressed
+}
+
+// searchSession returns a copy of the chat's latest search, unless it has expired, marking it as used.
+// Callback queries are handled concurrently, so the stored session is only touched under searchMu.
+func (p *MessageProcessor) searchSession(chatID int64) (searchSession, bool) {
+	p.searchMu.Lock()
+	defer p.searchMu.Unlock()
+
+	session, ok := p.searches[chatID]
+	if !ok || time.Since(session.used) > searchTTL {
+		return searchSession{}, false
+	}
+	session.used = time.Now()
+	return *session, true
+}
+
+// showSearchPage records the sections now shown by a search's message, if it's still the chat's latest search
+func (p *MessageProcessor) showSearchPage(chatID int64, messageID int, classes []ndparser.Class) {
+	p.searchMu.Lock()
+	defer p.searchMu.Unlock()
+
+	if session, ok := p.searches[chatID]; ok && session.messageID == messageID {
+		session.classes = classes
+	}
+}
+
+// rememberSearch keeps a chat's latest search, dropping searches nobody has used in a while
+func (p *MessageProcessor) rememberSearch(chatID int64, session *searchSession) {
+	p.searchMu.Lock()
+	defer p.searchMu.Unlock()
+
+	now := time.Now()
+	for id, old := range p.searches {
+		if now.Sub(old.used) > searchTTL {
+			delete(p.searches, id)
+		}
+	}
+
+	session.used = now
+	p.searches[chatID] = session
+}
+
+// parseSearchQuery turns /search arguments into a query: a course ("CSE 20311" or "CSE20311"),
+// a subject in capitals ("CSE") or a title keyword ("algorithms")
+func parseSearchQuery(rawArgs string) (ndparser.SectionQuery, bool) {
+	fields := strings.Fields(rawArgs)
+
+	switch {
+	case len(fields) == 1 && courseCodePattern.MatchString(fields[0]):
+		m := courseCodePattern.FindStringSubmatch(fields[0])
+		return ndparser.SectionQuery{Subject: strings.ToUpper(m[1]), CourseNumber: m[2]}, true
+	case len(fields) == 2 && subjectPattern.MatchString(fields[0]) && courseNumberPattern.MatchString(fields[1]):
+		return ndparser.SectionQuery{Subject: strings.ToUpper(fields[0]), CourseNumber: fields[1]}, true
+	case len(fields) == 1 && subjectPattern.MatchString(fields[0]) && strings.ToUpper(fields[0]) == fields[0]:
+		return ndparser.SectionQuery{Subject: fields[0]}, true
+	}
+
+	keyword := strings.Join(fields, " ")
+	if len(keyword) < minKeywordLength {
+		return ndparser.SectionQuery{}, false
+	}
+
+	return ndparser.SectionQuery{Keyword: keyword}, true
+}
+
+// describeQuery formats a query the way the user typed it
+func describeQuery(query ndparser.SectionQuery) string {
+	if query.Keyword != "" {
+		return fmt.Sprintf("%q", query.Keyword)
+	}
+	return strings.TrimSpace(query.Subject + " " + query.CourseNumber)
+}
+
+// search runs a /search in the current term and sends the first page of results
+func (p *MessageProcessor) search(ctx context.Context, chatID int64, query ndparser.SectionQuery) error {
+	term, err := p.parser.CurrentTerm(ctx)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error retrieving current term: %s", userError(err)))
+	}
+
+	page, err := p.parser.SearchPage(ctx, term.Code, query, 0, searchPageSize)
+	if err != nil {
+		return p.client.SendMessage(chatID, fmt.Sprintf("Error searching classes: %s", userError(err)))
+	}
+
+	if page.Total == 0 {
+		return p.client.SendMessage(chatID, fmt.Sprintf("No sections found for %s in %s.", describeQuery(query), term.Description))
+	}
+
+	msg, err := p.client.SendMessageWithKeyboard(ctx, chatID, formatSearchPage(query, term, page), searchKeyboard(term, page))
+	if err != nil {
+		return err
+	}
+
+	p.rememberSearch(chatID, &searchSession{query: query, term: term, messageID: msg.MessageID, classes: page.Classes})
+
+	return nil
+}
+
+// turnSearchPage shows another page of the chat's latest search in place of the current one
+func (p *MessageProcessor) turnSearchPage(ctx context.Context, query *CallbackQuery, arg string) error {
+	chatID := query.Message.Chat.ID
+
+	offset, err := strconv.Atoi(arg)
+	if err != nil || offset < 0 {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "Unknown button.")
+	}
+
+	session, ok := p.searchSession(chatID)
+	if !ok || session.messageID != query.Message.MessageID {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "This search has expired, please search again.")
+	}
+
+	if err := p.client.AnswerCallbackQuery(ctx, query.ID, ""); err != nil {
+		return err
+	}
+
+	p.spawn(chatID, func(ctx context.Context) error {
+		page, err := p.parser.SearchPage(ctx, session.term.Code, session.query, offset, searchPageSize)
+		if err != nil {
+			return p.client.SendMessage(chatID, fmt.Sprintf("Error searching classes: %s", userError(err)))
+		}
+
+		p.showSearchPage(chatID, session.messageID, page.Classes)
+
+		return p.client.EditMessageText(ctx, chatID, session.messageID, formatSearchPage(session.query, session.term, page), searchKeyboard(session.term, page))
+	})
+
+	return nil
+}
+
+// trackSearchResult adds a section from the search results to the user's tracking list
+func (p *MessageProcessor) trackSearchResult(ctx context.Context, query *CallbackQuery, arg string) error {
+	chatID := query.Message.Chat.ID
+
+	termCode, crn, ok := strings.Cut(arg, ":")
+	if !ok || !crnPattern.MatchString(crn) {
+		return p.client.AnswerCallbackQuery(ctx, query.ID, "Unknown button.")
+	}
+
+	user, err := p.db.CreateUser(chatID, "")
+	if err != nil {
+		return errors.Join(fmt.Errorf("can't load user: %w", err), p.client.AnswerCallbackQuery(ctx, query.ID, ""))
+	}
+
+	// The title is known if the section is still on the page; otherwise it has to be searched again
+	var class *ndparser.Class
+	if session, ok := p.searchSession(chatID); ok && session.term.Code == termCode {
+		for i := range session.classes {
+			if session.classes[i].CRN == crn {
+				class = &session.classes[i]
+			}
+		}
+	}
+
+	if class == nil {
+		if err := p.client.AnswerCallbackQuery(ctx, query.ID, ""); err != nil {
+			return err
+		}
+		p.spawn(chatID, func(ctx context.Context) error {
+			return p.addTrackedCRNs(ctx, chatID, user.ID, targetArgs{targets: []target{{crn: crn}}, term: termCode})
+		})
+		return nil
+	}
+
+	crns, err := p.db.GetUserTrackedCRNs(user.ID)
+	if err != nil {
+		return errors.Join(fmt.Errorf("can't get tracked CRNs: %w", err), p.client.AnswerCallbackQuery(ctx, query.ID, ""))
+	}
+	for _, tracked := range crns {
+		if tracked.CRN == crn && p.sameTerm(ctx, tracked.Term, termCode) {
+			return p.client.AnswerCallbackQuery(ctx, query.ID, "You are already tracking CRN "+crn)
+		}
+	}
+
+	if _, err := p.db.AddTrackedCRN(user.ID, crn, termCode, class.Title); err != nil {
+		answerErr := p.client.AnswerCallbackQuery(ctx, query.ID, "")
+		return errors.Join(answerErr, p.client.SendMessage(chatID, fmt.Sprintf("Error adding CRN to tracking list: %s", userError(err))))
+	}
+
+	return p.client.AnswerCallbackQuery(ctx, query.ID, fmt.Sprintf("Added CRN %s (%s) to your tracking list.", crn, class.Title))
+}
+
+// sameTerm reports whether a tracked row's term is the given one.
+// Rows tracked before terms were stored have an empty term, meaning the current one.
+func (p *MessageProcessor) sameTerm(ctx context.Context, rowTerm string, termCode string) bool {
+	if rowTerm != "" {
+		return rowTerm == termCode
+	}
+	current, err := p.parser.CurrentTerm(ctx)
+	if err != nil {
+		p.logger.Warn("Error resolving current term: %v", err)
+		return false
+	}
+	return current.Code == termCode
+}
+
+// formatSearchPage formats one page of search results
+func formatSearchPage(query ndparser.SectionQuery, term ndparser.Term, page *ndparser.SectionPage) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Results for %s in %s (%d-%d of %d):\n",
+		describeQuery(query), term.Description, page.Offset+1, page.Offset+len(page.Classes), page.Total)
+
+	for _, class := range page.Classes {
+		fmt.Fprintf(&b, "\n%s %s %s\n", class.CRN, class.Course(), class.Title)
+		fmt.Fprintf(&b, "Seats: %d", class.Seats)
+		if class.Capacity > 0 {
+			fmt.Fprintf(&b, " (%d/%d enrolled)", class.Enrollment, class.Capacity)
+		}
+		b.WriteString("\n")
+		if len(class.Instructors) > 0 {
+			fmt.Fprintf(&b, "Instructor: %s\n", strings.Join(class.Instructors, "; "))
+		}
+		for _, meeting := range class.Meetings {
+			fmt.Fprintf(&b, "Meets: %s\n", meeting)
+		}
+	}
+
+	return b.String()
+}
+
+// searchKeyboard returns a "Track" button for each section on the page and buttons to turn pages
+func searchKeyboard(term ndparser.Term, page *ndparser.SectionPage) *InlineKeyboardMarkup {
+	keyboard := &InlineKeyboardMarkup{}
+	for _, class := range page.Classes {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []InlineKeyboardButton{
+			{Text: "Track " + class.CRN, CallbackData: callbackTrack + ":" + term.Code + ":" + class.CRN},
+		})
+	}
+
+	var nav []InlineKeyboardButton
+	if page.Offset > 0 {
+		nav = append(nav, InlineKeyboardButton{Text: "« Previous", CallbackData: callbackPage + ":" + strconv.Itoa(max(page.Offset-searchPageSize, 0))})
+	}
+	if next := page.Offset + len(page.Classes); next < page.Total {
+		nav = append(nav, InlineKeyboardButton{Text: "Next »", CallbackData: callbackPage + ":" + strconv.Itoa(next)})
+	}
+	if len(nav) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, nav)
+	}
+
+	return keyboard
+}
@@ -0,0 +1,177 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"NDClasses/clients/ndparser"
+)
+
+// maxTargets caps the classes one command can name, since each CRN costs a search on the registration site
+const maxTargets = 10
+
+var (
+	crnPattern          = regexp.MustCompile(`^[0-9]{5}$`)
+	subjectPattern      = regexp.MustCompile(`^[A-Za-z]{2,5}$`)
+	courseNumberPattern = regexp.MustCompile(`^[0-9]{5}$`)
+	courseCodePattern   = regexp.MustCompile(`^([A-Za-z]{2,5})([0-9]{5})$`)
+	termCodePattern     = regexp.MustCompile(`^[0-9]{6}$`)
+)
+
+// target is a class named in a command: a single CRN, or a course standing for all of its sections
+type target struct {
+	crn          string
+	subject      string
+	courseNumber string
+}
+
+// String returns the target the way users write it
+func (t target) String() string {
+	if t.crn != "" {
+		return t.crn
+	}
+	return t.subject + " " + t.courseNumber
+}
+
+// targetArgs is the parsed argument list of /add, /remove and /check
+type targetArgs struct {
+	targets []target
+	invalid []string // Numbers that can't be CRNs
+	term    string   // Term query following the classes
+}
+
+// parseTargets parses "12345 23456 CSE 20311 [term]".
+// CRNs are five digits and courses are a subject followed by a course number, written apart or together.
+// The first argument that is neither starts the term query, so "12345 Spring 2026" still works.
+func parseTargets(rawArgs string) targetArgs {
+	var args targetArgs
+
+	fields := strings.FieldsFunc(rawArgs, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		switch {
+		case crnPattern.MatchString(field):
+			args.targets = append(args.targets, target{crn: field})
+		case courseCodePattern.MatchString(field):
+			m := courseCodePattern.FindStringSubmatch(field)
+			args.targets = append(args.targets, target{subject: strings.ToUpper(m[1]), courseNumber: m[2]})
+		case subjectPattern.MatchString(field) && i+1 < len(fields) && courseNumberPattern.MatchString(fields[i+1]):
+			args.targets = append(args.targets, target{subject: strings.ToUpper(field), courseNumber: fields[i+1]})
+			i++
+		case isDigits(field) && !termCodePattern.MatchString(field):
+			args.invalid = append(args.invalid, field)
+		default:
+			args.term = strings.Join(fields[i:], " ")
+			return args
+		}
+	}
+
+	return args
+}
+
+// isDigits reports whether s consists of digits only
+func isDigits(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) }) < 0
+}
+
+// validateTargets returns the message explaining why the arguments can't be used, or "" if they can
+func validateTargets(args targetArgs, command string) string {
+	if len(args.invalid) > 0 {
+		return fmt.Sprintf("Invalid CRN(s): %s. CRNs are 5-digit numbers.", strings.Join(args.invalid, ", "))
+	}
+	if len(args.targets) == 0 {
+		return fmt.Sprintf("Usage: /%s CRN [CRN...] or /%s SUBJECT NUMBER [term], e.g. /%s CSE 20311", command, command, command)
+	}
+	if len(args.targets) > maxTargets {
+		return fmt.Sprintf("Too many classes, please name at most %d at once.", maxTargets)
+	}
+	return ""
+}
+
+// lookupResult is what searching the registration site found for a list of targets
+type lookupResult struct {
+	classes  []*ndparser.Class
+	notFound []string
+	failed   []string
+}
+
+// lookupTargets searches the registration site for the targets in a term.
+// Courses expand to all of their sections; each class appears once even if it's named twice.
+func (p *MessageProcessor) lookupTargets(ctx context.Context, term string, targets []target) (lookupResult, error) {
+	var result lookupResult
+	seen := make(map[string]bool)
+
+	add := func(class *ndparser.Class) {
+		if !seen[class.CRN] {
+			seen[class.CRN] = true
+			result.classes = append(result.classes, class)
+		}
+	}
+
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		if t.crn != "" {
+			if seen[t.crn] {
+				continue
+			}
+
+			class, err := p.parser.SearchClass(ctx, term, t.crn)
+			if errors.Is(err, ndparser.ErrClassNotFound) {
+				result.notFound = append(result.notFound, t.String())
+			} else if err != nil {
+				result.failed = append(result.failed, fmt.Sprintf("%s (%s)", t, userError(err)))
+			} else {
+				add(class)
+			}
+			continue
+		}
+
+		classes, err := p.parser.SearchSections(ctx, term, ndparser.SectionQuery{Subject: t.subject, CourseNumber: t.courseNumber})
+		if err != nil {
+			result.failed = append(result.failed, fmt.Sprintf("%s (%s)", t, userError(err)))
+			continue
+		}
+		if len(classes) == 0 {
+			result.notFound = append(result.notFound, t.String())
+		}
+		for i := range classes {
+			add(&classes[i])
+		}
+	}
+
+	return result, nil
+}
+
+// summaryLine formats one class in a combined reply, e.g. "12345 CSE 20311-01 Title"
+func summaryLine(crn string, course string, title string) string {
+	line := "- " + crn
+	if course != "" {
+		line += " " + course
+	}
+	if title != "" {
+		line += " " + title
+	}
+	return line + "\n"
+}
+
+// writeList appends a titled list of items to a reply, skipping empty lists
+func writeList(b *strings.Builder, heading string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "%s\n", heading)
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", item)
+	}
+}
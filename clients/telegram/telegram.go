@@ -10,32 +10,93 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"NDClasses/clients/logger"
 )
 
 type Client struct {
 	host     string
+	token    string // Kept to be masked in errors; see redactURL
 	basePath string
 	client   http.Client
 	timeout  time.Duration
+
+	// Delays between retries of failed requests
+	retryBase time.Duration
+	retryMax  time.Duration
+
+	// outbox is shared by all copies of the client, so that every sender obeys the same limits
+	outbox *Outbox
+
+	// lastPoll is the Unix time, in nanoseconds, of the last successful getUpdates, shared like outbox
+	lastPoll *atomic.Int64
+
+	logger *logger.Logger
 }
 
+// pollTimeout is how long Telegram holds a getUpdates request open waiting for updates
+const pollTimeout = 30 * time.Second
+
+// New creates a client for the bot with the given token. The token is masked in log output from then on.
 func New(host string, token string) Client {
+	logger.AddSecret(token)
+
 	return Client{
-		host:     host,
-		basePath: "bot" + token,
-		client:   http.Client{},
-		timeout:  5 * time.Second, // Default timeout of 30 seconds
+		host:      host,
+		token:     token,
+		basePath:  "bot" + token,
+		client:    http.Client{},
+		timeout:   5 * time.Second, // Default timeout of 30 seconds
+		retryBase: 1 * time.Second,
+		retryMax:  1 * time.Minute,
+		outbox:    newOutbox(),
+		lastPoll:  &atomic.Int64{},
+		logger:    logger.Default(),
+	}
+}
+
+// SetLogger changes where the client reports failed requests
+func (c *Client) SetLogger(l *logger.Logger) {
+	c.logger = l
+}
+
+// Outbox returns the queue outgoing messages go through
+func (c *Client) Outbox() *Outbox {
+	return c.outbox
+}
+
+// LastPoll returns when getUpdates last succeeded, or the zero time if it hasn't yet
+func (c *Client) LastPoll() time.Time {
+	last := c.lastPoll.Load()
+	if last == 0 {
+		return time.Time{}
 	}
+	return time.Unix(0, last)
+}
+
+// SetBackoff changes the delay before the first retry of a failed request and the cap it grows to
+func (c *Client) SetBackoff(base time.Duration, max time.Duration) {
+	c.retryBase = base
+	c.retryMax = max
 }
 
 func (c *Client) Updates(offset int, limit int) ([]Update, error) {
+	return c.updates(context.Background(), offset, limit, 0)
+}
+
+// updates requests updates, waiting up to wait for new ones to arrive (long polling)
+func (c *Client) updates(ctx context.Context, offset int, limit int, wait time.Duration) ([]Update, error) {
 	q := url.Values{}
 	q.Add("offset", strconv.Itoa(offset))
 	q.Add("limit", strconv.Itoa(limit))
+	if wait > 0 {
+		q.Add("timeout", strconv.Itoa(int(wait.Seconds())))
+	}
 
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	// Create context with timeout, leaving room for Telegram to hold the request
+	ctx, cancel := context.WithTimeout(ctx, wait+c.timeout)
 	defer cancel()
 
 	data, err := c.doRequest(ctx, "getUpdates", q)
@@ -48,53 +109,239 @@ func (c *Client) Updates(offset int, limit int) ([]Update, error) {
 		return nil, fmt.Errorf("can't parse json: %w", err)
 	}
 
+	if !resp.Ok {
+		return nil, apiError("getUpdates", APIResponse{
+			Ok:          resp.Ok,
+			ErrorCode:   resp.ErrorCode,
+			Description: resp.Description,
+			Parameters:  resp.Parameters,
+		})
+	}
+
+	c.lastPoll.Store(time.Now().UnixNano())
 	return resp.Result, nil
 }
 
-// PollUpdates starts polling for updates and processes them
-func (c *Client) PollUpdates(processor *MessageProcessor) error {
+// Run long-polls for updates and processes them until ctx is cancelled.
+// Updates are processed in the background; the processor's Shutdown waits for them.
+// Failed polls are retried with exponential backoff, honoring Telegram's retry_after.
+// It returns nil after a cancellation and an error only when Telegram rejects the bot token.
+func (c *Client) Run(ctx context.Context, processor *MessageProcessor) error {
 	offset := 0
+	attempt := 0
+
+	// getUpdates doesn't work while a webhook is set, e.g. after switching back from webhook mode
+	for {
+		err := c.DeleteWebhook(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if isInvalidToken(err) {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		if !c.backoff(ctx, err, attempt) {
+			return nil
+		}
+		attempt++
+	}
 
+	attempt = 0
 	for {
 		// Get updates
-		updates, err := c.Updates(offset, 100)
+		updates, err := c.updates(ctx, offset, 100, pollTimeout)
+		if ctx.Err() != nil {
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("failed to get updates: %w", err)
+			if isInvalidToken(err) {
+				return fmt.Errorf("failed to get updates: %w", err)
+			}
+			if !c.backoff(ctx, err, attempt) {
+				return nil
+			}
+			attempt++
+			continue
 		}
+		attempt = 0
 
-		// Process each update
+		// Hand each update to its chat's worker, so slow replies don't hold up polling
 		for _, update := range updates {
-			// Process the update
-			if err := processor.ProcessUpdate(update); err != nil {
-				fmt.Printf("Error processing update: %v\n", err)
-			}
+			processor.Enqueue(update)
 
 			// Update offset to avoid processing the same update again
 			if update.ID >= offset {
 				offset = update.ID + 1
 			}
 		}
+	}
+}
+
+// backoff logs a failed poll and waits before the next attempt.
+// It returns false if ctx was cancelled while waiting.
+func (c *Client) backoff(ctx context.Context, err error, attempt int) bool {
+	delay := retryDelay(err, attempt, c.retryBase, c.retryMax)
+	c.logger.With("retry_in", delay, "attempt", attempt+1).Warn("Telegram request failed: %v", err)
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// call performs a Bot API method that returns nothing but ok and fails when Telegram rejects it
+func (c *Client) call(ctx context.Context, method string, query url.Values) error {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
 
-		// Sleep for a bit before polling again
-		time.Sleep(1 * time.Second)
+	data, err := c.doRequest(ctx, method, query)
+	if err != nil {
+		return fmt.Errorf("can't call %s: %w", method, err)
+	}
+
+	var resp APIResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("can't parse json: %w", err)
 	}
+
+	if !resp.Ok {
+		return apiError(method, resp)
+	}
+
+	return nil
 }
 
+// SendMessage sends a text message through the outbox and waits until it's delivered or has finally failed
 func (c *Client) SendMessage(chatID int64, text string) error {
+	_, err := c.SendMessageContext(context.Background(), chatID, text)
+	return err
+}
+
+// SendMessageContext is like SendMessage, but gives up when ctx is done and returns the sent message
+func (c *Client) SendMessageContext(ctx context.Context, chatID int64, text string) (Message, error) {
+	return c.SendMessageWithKeyboard(ctx, chatID, text, nil)
+}
+
+// SendMessageWithKeyboard sends a text message with an inline keyboard below it; a nil keyboard sends none
+func (c *Client) SendMessageWithKeyboard(ctx context.Context, chatID int64, text string, keyboard *InlineKeyboardMarkup) (Message, error) {
 	q := url.Values{}
 	q.Add("chat_id", strconv.FormatInt(chatID, 10))
 	q.Add("text", text)
+	if keyboard != nil {
+		markup, err := json.Marshal(keyboard)
+		if err != nil {
+			return Message{}, fmt.Errorf("can't encode keyboard: %w", err)
+		}
+		q.Add("reply_markup", string(markup))
+	}
+
+	backoff := func(err error, attempt int) time.Duration {
+		return retryDelay(err, attempt, c.retryBase, c.retryMax)
+	}
+
+	msg, err := c.outbox.deliver(ctx, chatID, backoff, func(ctx context.Context) (Message, error) {
+		return c.sendMessage(ctx, q)
+	})
+	if err != nil {
+		return Message{}, fmt.Errorf("can't send message: %w", err)
+	}
+
+	return msg, nil
+}
 
+// GetMe returns the bot's own user, e.g. to learn its username
+func (c *Client) GetMe(ctx context.Context) (User, error) {
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	_, err := c.doRequest(ctx, "sendMessage", q)
+	data, err := c.doRequest(ctx, "getMe", url.Values{})
 	if err != nil {
-		return fmt.Errorf("can't send message: %w", err)
+		return User{}, fmt.Errorf("can't call getMe: %w", err)
 	}
 
-	return nil
+	var resp struct {
+		APIResponse
+		Result User `json:"result"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return User{}, fmt.Errorf("can't parse json: %w", err)
+	}
+
+	if !resp.Ok {
+		return User{}, apiError("getMe", resp.APIResponse)
+	}
+
+	return resp.Result, nil
+}
+
+// SetMyCommands replaces the command menu Telegram shows to users
+func (c *Client) SetMyCommands(ctx context.Context, commands []BotCommand) error {
+	data, err := json.Marshal(commands)
+	if err != nil {
+		return fmt.Errorf("can't encode commands: %w", err)
+	}
+
+	q := url.Values{}
+	q.Add("commands", string(data))
+
+	return c.call(ctx, "setMyCommands", q)
+}
+
+// EditMessageText replaces the text and inline keyboard of a message the bot sent earlier
+func (c *Client) EditMessageText(ctx context.Context, chatID int64, messageID int, text string, keyboard *InlineKeyboardMarkup) error {
+	q := url.Values{}
+	q.Add("chat_id", strconv.FormatInt(chatID, 10))
+	q.Add("message_id", strconv.Itoa(messageID))
+	q.Add("text", text)
+	if keyboard != nil {
+		markup, err := json.Marshal(keyboard)
+		if err != nil {
+			return fmt.Errorf("can't encode keyboard: %w", err)
+		}
+		q.Add("reply_markup", string(markup))
+	}
+
+	return c.call(ctx, "editMessageText", q)
+}
+
+// AnswerCallbackQuery stops the loading indicator on a pressed button, showing text as a notification if it isn't empty
+func (c *Client) AnswerCallbackQuery(ctx context.Context, callbackQueryID string, text string) error {
+	q := url.Values{}
+	q.Add("callback_query_id", callbackQueryID)
+	if text != "" {
+		q.Add("text", text)
+	}
+
+	return c.call(ctx, "answerCallbackQuery", q)
+}
+
+// sendMessage performs a single sendMessage request
+func (c *Client) sendMessage(ctx context.Context, query url.Values) (Message, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	data, err := c.doRequest(ctx, "sendMessage", query)
+	if err != nil {
+		return Message{}, err
+	}
+
+	var resp SendMessageResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return Message{}, fmt.Errorf("can't parse json: %w", err)
+	}
+
+	if !resp.Ok {
+		return Message{}, apiError("sendMessage", resp.APIResponse)
+	}
+
+	return resp.Result, nil
 }
 
 func (c *Client) doRequest(ctx context.Context, method string, query url.Values) ([]byte, error) {
@@ -113,14 +360,15 @@ func (c *Client) doRequest(ctx context.Context, method string, query url.Values)
 	const errMsg = "can't do request: %w"
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
 	if err != nil {
-		return nil, fmt.Errorf(errMsg, err)
+		return nil, fmt.Errorf(errMsg, c.redactURL(err))
 	}
 
 	req.URL.RawQuery = query.Encode()
 
+	// Errors from http.Client quote the URL, which holds the token
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf(errMsg, err)
+		return nil, fmt.Errorf(errMsg, c.redactURL(err))
 	}
 
 	defer resp.Body.Close()
@@ -129,5 +377,10 @@ func (c *Client) doRequest(ctx context.Context, method string, query url.Values)
 	if err != nil {
 		return nil, fmt.Errorf(errMsg, err)
 	}
+
+	// A proxy in front of the Bot API answers failures with an HTML page; keep the status so they can be retried
+	if resp.StatusCode >= http.StatusInternalServerError && !json.Valid(body) {
+		return nil, apiError(method, APIResponse{ErrorCode: resp.StatusCode, Description: http.StatusText(resp.StatusCode)})
+	}
 	return body, nil
 }
@@ -1,17 +1,22 @@
 package telegram
 
 type UpdatesResponse struct {
-	Ok     bool     `json:"ok"`
-	Result []Update `json:"result"`
+	Ok          bool                `json:"ok"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+	Result      []Update            `json:"result"`
 }
 
 type Update struct {
-	ID      int     `json:"update_id"`
-	Message Message `json:"message"`
+	ID            int            `json:"update_id"`
+	Message       Message        `json:"message"`
+	CallbackQuery *CallbackQuery `json:"callback_query,omitempty"`
 }
 
 type Message struct {
 	MessageID int    `json:"message_id"`
+	From      *User  `json:"from,omitempty"` // Sender; missing in channels
 	Chat      Chat   `json:"chat"`
 	Text      string `json:"text"`
 }
@@ -21,12 +26,58 @@ type Chat struct {
 	Type string `json:"type"`
 }
 
+// User represents a Telegram user or bot
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// BotCommand is an entry of the command menu Telegram shows to users
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// CallbackQuery is sent when a user presses an inline keyboard button
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message,omitempty"` // Message the button belongs to
+	Data    string   `json:"data"`
+}
+
+// InlineKeyboardMarkup is a keyboard shown right below a message
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton is a button that sends CallbackData back to the bot when pressed
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
 type SendMessageRequest struct {
-	ChatID int64  `json:"chat_id"`
-	Text   string `json:"text"`
+	ChatID      int64                 `json:"chat_id"`
+	Text        string                `json:"text"`
+	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
 }
 
 type SendMessageResponse struct {
-	Ok     bool    `json:"ok"`
+	APIResponse
 	Result Message `json:"result"`
 }
+
+// APIResponse represents the common part of every Bot API response
+type APIResponse struct {
+	Ok          bool                `json:"ok"`
+	ErrorCode   int                 `json:"error_code,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Parameters  *ResponseParameters `json:"parameters,omitempty"`
+}
+
+// ResponseParameters explains why a request failed and how it can be repeated
+type ResponseParameters struct {
+	RetryAfter      int   `json:"retry_after,omitempty"`
+	MigrateToChatID int64 `json:"migrate_to_chat_id,omitempty"`
+}
@@ -0,0 +1,155 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// secretTokenHeader carries the webhook secret on every update Telegram delivers
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// maxUpdateSize bounds the body of a posted update; Telegram's updates are far smaller
+const maxUpdateSize = 1 << 20
+
+// WebhookConfig describes where Telegram delivers updates in webhook mode
+type WebhookConfig struct {
+	URL         string // Public HTTPS URL registered with Telegram, including the path
+	ListenAddr  string // Local address the HTTP server listens on, e.g. ":8080"
+	Path        string // Local path updates are posted to, e.g. "/telegram/webhook"
+	SecretToken string // Secret Telegram sends back in every request; generated when empty
+}
+
+// SetWebhook registers the URL Telegram delivers updates to
+func (c *Client) SetWebhook(ctx context.Context, webhookURL string, secretToken string) error {
+	q := url.Values{}
+	q.Add("url", webhookURL)
+	if secretToken != "" {
+		q.Add("secret_token", secretToken)
+	}
+
+	return c.call(ctx, "setWebhook", q)
+}
+
+// DeleteWebhook removes the webhook so updates can be received with getUpdates again
+func (c *Client) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", url.Values{})
+}
+
+// NewWebhookHandler creates an HTTP handler that feeds updates posted by Telegram to the processor.
+// Requests without the secret token are rejected. Updates are answered once queued, before they're processed.
+func NewWebhookHandler(processor *MessageProcessor, secretToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(secretTokenHeader)), []byte(secretToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var update Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Telegram redelivers updates that get a non-2xx answer, so the update is acknowledged once it's queued;
+		// the processor handles and logs it later, and a failure then doesn't make Telegram send it again
+		processor.Enqueue(update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// RunWebhook registers the webhook and serves updates until ctx is cancelled.
+// It returns nil after a cancellation, and otherwise the error that stopped the server or kept the webhook from being set.
+func (c *Client) RunWebhook(ctx context.Context, processor *MessageProcessor, config WebhookConfig) error {
+	if config.SecretToken == "" {
+		token, err := newSecretToken()
+		if err != nil {
+			return err
+		}
+		config.SecretToken = token
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(config.Path, NewWebhookHandler(processor, config.SecretToken))
+	server := &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Start listening before Telegram is told to send updates
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	// Telegram may be out of reach for a while, so setting the webhook is retried with the same backoff as polling.
+	// Errors Telegram says won't go away, like a rejected token or URL, stop the bot instead.
+	for attempt := 0; ; attempt++ {
+		err := c.SetWebhook(ctx, config.URL, config.SecretToken)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			server.Close()
+			return nil
+		}
+		var apiErr *Error
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			server.Close()
+			return fmt.Errorf("failed to set webhook: %w", err)
+		}
+		select {
+		case err := <-serverErr:
+			return fmt.Errorf("webhook server failed: %w", err)
+		default:
+		}
+		if !c.backoff(ctx, err, attempt) {
+			server.Close()
+			return nil
+		}
+	}
+	c.logger.Info("Receiving updates on %s%s", config.ListenAddr, config.Path)
+
+	select {
+	case err := <-serverErr:
+		return fmt.Errorf("webhook server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	// Let updates being processed finish; Telegram keeps new ones until we're back
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("can't shut down webhook server: %w", err)
+	}
+
+	return nil
+}
+
+// newSecretToken generates a random webhook secret
+func newSecretToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("can't generate secret token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -15,14 +17,74 @@ type Client struct {
 	timeout time.Duration
 }
 
-// New creates a new web client
+// New creates a new web client with its own cookie session
 func New() Client {
+	// cookiejar.New only fails on invalid options, so the error is ignored
+	jar, _ := cookiejar.New(nil)
+
 	return Client{
-		client:  http.Client{},
+		client:  http.Client{Jar: jar},
 		timeout: 30 * time.Second, // Default timeout of 30 seconds
 	}
 }
 
+// ResetSession drops all cookies collected so far, starting a fresh session
+func (c *Client) ResetSession() {
+	jar, _ := cookiejar.New(nil)
+	c.client.Jar = jar
+}
+
+// Get performs a GET request with the given query and returns the response body
+func (c *Client) Get(ctx context.Context, targetURL string, query url.Values) ([]byte, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't create request: %w", err)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	return c.do(req)
+}
+
+// PostForm performs a POST request with a form-encoded body and returns the response body
+func (c *Client) PostForm(ctx context.Context, targetURL string, form url.Values) ([]byte, error) {
+	// Create context with timeout
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("can't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return c.do(req)
+}
+
+// do sends a prepared request and fails on non-2xx status codes
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("can't read response body: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return body, nil
+}
+
 // Updates retrieves updates from a web source
 func (c *Client) Updates(targetURL string) ([]Update, error) {
 	// Create context with timeout
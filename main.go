@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"NDClasses/clients/checker"
 	"NDClasses/clients/database"
+	"NDClasses/clients/health"
 	"NDClasses/clients/logger"
+	"NDClasses/clients/ndparser"
 	"NDClasses/clients/telegram"
 
 	"github.com/joho/godotenv"
@@ -16,48 +22,159 @@ import (
 func main() {
 	// Define command-line flags
 	debugMode := flag.Bool("debug", false, "Enable debug mode to see all parser actions")
+	term := flag.String("term", "", "Academic term to search by default, as a code or a name (overrides ACADEMIC_TERM)")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait for running checks and commands on shutdown")
 	flag.Parse()
 
-	// Create logger based on debug mode flag
-	logger := logger.New(*debugMode)
+	// Load environment variables from .env file
+	err := godotenv.Load()
+	if err != nil {
+		fatal(logger.Default(), "Error loading .env file: %v", err)
+	}
+
+	// Create logger from LOG_LEVEL and LOG_FORMAT, with debug messages if the flag is set
+	logger := newLogger(*debugMode)
 
 	// Log startup message
 	logger.Info("Starting ND Classes Parser Bot")
-	if *debugMode {
+	if logger.IsDebugMode() {
 		logger.Info("Debug mode enabled")
 	}
 
-	// Load environment variables from .env file
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// "migrate status|up|down" manages the schema instead of running the bot
+	if flag.Arg(0) == "migrate" {
+		os.Exit(runMigrate(flag.Args()[1:], logger.Component("database")))
 	}
 
 	// Get bot token from environment variables
 	botToken := os.Getenv("BOT_TOKEN")
 	if botToken == "" {
-		log.Fatal("BOT_TOKEN not set in environment variables")
+		fatal(logger, "BOT_TOKEN not set in environment variables")
+	}
+
+	// Check the webhook settings before anything starts, so a bad one doesn't need a shutdown
+	webhookMode := os.Getenv("TELEGRAM_MODE") == "webhook"
+	var webhook telegram.WebhookConfig
+	if webhookMode {
+		if webhook, err = webhookConfig(); err != nil {
+			fatal(logger, "Error configuring webhook: %v", err)
+		}
 	}
 
-	// Create database connection
-	db, err := database.New()
+	// Cancel the root context on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Create database connection, or an in-memory store with DB_DRIVER=memory
+	db, err := database.NewStore(ctx)
 	if err != nil {
-		log.Fatalf("Error connecting to database: %v", err)
+		fatal(logger, "Error connecting to database: %v", err)
+	}
+	if _, ok := db.(*database.Memory); ok {
+		logger.Info("Running without a database, nothing will be saved across restarts")
 	}
 
 	// Create Telegram client
 	TGclient := telegram.New("api.telegram.org", botToken)
+	TGclient.SetLogger(logger.Component("telegram"))
+
+	// Create class source selected by PARSER_BACKEND
+	parser := ndparser.NewSource(logger.Component("parser"), *term)
 
 	// Create message processor
-	processor := telegram.NewMessageProcessor(&TGclient, db, logger)
+	processor := telegram.NewMessageProcessor(&TGclient, db, parser, logger.Component("telegram"))
+
+	// Publish the command menu; the bot works without it, so failures are only logged
+	if err := processor.SyncCommands(ctx); err != nil {
+		logger.Error("Error syncing bot commands: %v", err)
+	}
 
 	// Create and start checker service
-	checker := checker.New(db, TGclient, logger)
-	checker.Start()
+	checker := checker.New(db, TGclient, parser, logger.Component("checker"))
+	processor.SetChecker(checker)
+	checker.Start(ctx)
+
+	// Serve metrics and health checks if asked to; polling is only watched when we poll
+	var updates health.PollTracker = &TGclient
+	if webhookMode {
+		updates = nil
+	}
+	serveStatus(ctx, health.New(db, checker, updates, parser), logger.Component("status"))
+
+	// Receive updates until we're asked to stop
+	exitCode := 0
+	if webhookMode {
+		logger.Info("Starting bot webhook...")
+		if err := TGclient.RunWebhook(ctx, processor, webhook); err != nil {
+			logger.Error("Error serving webhook: %v", err)
+			exitCode = 1
+		}
+	} else {
+		logger.Info("Starting bot polling...")
+		if err := TGclient.Run(ctx, processor); err != nil {
+			logger.Error("Error polling updates: %v", err)
+			exitCode = 1
+		}
+	}
+	stop()
 
-	// Start polling for updates
-	logger.Info("Starting bot polling...")
-	if err := TGclient.PollUpdates(processor); err != nil {
-		log.Fatalf("Error polling updates: %v", err)
+	// Let running checks and commands finish, then close the database
+	logger.Info("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	if err := checker.Stop(shutdownCtx); err != nil {
+		logger.Error("Checker didn't stop in time: %v", err)
+	}
+	if err := processor.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Commands didn't finish in time: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		logger.Error("Error closing database: %v", err)
+	}
+
+	logger.Info("Bot stopped")
+	os.Exit(exitCode)
+}
+
+// newLogger creates the bot's logger from the environment and makes it the default for every package
+func newLogger(debugMode bool) *logger.Logger {
+	config, err := logger.ConfigFromEnv(debugMode)
+	if err != nil {
+		fatal(logger.Default(), "Error configuring logger: %v", err)
 	}
+
+	l := logger.NewWithConfig(config)
+	logger.SetDefault(l)
+	return l
+}
+
+// fatal logs an error that keeps the bot from starting and exits.
+// It goes through the logger rather than the log package, so secrets in it are redacted.
+func fatal(l *logger.Logger, format string, args ...interface{}) {
+	l.Error(format, args...)
+	os.Exit(1)
+}
+
+// webhookConfig reads the webhook settings from environment variables
+func webhookConfig() (telegram.WebhookConfig, error) {
+	config := telegram.WebhookConfig{
+		URL:         os.Getenv("WEBHOOK_URL"),
+		ListenAddr:  os.Getenv("WEBHOOK_LISTEN_ADDR"),
+		Path:        os.Getenv("WEBHOOK_PATH"),
+		SecretToken: os.Getenv("WEBHOOK_SECRET"),
+	}
+	logger.AddSecret(config.SecretToken)
+
+	if config.URL == "" {
+		return config, errors.New("WEBHOOK_URL not set in environment variables")
+	}
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":8080"
+	}
+	if config.Path == "" {
+		config.Path = "/telegram/webhook"
+	}
+
+	return config, nil
 }
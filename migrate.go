@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"NDClasses/clients/database"
+	"NDClasses/clients/logger"
+)
+
+const migrateUsage = "Usage: migrate status | up | down [steps]"
+
+// runMigrate runs the migrate subcommand and returns the exit code.
+// Errors are logged, so connection strings in them are redacted; results go to stdout.
+func runMigrate(args []string, log *logger.Logger) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.Connect(context.Background())
+	if err != nil {
+		log.Error("Error connecting to database: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			log.Error("Error reading migration status: %v", err)
+			return 1
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + time.Unix(s.AppliedAt, 0).UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-45s %s\n", s.Version, s.Name, applied)
+		}
+		return 0
+
+	case "up":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Error("Error applying migrations: %v", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+		return 0
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, migrateUsage)
+				return 2
+			}
+		}
+		rolledBack, err := db.MigrateDown(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Error("Error rolling back migrations: %v", err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("No migrations to roll back")
+		}
+		return 0
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"NDClasses/clients/health"
+	"NDClasses/clients/logger"
+	"NDClasses/clients/metrics"
+)
+
+// serveStatus serves the Prometheus metrics under /metrics on METRICS_LISTEN_ADDR, and health checks
+// under /healthz and /readyz on HEALTH_LISTEN_ADDR, until ctx is cancelled.
+// Both can share one address; nothing is served on an empty one.
+func serveStatus(ctx context.Context, h *health.Health, logger *logger.Logger) {
+	muxes := make(map[string]*http.ServeMux)
+	mux := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
+		}
+		return muxes[addr]
+	}
+
+	if addr := os.Getenv("METRICS_LISTEN_ADDR"); addr != "" {
+		mux(addr).Handle("/metrics", metrics.Default().Handler())
+	}
+	if addr := os.Getenv("HEALTH_LISTEN_ADDR"); addr != "" {
+		mux(addr).Handle("/healthz", h.LivenessHandler())
+		mux(addr).Handle("/readyz", h.ReadinessHandler())
+	}
+
+	for addr, handler := range muxes {
+		go serveHTTP(ctx, addr, handler, logger)
+	}
+}
+
+// serveHTTP serves handler on addr until ctx is cancelled
+func serveHTTP(ctx context.Context, addr string, handler http.Handler, logger *logger.Logger) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Stop serving once the bot shuts down
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving status endpoints on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Status server on %s failed: %v", addr, err)
+	}
+}
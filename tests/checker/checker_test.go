@@ -0,0 +1,878 @@
+package checker_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"NDClasses/clients/checker"
+	"NDClasses/clients/database"
+	"NDClasses/clients/logger"
+	"NDClasses/clients/ndparser"
+	"NDClasses/clients/telegram"
+	"NDClasses/tests/internal/metricstest"
+)
+
+// setupTestDB creates an in-memory store, so the checker can be tested without a database
+func setupTestDB(t *testing.T) database.Store {
+	db := database.NewMemory()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// sentMessages records the messages sent through a fake Telegram server
+type sentMessages struct {
+	mu      sync.Mutex
+	chats   []string
+	texts   []string
+	markups []string
+}
+
+func newTelegramServer(sent *sentMessages) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent.mu.Lock()
+		sent.chats = append(sent.chats, r.URL.Query().Get("chat_id"))
+		sent.texts = append(sent.texts, r.URL.Query().Get("text"))
+		sent.markups = append(sent.markups, r.URL.Query().Get("reply_markup"))
+		sent.mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+}
+
+func createTestClient(serverURL string) telegram.Client {
+	testURL, _ := url.Parse(serverURL)
+	client := telegram.New("http://"+testURL.Host, "test_token")
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	return client
+}
+
+func TestCheckNowFetchesSharedCRNOnce(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Three students watch the same popular section, one watches another
+	for i, crn := range []string{"12345", "12345", "12345", "67890"} {
+		user, err := db.CreateUser(int64(100+i), "")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := db.AddTrackedCRN(user.ID, crn, "202510", "Test Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(
+		ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 2},
+		ndparser.Class{CRN: "67890", Term: "202510", Title: "Full Class", Seats: 0},
+	)
+
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+
+	if source.Calls("12345") != 1 {
+		t.Errorf("Expected shared CRN to be fetched once, got %d", source.Calls("12345"))
+	}
+
+	if source.Calls("67890") != 1 {
+		t.Errorf("Expected CRN 67890 to be fetched once, got %d", source.Calls("67890"))
+	}
+
+	// Every watcher of the open section is notified, nobody else is
+	sort.Strings(sent.chats)
+	want := []string{"100", "101", "102"}
+	if len(sent.chats) != len(want) {
+		t.Fatalf("Expected %d notifications, got %d: %v", len(want), len(sent.chats), sent.chats)
+	}
+	for i := range want {
+		if sent.chats[i] != want[i] {
+			t.Errorf("Expected notification to %s, got %s", want[i], sent.chats[i])
+		}
+	}
+}
+
+func TestCheckNowSeparatesTerms(t *testing.T) {
+	db := setupTestDB(t)
+
+	user, err := db.CreateUser(100, "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// The same CRN in two terms is two different sections
+	if _, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Fall Class"); err != nil {
+		t.Fatalf("Failed to add fall CRN: %v", err)
+	}
+	if _, err := db.AddTrackedCRN(user.ID, "12345", "202520", "Spring Class"); err != nil {
+		t.Fatalf("Failed to add spring CRN: %v", err)
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(
+		ndparser.Class{CRN: "12345", Term: "202510", Title: "Fall Class", Seats: 0},
+		ndparser.Class{CRN: "12345", Term: "202520", Title: "Spring Class", Seats: 1},
+	)
+
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+
+	if source.Calls("12345") != 2 {
+		t.Errorf("Expected one fetch per term, got %d", source.Calls("12345"))
+	}
+
+	if len(sent.texts) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(sent.texts))
+	}
+}
+
+func TestCheckNowGroupsLegacyRowsWithCurrentTerm(t *testing.T) {
+	db := setupTestDB(t)
+
+	// One row predates stored terms, the other names the current term
+	for i, term := range []string{"", ndparser.FakeTerm.Code} {
+		user, err := db.CreateUser(int64(100+i), "")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := db.AddTrackedCRN(user.ID, "12345", term, "Test Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+
+	if source.Calls("12345") != 1 {
+		t.Errorf("Expected the section to be fetched once, got %d", source.Calls("12345"))
+	}
+	if len(sent.texts) != 2 {
+		t.Errorf("Expected both watchers to be notified, got %d", len(sent.texts))
+	}
+	if _, err := db.GetSectionState(ndparser.FakeTerm.Code, "12345"); err != nil {
+		t.Errorf("Expected the state to be stored under the current term: %v", err)
+	}
+}
+
+func TestCheckNowSkipsLegacyRowsWithoutCurrentTerm(t *testing.T) {
+	db := setupTestDB(t)
+
+	// One row predates stored terms, the other names the term it was tracked in
+	for i, term := range []string{"", ndparser.FakeTerm.Code} {
+		user, err := db.CreateUser(int64(100+i), "")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := db.AddTrackedCRN(user.ID, "12345", term, "Test Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), termlessSource{fake}, logger.New(false))
+
+	// Without the current term the legacy row can't be grouped, so it waits for the next cycle
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected only the watcher with a term to be notified, got %d", n)
+	}
+	if fake.Calls("12345") != 1 {
+		t.Errorf("Expected the section to be fetched once, got %d", fake.Calls("12345"))
+	}
+	if _, err := db.GetSectionState("", "12345"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Expected no state under an empty term, got: %v", err)
+	}
+}
+
+func TestCheckNowAlertsUserOnceForDuplicateRows(t *testing.T) {
+	db := setupTestDB(t)
+
+	// The same user tracks the section from before terms were stored and again under the current term
+	user, err := db.CreateUser(100, "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	for _, term := range []string{"", ndparser.FakeTerm.Code} {
+		if _, err := db.AddTrackedCRN(user.ID, "12345", term, "Test Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected 1 alert, got %d", n)
+	}
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no alert while the section stays open, got %d", n)
+	}
+
+	// Another user with both rows snoozed only the term row, which still holds back the alert
+	other, err := db.CreateUser(200, "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	var snoozed *database.TrackedCRN
+	for _, term := range []string{"", ndparser.FakeTerm.Code} {
+		if snoozed, err = db.AddTrackedCRN(other.ID, "12345", term, "Test Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+	if err := db.SnoozeTrackedCRN(other.ID, snoozed.ID, time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("Failed to snooze CRN: %v", err)
+	}
+
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no alert to a user who snoozed one of their rows, got %d", n)
+	}
+}
+
+// setupWatcher creates a user watching CRN 12345 in term 202510
+func setupWatcher(t *testing.T, db database.Store, telegramID int64) {
+	user, err := db.CreateUser(telegramID, "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Test Class"); err != nil {
+		t.Fatalf("Failed to add tracked CRN: %v", err)
+	}
+}
+
+// runCycle runs one check and returns how many messages it sent
+func runCycle(t *testing.T, c *checker.Checker, sent *sentMessages) int {
+	sent.mu.Lock()
+	before := len(sent.texts)
+	sent.mu.Unlock()
+
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+
+	sent.mu.Lock()
+	defer sent.mu.Unlock()
+	return len(sent.texts) - before
+}
+
+func TestCheckNowNotifiesOnlyOnTransitions(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected 1 alert when the section is first seen open, got %d", n)
+	}
+
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no alert while the section stays open, got %d", n)
+	}
+
+	// A restarted checker must not repeat the alert
+	restarted := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if n := runCycle(t, restarted, &sent); n != 0 {
+		t.Errorf("Expected no alert after restart, got %d", n)
+	}
+
+	source.Set(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 0})
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no alert when the section closes, got %d", n)
+	}
+
+	source.Set(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 2})
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected 1 alert when the section reopens, got %d", n)
+	}
+
+	state, err := db.GetSectionState("202510", "12345")
+	if err != nil {
+		t.Fatalf("Failed to get section state: %v", err)
+	}
+
+	if !state.Open || state.Seats != 2 {
+		t.Errorf("Expected open state with 2 seats, got %+v", state)
+	}
+}
+
+func TestCheckNowNotifiesOnClose(t *testing.T) {
+	t.Setenv("NOTIFY_ON_CLOSE", "true")
+
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	runCycle(t, c, &sent)
+
+	// A user who joins while the section is open hears about it, but not about it closing before that
+	setupWatcher(t, db, 101)
+	source.Set(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 0})
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected 1 closed alert, got %d", n)
+	}
+
+	if !strings.Contains(sent.texts[len(sent.texts)-1], "full again") {
+		t.Errorf("Expected closed alert, got: %s", sent.texts[len(sent.texts)-1])
+	}
+}
+
+func TestCheckNowRetriesFailedAlerts(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	var failing atomic.Bool
+	failing.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			// Drop the connection to simulate Telegram being unreachable
+			panic(http.ErrAbortHandler)
+		}
+		sent.mu.Lock()
+		sent.texts = append(sent.texts, r.URL.Query().Get("text"))
+		sent.mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	runCycle(t, c, &sent)
+
+	failing.Store(false)
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Errorf("Expected the failed alert to be retried, got %d messages", n)
+	}
+
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no more alerts after delivery, got %d", n)
+	}
+}
+
+func TestSnoozeHoldsBackAlerts(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	user, _ := db.GetUserByTelegramID(100)
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if err := db.SnoozeTrackedCRN(user.ID, crns[0].ID, time.Now().Add(time.Hour).Unix()); err != nil {
+		t.Fatalf("Failed to snooze: %v", err)
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	if n := runCycle(t, c, &sent); n != 0 {
+		t.Errorf("Expected no alert while snoozed, got %d", n)
+	}
+
+	// Once the snooze is over, the opening is still reported
+	db.SnoozeTrackedCRN(user.ID, crns[0].ID, time.Now().Add(-time.Minute).Unix())
+	if n := runCycle(t, c, &sent); n != 1 {
+		t.Fatalf("Expected the alert after the snooze, got %d", n)
+	}
+
+	var keyboard telegram.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(sent.markups[0]), &keyboard); err != nil {
+		t.Fatalf("Expected the alert to have buttons, got %q: %v", sent.markups[0], err)
+	}
+	want := fmt.Sprintf("untrack:%d", crns[0].ID)
+	if len(keyboard.InlineKeyboard) != 1 || keyboard.InlineKeyboard[0][0].CallbackData != want {
+		t.Errorf("Expected a %q button, got %+v", want, keyboard)
+	}
+}
+
+// countingSource records the highest number of concurrent searches
+type countingSource struct {
+	*ndparser.Fake
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func (s *countingSource) SearchClass(ctx context.Context, term string, crn string) (*ndparser.Class, error) {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		seen := s.maxSeen.Load()
+		if n <= seen || s.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	return s.Fake.SearchClass(ctx, term, crn)
+}
+
+func TestCheckNowWorkerCap(t *testing.T) {
+	t.Setenv("CHECKER_WORKERS", "2")
+
+	db := setupTestDB(t)
+	user, err := db.CreateUser(100, "")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	source := &countingSource{Fake: ndparser.NewFake()}
+	for i := 0; i < 6; i++ {
+		crn := fmt.Sprintf("1000%d", i)
+		source.Set(ndparser.Class{CRN: crn, Term: "202510", Title: "Full Class"})
+		if _, err := db.AddTrackedCRN(user.ID, crn, "202510", "Full Class"); err != nil {
+			t.Fatalf("Failed to add tracked CRN: %v", err)
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+
+	if source.maxSeen.Load() != 2 {
+		t.Errorf("Expected at most 2 concurrent checks, got %d", source.maxSeen.Load())
+	}
+
+	if c.QueueDepth() != 0 {
+		t.Errorf("Expected empty queue after the cycle, got %d", c.QueueDepth())
+	}
+}
+
+// slowSource is a class source whose searches take a while and honor cancellation
+type slowSource struct {
+	*ndparser.Fake
+	delay     time.Duration
+	started   chan struct{}
+	cancelled atomic.Bool
+}
+
+func (s *slowSource) SearchClass(ctx context.Context, term string, crn string) (*ndparser.Class, error) {
+	s.started <- struct{}{}
+	select {
+	case <-time.After(s.delay):
+		return s.Fake.SearchClass(ctx, term, crn)
+	case <-ctx.Done():
+		s.cancelled.Store(true)
+		return nil, ctx.Err()
+	}
+}
+
+func TestStopWaitsForRunningSearch(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := &slowSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1}),
+		delay:   100 * time.Millisecond,
+		started: make(chan struct{}, 1),
+	}
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- c.Run(context.Background()) }()
+	<-source.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	if source.cancelled.Load() {
+		t.Error("Expected the running search to finish, but it was cancelled")
+	}
+
+	// The search finished, so its alert went out before Stop returned
+	sent.mu.Lock()
+	if len(sent.texts) != 1 {
+		t.Errorf("Expected 1 alert, got %d", len(sent.texts))
+	}
+	sent.mu.Unlock()
+
+	if err := <-runDone; err != nil {
+		t.Errorf("Expected Run to return nil, got: %v", err)
+	}
+}
+
+func TestStopRightAfterStart(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := &slowSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1}),
+		started: make(chan struct{}, 10),
+	}
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	// Stop may run before the loop's goroutine does, and must still wait for it
+	c.Start(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	searches := len(source.started)
+	time.Sleep(50 * time.Millisecond)
+	if len(source.started) != searches {
+		t.Error("Expected no searches after Stop returned")
+	}
+}
+
+func TestStopCancelsSearchAfterDeadline(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := &slowSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1}),
+		delay:   time.Minute,
+		started: make(chan struct{}, 1),
+	}
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	go c.Run(context.Background())
+	<-source.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+
+	if !source.cancelled.Load() {
+		t.Error("Expected the running search to be cancelled after the deadline")
+	}
+}
+
+func TestStopStopsForcedCheck(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := &slowSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1}),
+		delay:   time.Minute,
+		started: make(chan struct{}, 1),
+	}
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	// Paused, so the only search is the forced one
+	c.Pause()
+	c.Start(context.Background())
+
+	checkDone := make(chan error, 1)
+	go func() { checkDone <- c.CheckNow(context.Background()) }()
+	<-source.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+
+	// Stop waited for the forced check, which was cut short
+	select {
+	case err := <-checkDone:
+		if !errors.Is(err, checker.ErrStopped) {
+			t.Errorf("Expected ErrStopped, got: %v", err)
+		}
+	default:
+		t.Fatal("Expected the forced check to be done when Stop returned")
+	}
+	if !source.cancelled.Load() {
+		t.Error("Expected the forced search to be cancelled after the deadline")
+	}
+
+	if err := c.CheckNow(context.Background()); !errors.Is(err, checker.ErrStopped) {
+		t.Errorf("Expected checks after Stop to be refused, got: %v", err)
+	}
+}
+
+func TestCheckNowRecordsHistory(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 2, Enrollment: 28, Capacity: 30})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	runCycle(t, c, &sent)
+
+	// Failed fetches are part of the history too
+	source.SetError("12345", errors.New("registration site is down"))
+	runCycle(t, c, &sent)
+
+	observations, err := db.GetObservations("202510", "12345", 0)
+	if err != nil {
+		t.Fatalf("Failed to get observations: %v", err)
+	}
+	if len(observations) != 2 {
+		t.Fatalf("Expected 2 observations, got %d", len(observations))
+	}
+
+	if first := observations[0]; !first.Success || first.Seats != 2 || first.Enrollment != 28 || first.Capacity != 30 {
+		t.Errorf("Unexpected successful observation: %+v", first)
+	}
+	if second := observations[1]; second.Success || !strings.Contains(second.Error, "registration site is down") {
+		t.Errorf("Unexpected failed observation: %+v", second)
+	}
+}
+
+// termlessSource can't tell the current term, as when the registration site is down
+type termlessSource struct {
+	*ndparser.Fake
+}
+
+func (s termlessSource) CurrentTerm(ctx context.Context) (ndparser.Term, error) {
+	return ndparser.Term{}, errors.New("registration site is down")
+}
+
+func TestCheckNowSkipsHistoryWithoutTerm(t *testing.T) {
+	db := setupTestDB(t)
+	user, _ := db.CreateUser(100, "")
+	db.AddTrackedCRN(user.ID, "12345", "", "Test Class")
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	fake := ndparser.NewFake()
+	fake.SetError("12345", errors.New("registration site is down"))
+	c := checker.New(db, createTestClient(server.URL), termlessSource{fake}, logger.New(false))
+
+	runCycle(t, c, &sent)
+
+	// A failure in an unknown term can't be shown by /history, so it isn't stored under an empty term
+	observations, err := db.GetObservations("", "12345", 0)
+	if err != nil {
+		t.Fatalf("Failed to get observations: %v", err)
+	}
+	if len(observations) != 0 {
+		t.Errorf("Expected no observations without a term, got %+v", observations)
+	}
+}
+
+func TestCheckNowPrunesHistory(t *testing.T) {
+	t.Setenv("HISTORY_RETENTION_DAYS", "1")
+
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	if err := db.RecordObservation(&database.SectionObservation{Term: "202510", CRN: "12345", Success: true, ObservedAt: old}); err != nil {
+		t.Fatalf("Failed to record observation: %v", err)
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 0})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	runCycle(t, c, &sent)
+
+	observations, err := db.GetObservations("202510", "12345", 0)
+	if err != nil {
+		t.Fatalf("Failed to get observations: %v", err)
+	}
+	if len(observations) != 1 || observations[0].ObservedAt == old {
+		t.Errorf("Expected only the new observation to be kept, got %+v", observations)
+	}
+}
+
+func TestCheckNowUpdatesMetrics(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Two students watch the same section, one of them another section too
+	for i, crns := range [][]string{{"12345", "67890"}, {"12345"}} {
+		user, err := db.CreateUser(int64(300+i), "")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		for _, crn := range crns {
+			if _, err := db.AddTrackedCRN(user.ID, crn, "202510", "Test Class"); err != nil {
+				t.Fatalf("Failed to add tracked CRN: %v", err)
+			}
+		}
+	}
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(
+		ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 0},
+		ndparser.Class{CRN: "67890", Term: "202510", Title: "Full Class", Seats: 0},
+	)
+
+	cycles := metricstest.Value(t, "ndclasses_checker_cycle_duration_seconds_count")
+	before := time.Now().Unix()
+
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	if !c.LastCycle().IsZero() {
+		t.Errorf("Expected no last cycle before the first check, got %v", c.LastCycle())
+	}
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+	if c.LastCycle().Unix() < before {
+		t.Errorf("Expected the last cycle time to be recorded, got %v", c.LastCycle())
+	}
+
+	for series, want := range map[string]float64{
+		"ndclasses_tracked_crns":                         3,
+		"ndclasses_tracked_sections":                     2,
+		"ndclasses_active_users":                         2,
+		"ndclasses_checker_cycle_duration_seconds_count": cycles + 1,
+	} {
+		if got := metricstest.Value(t, series); got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+	if last := metricstest.Value(t, "ndclasses_checker_last_cycle_timestamp_seconds"); last < float64(before) {
+		t.Errorf("Expected the last cycle time to be updated, got %v", last)
+	}
+}
+
+func TestPauseSkipsScheduledChecks(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+
+	if !c.Pause() || c.Pause() {
+		t.Fatal("Expected only the first Pause to pause the checker")
+	}
+	if paused, since := c.Paused(); !paused || since.IsZero() {
+		t.Errorf("Expected the checker to report being paused, got %v %v", paused, since)
+	}
+
+	runDone := make(chan error, 1)
+	go func() { runDone <- c.Run(context.Background()) }()
+	defer func() {
+		c.Stop(context.Background())
+		<-runDone
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if calls := source.Calls("12345"); calls != 0 {
+		t.Fatalf("Expected no checks while paused, got %d", calls)
+	}
+	if !c.LastCycle().IsZero() {
+		t.Errorf("Expected no finished cycle while paused, got %v", c.LastCycle())
+	}
+
+	// Resuming checks right away instead of waiting for the next interval
+	if !c.Resume() || c.Resume() {
+		t.Fatal("Expected only the first Resume to resume the checker")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for c.LastCycle().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c.LastCycle().IsZero() || source.Calls("12345") != 1 {
+		t.Errorf("Expected one check after resuming, got %d", source.Calls("12345"))
+	}
+	if paused, _ := c.Paused(); paused {
+		t.Error("Expected the checker to report running after Resume")
+	}
+}
+
+func TestCheckNowWhilePaused(t *testing.T) {
+	db := setupTestDB(t)
+	setupWatcher(t, db, 100)
+
+	var sent sentMessages
+	server := newTelegramServer(&sent)
+	defer server.Close()
+
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Term: "202510", Title: "Test Class", Seats: 1})
+	c := checker.New(db, createTestClient(server.URL), source, logger.New(false))
+	c.Pause()
+
+	// Checks asked for explicitly still run
+	if err := c.CheckNow(context.Background()); err != nil {
+		t.Fatalf("CheckNow failed: %v", err)
+	}
+	if source.Calls("12345") != 1 || c.LastCycle().IsZero() {
+		t.Errorf("Expected a complete check while paused, got %d searches", source.Calls("12345"))
+	}
+	if c.LastCycleDuration() <= 0 {
+		t.Errorf("Expected the cycle duration to be recorded, got %v", c.LastCycleDuration())
+	}
+}
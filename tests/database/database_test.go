@@ -1,28 +1,34 @@
 package database_test
 
 import (
+	"context"
 	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"NDClasses/clients/database"
+	"NDClasses/clients/logger"
 
-	"gorm.io/driver/sqlite"
+	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
 
 func setupTestDB(t *testing.T) *database.Database {
 	// Use in-memory SQLite for testing
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	db, err := database.Open(context.Background(), database.Config{Driver: database.DriverSQLite, DSN: ":memory:"})
 	if err != nil {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
+	t.Cleanup(func() { db.Close() })
 
 	// Run migrations
-	if err := db.AutoMigrate(&database.User{}, &database.TrackedCRN{}); err != nil {
+	if _, err := db.MigrateUp(); err != nil {
 		t.Fatalf("Failed to run migrations: %v", err)
 	}
 
-	return &database.Database{DB: db}
+	return db
 }
 
 func TestCreateUser(t *testing.T) {
@@ -124,7 +130,7 @@ func TestAddTrackedCRN(t *testing.T) {
 	}
 
 	// Test adding a CRN
-	trackedCRN, err := db.AddTrackedCRN(user.ID, "12345", "Test Class")
+	trackedCRN, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Test Class")
 	if err != nil {
 		t.Fatalf("Failed to add tracked CRN: %v", err)
 	}
@@ -146,7 +152,7 @@ func TestAddTrackedCRN(t *testing.T) {
 	}
 
 	// Test adding the same CRN again (should return existing without updating title)
-	trackedCRN2, err := db.AddTrackedCRN(user.ID, "12345", "Updated Title")
+	trackedCRN2, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Updated Title")
 	if err != nil {
 		t.Fatalf("Failed to add existing CRN: %v", err)
 	}
@@ -170,25 +176,72 @@ func TestRemoveTrackedCRN(t *testing.T) {
 		t.Fatalf("Failed to create user: %v", err)
 	}
 
-	_, err = db.AddTrackedCRN(user.ID, "12345", "Test Class")
+	_, err = db.AddTrackedCRN(user.ID, "12345", "202510", "Test Class")
+	if err != nil {
+		t.Fatalf("Failed to add tracked CRN: %v", err)
+	}
+	_, err = db.AddTrackedCRN(user.ID, "12345", "202520", "Test Class")
 	if err != nil {
 		t.Fatalf("Failed to add tracked CRN: %v", err)
 	}
 
-	// Test removing the CRN
-	err = db.RemoveTrackedCRN(user.ID, "12345")
+	// Test removing the CRN in one term
+	err = db.RemoveTrackedCRN(user.ID, "12345", "202510")
 	if err != nil {
 		t.Fatalf("Failed to remove tracked CRN: %v", err)
 	}
 
-	// Check that it's no longer active
+	// Check that only the other term is still active
 	crns, err := db.GetUserTrackedCRNs(user.ID)
 	if err != nil {
 		t.Fatalf("Failed to get user tracked CRNs: %v", err)
 	}
 
-	if len(crns) != 0 {
-		t.Errorf("Expected 0 tracked CRNs after removal, got %d", len(crns))
+	if len(crns) != 1 || crns[0].Term != "202520" {
+		t.Errorf("Expected only the 202520 CRN after removal, got %+v", crns)
+	}
+}
+
+func TestRemoveTrackedCRNByID(t *testing.T) {
+	db := setupTestDB(t)
+
+	user, err := db.CreateUser(12345, "testuser")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	other, err := db.CreateUser(67890, "otheruser")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	fall, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Test Class")
+	if err != nil {
+		t.Fatalf("Failed to add tracked CRN: %v", err)
+	}
+	_, err = db.AddTrackedCRN(user.ID, "12345", "202520", "Test Class")
+	if err != nil {
+		t.Fatalf("Failed to add tracked CRN: %v", err)
+	}
+
+	// Another user's ID doesn't remove the row
+	if err := db.RemoveTrackedCRNByID(other.ID, fall.ID); err != nil {
+		t.Fatalf("Failed to remove tracked CRN: %v", err)
+	}
+	if crns, _ := db.GetUserTrackedCRNs(user.ID); len(crns) != 2 {
+		t.Errorf("Expected 2 tracked CRNs after removal by another user, got %+v", crns)
+	}
+
+	// Removing by ID leaves the same CRN in other terms alone
+	if err := db.RemoveTrackedCRNByID(user.ID, fall.ID); err != nil {
+		t.Fatalf("Failed to remove tracked CRN: %v", err)
+	}
+
+	crns, err := db.GetUserTrackedCRNs(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user tracked CRNs: %v", err)
+	}
+	if len(crns) != 1 || crns[0].Term != "202520" {
+		t.Errorf("Expected only the 202520 CRN after removal, got %+v", crns)
 	}
 }
 
@@ -202,12 +255,12 @@ func TestGetUserTrackedCRNs(t *testing.T) {
 	}
 
 	// Add multiple CRNs
-	_, err = db.AddTrackedCRN(user.ID, "12345", "Class 1")
+	_, err = db.AddTrackedCRN(user.ID, "12345", "202510", "Class 1")
 	if err != nil {
 		t.Fatalf("Failed to add CRN 1: %v", err)
 	}
 
-	_, err = db.AddTrackedCRN(user.ID, "67890", "Class 2")
+	_, err = db.AddTrackedCRN(user.ID, "67890", "202510", "Class 2")
 	if err != nil {
 		t.Fatalf("Failed to add CRN 2: %v", err)
 	}
@@ -258,12 +311,12 @@ func TestGetAllTrackedCRNs(t *testing.T) {
 	}
 
 	// Add CRNs for both users
-	_, err = db.AddTrackedCRN(user1.ID, "11111", "Class A")
+	_, err = db.AddTrackedCRN(user1.ID, "11111", "202510", "Class A")
 	if err != nil {
 		t.Fatalf("Failed to add CRN for user1: %v", err)
 	}
 
-	_, err = db.AddTrackedCRN(user2.ID, "22222", "Class B")
+	_, err = db.AddTrackedCRN(user2.ID, "22222", "202510", "Class B")
 	if err != nil {
 		t.Fatalf("Failed to add CRN for user2: %v", err)
 	}
@@ -288,13 +341,13 @@ func TestUpdateCRNTitle(t *testing.T) {
 		t.Fatalf("Failed to create user: %v", err)
 	}
 
-	_, err = db.AddTrackedCRN(user.ID, "12345", "Original Title")
+	_, err = db.AddTrackedCRN(user.ID, "12345", "202510", "Original Title")
 	if err != nil {
 		t.Fatalf("Failed to add tracked CRN: %v", err)
 	}
 
 	// Update the title
-	err = db.UpdateCRNTitle(user.ID, "12345", "Updated Title")
+	err = db.UpdateCRNTitle(user.ID, "12345", "202510", "Updated Title")
 	if err != nil {
 		t.Fatalf("Failed to update CRN title: %v", err)
 	}
@@ -313,3 +366,654 @@ func TestUpdateCRNTitle(t *testing.T) {
 		t.Errorf("Expected title 'Updated Title', got '%s'", crns[0].Title)
 	}
 }
+
+func TestAddTrackedCRNInDifferentTerms(t *testing.T) {
+	db := setupTestDB(t)
+
+	user, err := db.CreateUser(12345, "testuser")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// The same CRN can be tracked in two terms at once
+	fall, err := db.AddTrackedCRN(user.ID, "12345", "202510", "Fall Class")
+	if err != nil {
+		t.Fatalf("Failed to add fall CRN: %v", err)
+	}
+
+	spring, err := db.AddTrackedCRN(user.ID, "12345", "202520", "Spring Class")
+	if err != nil {
+		t.Fatalf("Failed to add spring CRN: %v", err)
+	}
+
+	if fall.ID == spring.ID {
+		t.Error("Expected separate rows for different terms")
+	}
+
+	if spring.Term != "202520" {
+		t.Errorf("Expected term '202520', got '%s'", spring.Term)
+	}
+
+	crns, err := db.GetUserTrackedCRNs(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user tracked CRNs: %v", err)
+	}
+
+	if len(crns) != 2 {
+		t.Errorf("Expected 2 tracked CRNs, got %d", len(crns))
+	}
+}
+
+func TestSaveSectionState(t *testing.T) {
+	db := setupTestDB(t)
+
+	// Test getting a section that was never observed
+	_, err := db.GetSectionState("202510", "12345")
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound for unknown section, got: %v", err)
+	}
+
+	state := &database.SectionState{Term: "202510", CRN: "12345", Seats: 3, Open: true, ChangedAt: 100}
+	if err := db.SaveSectionState(state); err != nil {
+		t.Fatalf("Failed to save section state: %v", err)
+	}
+
+	// Test updating the existing state
+	state.Seats = 0
+	state.Open = false
+	if err := db.SaveSectionState(state); err != nil {
+		t.Fatalf("Failed to update section state: %v", err)
+	}
+
+	saved, err := db.GetSectionState("202510", "12345")
+	if err != nil {
+		t.Fatalf("Failed to get section state: %v", err)
+	}
+
+	if saved.ID != state.ID || saved.Open || saved.Seats != 0 || saved.ChangedAt != 100 {
+		t.Errorf("Unexpected section state: %+v", saved)
+	}
+}
+
+func TestRecordNotification(t *testing.T) {
+	db := setupTestDB(t)
+
+	delivered, err := db.GetDeliveredNotifications("202510", "12345", 100)
+	if err != nil {
+		t.Fatalf("Failed to check notifications: %v", err)
+	}
+	if len(delivered) != 0 {
+		t.Error("Expected no delivered notification yet")
+	}
+
+	// A failed attempt doesn't count as delivered
+	if err := db.RecordNotification(1, "202510", "12345", database.NotificationOpened, 100, false); err != nil {
+		t.Fatalf("Failed to record notification: %v", err)
+	}
+	delivered, _ = db.GetDeliveredNotifications("202510", "12345", 100)
+	if len(delivered) != 0 {
+		t.Error("Expected failed notification not to be delivered")
+	}
+
+	if err := db.RecordNotification(1, "202510", "12345", database.NotificationOpened, 100, true); err != nil {
+		t.Fatalf("Failed to record notification retry: %v", err)
+	}
+	delivered, _ = db.GetDeliveredNotifications("202510", "12345", 100)
+	if len(delivered) != 1 || delivered[0].UserID != 1 || delivered[0].ChangedAt != 100 {
+		t.Errorf("Expected notification to be delivered after retry, got %+v", delivered)
+	}
+
+	// A later transition is a different alert
+	delivered, _ = db.GetDeliveredNotifications("202510", "12345", 200)
+	if len(delivered) != 0 {
+		t.Error("Expected no delivered notification for a later transition")
+	}
+
+	var count int64
+	db.DB.Model(&database.Notification{}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected 1 notification row, got %d", count)
+	}
+}
+
+func TestSectionObservations(t *testing.T) {
+	db := setupTestDB(t)
+
+	for i, seats := range []int{0, 2, 1} {
+		obs := &database.SectionObservation{Term: "202510", CRN: "12345", Success: true, Seats: seats, ObservedAt: int64(100 * (i + 1))}
+		if err := db.RecordObservation(obs); err != nil {
+			t.Fatalf("Failed to record observation: %v", err)
+		}
+	}
+	if err := db.RecordObservation(&database.SectionObservation{Term: "202510", CRN: "67890", Success: false, Error: "timeout", ObservedAt: 150}); err != nil {
+		t.Fatalf("Failed to record failed observation: %v", err)
+	}
+
+	observations, err := db.GetObservations("202510", "12345", 150)
+	if err != nil {
+		t.Fatalf("Failed to get observations: %v", err)
+	}
+	if len(observations) != 2 || observations[0].Seats != 2 || observations[1].Seats != 1 {
+		t.Errorf("Expected the 2 observations since 150 oldest first, got %+v", observations)
+	}
+
+	// Pruning removes observations of every section
+	deleted, err := db.PruneObservations(200)
+	if err != nil {
+		t.Fatalf("Failed to prune observations: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 pruned observations, got %d", deleted)
+	}
+
+	observations, _ = db.GetObservations("202510", "12345", 0)
+	if len(observations) != 2 || observations[0].ObservedAt != 200 {
+		t.Errorf("Expected observations from 200 on to be kept, got %+v", observations)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	db := setupTestDB(t)
+	migrations := database.Migrations()
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Fatalf("Migration %d is out of order", migrations[i].Version)
+		}
+	}
+
+	statuses, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	if len(statuses) != len(migrations) {
+		t.Fatalf("Expected %d migrations, got %d", len(migrations), len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt == 0 {
+			t.Errorf("Expected migration %d to be applied, got %+v", status.Version, status)
+		}
+	}
+
+	// Applying again is a no-op
+	if applied, err := db.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no pending migrations, got %d (%v)", len(applied), err)
+	}
+
+	// Rolling everything back leaves only the bookkeeping table
+	rolledBack, err := db.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatalf("Failed to roll back migrations: %v", err)
+	}
+	if len(rolledBack) != len(migrations) || rolledBack[0].Version != migrations[len(migrations)-1].Version {
+		t.Errorf("Expected all migrations rolled back newest first, got %+v", rolledBack)
+	}
+	for _, table := range []string{"users", "tracked_crns", "section_states", "notifications", "section_observations"} {
+		if db.DB.Migrator().HasTable(table) {
+			t.Errorf("Expected table %s to be dropped", table)
+		}
+	}
+
+	// The schema can be rebuilt step by step
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatalf("Failed to reapply migrations: %v", err)
+	}
+	if _, err := db.MigrateDown(1); err != nil {
+		t.Fatalf("Failed to roll back one migration: %v", err)
+	}
+	statuses, _ = db.MigrationStatus()
+	if last := statuses[len(statuses)-1]; last.Applied {
+		t.Errorf("Expected the newest migration to be pending, got %+v", last)
+	}
+	if _, err := db.CreateUser(123, "test"); err != nil {
+		t.Errorf("Expected older tables to still work: %v", err)
+	}
+}
+
+// baselineUser and baselineTrackedCRN are the models AutoMigrate created tables from before migrations were versioned
+type baselineUser struct {
+	ID         int64 `gorm:"primaryKey"`
+	TelegramID int64 `gorm:"uniqueIndex"`
+	Username   string
+	CreatedAt  int64
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineTrackedCRN struct {
+	ID        int64  `gorm:"primaryKey"`
+	UserID    int64  `gorm:"index"`
+	CRN       string `gorm:"index"`
+	Title     string
+	Active    bool `gorm:"default:true"`
+	CreatedAt int64
+}
+
+func (baselineTrackedCRN) TableName() string { return "tracked_crns" }
+
+func TestMigrationsAdoptAutoMigratedSchema(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("Failed to get database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	// Databases created before migrations were versioned already have the tables, and their data must survive
+	if err := db.AutoMigrate(&baselineUser{}, &baselineTrackedCRN{}); err != nil {
+		t.Fatalf("Failed to create old schema: %v", err)
+	}
+	user := &baselineUser{TelegramID: 123, Username: "test"}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := db.Create(&baselineTrackedCRN{UserID: user.ID, CRN: "12345", Title: "Test Class", Active: true}).Error; err != nil {
+		t.Fatalf("Failed to insert legacy row: %v", err)
+	}
+
+	d := &database.Database{DB: db}
+	applied, err := d.MigrateUp()
+	if err != nil {
+		t.Fatalf("Failed to migrate existing schema: %v", err)
+	}
+	if len(applied) != len(database.Migrations()) {
+		t.Errorf("Expected all migrations to be recorded, got %d", len(applied))
+	}
+
+	for _, column := range []string{"term", "snoozed_until"} {
+		if !db.Migrator().HasColumn("tracked_crns", column) {
+			t.Errorf("Expected tracked_crns.%s to be added", column)
+		}
+	}
+
+	if _, err := d.GetUserByTelegramID(123); err != nil {
+		t.Errorf("Expected existing user to survive, got: %v", err)
+	}
+
+	// The legacy row now has an empty term, meaning the current one, and no snooze
+	crns, err := d.GetUserTrackedCRNs(user.ID)
+	if err != nil || len(crns) != 1 || crns[0].CRN != "12345" || crns[0].Term != "" || crns[0].SnoozedUntil != 0 {
+		t.Fatalf("Expected the legacy row to survive with an empty term and no snooze, got %+v (%v)", crns, err)
+	}
+	if err := d.RemoveTrackedCRN(user.ID, "12345", ""); err != nil {
+		t.Fatalf("Failed to remove legacy row: %v", err)
+	}
+	if crns, _ := d.GetUserTrackedCRNs(user.ID); len(crns) != 0 {
+		t.Errorf("Expected the legacy row to be removed, got %+v", crns)
+	}
+}
+
+func TestMigrationsAddColumnsToExistingRows(t *testing.T) {
+	d, err := database.Open(context.Background(), database.Config{Driver: database.DriverSQLite, DSN: ":memory:"})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// Rows tracked before terms and snoozes existed
+	if _, err := d.MigrateUp(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if _, err := d.MigrateDown(4); err != nil {
+		t.Fatalf("Failed to roll back to the first migration: %v", err)
+	}
+	user, err := d.CreateUser(123, "test")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := d.DB.Exec("INSERT INTO tracked_crns (user_id, crn, title, active) VALUES (?, '12345', 'Test Class', true)", user.ID).Error; err != nil {
+		t.Fatalf("Failed to insert row: %v", err)
+	}
+
+	if _, err := d.MigrateUp(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	crns, err := d.GetUserTrackedCRNs(user.ID)
+	if err != nil || len(crns) != 1 || crns[0].Term != "" || crns[0].SnoozedUntil != 0 {
+		t.Fatalf("Expected the row to survive with an empty term and no snooze, got %+v (%v)", crns, err)
+	}
+	if readded, err := d.AddTrackedCRN(user.ID, "12345", "", "Test Class"); err != nil || readded.ID != crns[0].ID {
+		t.Errorf("Expected the row to be found by its empty term, got %+v (%v)", readded, err)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		env    map[string]string
+		driver string
+		dsn    string
+	}{
+		{map[string]string{"DATABASE_URL": "postgres://bot:secret@db:5432/ndclasses"}, database.DriverPostgres, "postgres://bot:secret@db:5432/ndclasses"},
+		{map[string]string{"DATABASE_URL": "sqlite:data/ndclasses.db", "DB_DRIVER": "postgres"}, database.DriverSQLite, "data/ndclasses.db"},
+		{map[string]string{"DATABASE_URL": "sqlite:///var/lib/ndclasses.db"}, database.DriverSQLite, "/var/lib/ndclasses.db"},
+		{map[string]string{"DB_DRIVER": "sqlite"}, database.DriverSQLite, "ndclasses.db"},
+		{map[string]string{"DB_DRIVER": "sqlite", "DB_PATH": "bot.db"}, database.DriverSQLite, "bot.db"},
+		{map[string]string{"DATABASE_URL": "memory:"}, database.DriverMemory, ""},
+		{map[string]string{"DB_USER": "bot", "DB_PASSWORD": "secret", "DB_NAME": "ndclasses"}, database.DriverPostgres, "host=localhost user=bot password=secret dbname=ndclasses port=5432 sslmode=disable TimeZone=UTC"},
+	}
+
+	for _, tt := range tests {
+		for _, key := range []string{"DATABASE_URL", "DB_DRIVER", "DB_PATH", "DB_HOST", "DB_PORT", "DB_USER", "DB_PASSWORD", "DB_NAME"} {
+			t.Setenv(key, tt.env[key])
+		}
+
+		config, err := database.ConfigFromEnv()
+		if err != nil {
+			t.Errorf("ConfigFromEnv(%v) failed: %v", tt.env, err)
+			continue
+		}
+		if config.Driver != tt.driver || config.DSN != tt.dsn {
+			t.Errorf("ConfigFromEnv(%v) = %+v, want %s %s", tt.env, config, tt.driver, tt.dsn)
+		}
+	}
+
+	for _, env := range []map[string]string{
+		{"DATABASE_URL": "mysql://bot@db/ndclasses"},
+		{"DB_DRIVER": "oracle"},
+		{"DB_USER": "bot"},
+	} {
+		for _, key := range []string{"DATABASE_URL", "DB_DRIVER", "DB_USER", "DB_PASSWORD", "DB_NAME"} {
+			t.Setenv(key, env[key])
+		}
+		if _, err := database.ConfigFromEnv(); err == nil {
+			t.Errorf("Expected error for %v, but got none", env)
+		}
+	}
+}
+
+func TestSQLiteFile(t *testing.T) {
+	config := database.Config{Driver: database.DriverSQLite, DSN: filepath.Join(t.TempDir(), "ndclasses.db")}
+
+	db, err := database.Open(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite file: %v", err)
+	}
+	if _, err := db.MigrateUp(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if _, err := db.CreateUser(123, "test"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// The connection settings from the DSN reach the driver
+	var journalMode string
+	var foreignKeys, busyTimeout int
+	db.DB.Raw("PRAGMA journal_mode").Scan(&journalMode)
+	db.DB.Raw("PRAGMA foreign_keys").Scan(&foreignKeys)
+	db.DB.Raw("PRAGMA busy_timeout").Scan(&busyTimeout)
+	if journalMode != "wal" || foreignKeys != 1 || busyTimeout != 5000 {
+		t.Errorf("Expected WAL, foreign keys and a 5s busy timeout, got %q, %d and %d", journalMode, foreignKeys, busyTimeout)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Failed to close database: %v", err)
+	}
+
+	// The data outlives the connection
+	db, err = database.Open(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite file: %v", err)
+	}
+	defer db.Close()
+
+	if applied, err := db.MigrateUp(); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no pending migrations after reopening, got %d (%v)", len(applied), err)
+	}
+	if _, err := db.GetUserByTelegramID(123); err != nil {
+		t.Errorf("Expected user to be stored in the file, got: %v", err)
+	}
+}
+
+func TestConfigFromEnvPostgresSettings(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("DB_DRIVER", "")
+	t.Setenv("DB_USER", "bot")
+	t.Setenv("DB_PASSWORD", "it's secret")
+	t.Setenv("DB_NAME", "ndclasses")
+	t.Setenv("DB_SSLMODE", "verify-full")
+	t.Setenv("DB_SSLROOTCERT", "/etc/ssl/db-ca.pem")
+	t.Setenv("DB_MAX_OPEN_CONNS", "20")
+	t.Setenv("DB_MAX_IDLE_CONNS", "")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "1h")
+	t.Setenv("DB_CONN_MAX_IDLE_TIME", "")
+	t.Setenv("DB_CONNECT_TIMEOUT", "1m")
+
+	config, err := database.ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv failed: %v", err)
+	}
+
+	for _, want := range []string{`password='it\'s secret'`, "sslmode=verify-full", "sslrootcert=/etc/ssl/db-ca.pem"} {
+		if !strings.Contains(config.DSN, want) {
+			t.Errorf("Expected DSN to contain %q, got: %s", want, config.DSN)
+		}
+	}
+
+	if config.MaxOpenConns != 20 || config.MaxIdleConns != 5 || config.ConnMaxLifetime != time.Hour || config.ConnMaxIdleTime != 5*time.Minute || config.ConnectTimeout != time.Minute {
+		t.Errorf("Unexpected pool settings: %+v", config)
+	}
+
+	for key, value := range map[string]string{"DB_SSLMODE": "sometimes", "DB_MAX_OPEN_CONNS": "many", "DB_CONNECT_TIMEOUT": "30"} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+			if _, err := database.ConfigFromEnv(); err == nil {
+				t.Errorf("Expected error for %s=%s, but got none", key, value)
+			}
+		})
+	}
+}
+
+func TestOpenRetriesUntilTimeout(t *testing.T) {
+	// Nothing listens on port 1, so every attempt is refused
+	config := database.Config{
+		Driver:         database.DriverPostgres,
+		DSN:            "host=127.0.0.1 port=1 user=bot password=secret dbname=ndclasses sslmode=disable connect_timeout=1",
+		ConnectTimeout: 1200 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err := database.Open(context.Background(), config); err == nil {
+		t.Fatal("Expected error for unreachable database, but got none")
+	}
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("Expected retries for about the connect timeout, took %v", elapsed)
+	}
+
+	// Cancelling stops the retries early
+	config.ConnectTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	if _, err := database.Open(ctx, config); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected cancellation to stop retrying, took %v", elapsed)
+	}
+}
+
+func TestPing(t *testing.T) {
+	db := setupTestDB(t)
+
+	if err := db.Ping(context.Background()); err != nil {
+		t.Errorf("Expected ping to succeed, got: %v", err)
+	}
+
+	db.Close()
+	if err := db.Ping(context.Background()); err == nil {
+		t.Error("Expected ping of a closed database to fail, but got none")
+	}
+}
+
+// stores returns every Store implementation, so they can be held to the same behaviour
+func stores(t *testing.T) map[string]database.Store {
+	memory := database.NewMemory()
+	t.Cleanup(func() { memory.Close() })
+
+	return map[string]database.Store{
+		"gorm":   setupTestDB(t),
+		"memory": memory,
+	}
+}
+
+func TestStoreTracking(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			user, err := store.CreateUser(123, "test")
+			if err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+			if again, err := store.CreateUser(123, "other"); err != nil || again.ID != user.ID || again.Username != "test" {
+				t.Errorf("Expected the existing user, got %+v (%v)", again, err)
+			}
+			if _, err := store.GetUserByTelegramID(456); !errors.Is(err, database.ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for unknown user, got: %v", err)
+			}
+
+			// Users are listed in the order they joined
+			other, _ := store.CreateUser(99, "other")
+			users, err := store.GetAllUsers()
+			if err != nil || len(users) != 2 || users[0].ID != user.ID || users[1].ID != other.ID {
+				t.Errorf("Expected both users in order, got %+v (%v)", users, err)
+			}
+
+			fall, _ := store.AddTrackedCRN(user.ID, "12345", "202510", "Test Class")
+			spring, _ := store.AddTrackedCRN(user.ID, "12345", "202520", "Test Class")
+			store.AddTrackedCRN(user.ID, "67890", "202510", "Other Class")
+
+			// Removing by ID leaves the same CRN in other terms alone
+			if err := store.RemoveTrackedCRNByID(user.ID, fall.ID); err != nil {
+				t.Fatalf("Failed to remove tracked CRN: %v", err)
+			}
+			crns, _ := store.GetUserTrackedCRNs(user.ID)
+			if len(crns) != 2 || crns[0].ID != spring.ID || crns[1].CRN != "67890" {
+				t.Errorf("Expected the spring section and 67890, got %+v", crns)
+			}
+
+			// Adding it again reactivates the same row
+			readded, _ := store.AddTrackedCRN(user.ID, "12345", "202510", "Test Class")
+			if readded.ID != fall.ID || !readded.Active {
+				t.Errorf("Expected the removed row to be reactivated, got %+v", readded)
+			}
+
+			if err := store.SnoozeTrackedCRN(user.ID, fall.ID, 1000); err != nil {
+				t.Fatalf("Failed to snooze: %v", err)
+			}
+			if err := store.UpdateCRNTitle(user.ID, "12345", "202510", "Renamed"); err != nil {
+				t.Fatalf("Failed to update title: %v", err)
+			}
+			got, err := store.GetTrackedCRN(user.ID, fall.ID)
+			if err != nil || got.SnoozedUntil != 1000 || got.Title != "Renamed" {
+				t.Errorf("Unexpected tracked CRN: %+v (%v)", got, err)
+			}
+			if other, _ := store.GetTrackedCRN(user.ID, spring.ID); other.Title != "Test Class" {
+				t.Errorf("Expected the title in other terms to be unchanged, got %q", other.Title)
+			}
+
+			// Other users can't see the row
+			if _, err := store.GetTrackedCRN(user.ID+1, fall.ID); !errors.Is(err, database.ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for another user's CRN, got: %v", err)
+			}
+
+			// Removing a row leaves the same CRN in other terms alone
+			store.RemoveTrackedCRNByID(user.ID, fall.ID)
+			all, _ := store.GetAllTrackedCRNs()
+			if len(all) != 2 || all[0].ID != spring.ID || all[1].CRN != "67890" {
+				t.Errorf("Expected the spring section and 67890 to be tracked, got %+v", all)
+			}
+
+			// Adding it back doesn't bring back the old snooze
+			readded, _ = store.AddTrackedCRN(user.ID, "12345", "202510", "Renamed")
+			if readded.SnoozedUntil != 0 {
+				t.Errorf("Expected the snooze to be reset, got %+v", readded)
+			}
+			if got, _ := store.GetTrackedCRN(user.ID, fall.ID); got.SnoozedUntil != 0 {
+				t.Errorf("Expected the stored snooze to be reset, got %+v", got)
+			}
+		})
+	}
+}
+
+func TestStoreSections(t *testing.T) {
+	for name, store := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.GetSectionState("202510", "12345"); !errors.Is(err, database.ErrNotFound) {
+				t.Errorf("Expected ErrNotFound for unknown section, got: %v", err)
+			}
+
+			state := &database.SectionState{Term: "202510", CRN: "12345", Seats: 2, Open: true}
+			if err := store.SaveSectionState(state); err != nil || state.ID == 0 {
+				t.Fatalf("Failed to save section state: %v", err)
+			}
+			state.Seats = 0
+			store.SaveSectionState(state)
+			if saved, _ := store.GetSectionState("202510", "12345"); saved.Seats != 0 || saved.ID != state.ID {
+				t.Errorf("Expected updated state, got %+v", saved)
+			}
+
+			store.RecordNotification(1, "202510", "12345", database.NotificationOpened, 100, false)
+			if delivered, _ := store.GetDeliveredNotifications("202510", "12345", 100); len(delivered) != 0 {
+				t.Error("Expected failed notification not to be delivered")
+			}
+			store.RecordNotification(1, "202510", "12345", database.NotificationOpened, 100, true)
+			if delivered, _ := store.GetDeliveredNotifications("202510", "12345", 100); len(delivered) != 1 {
+				t.Error("Expected notification to be delivered after retry")
+			}
+
+			// A section's delivered alerts are loaded together, from a given transition on
+			store.RecordNotification(2, "202510", "12345", database.NotificationClosed, 200, true)
+			store.RecordNotification(3, "202510", "12345", database.NotificationOpened, 300, false)
+			store.RecordNotification(1, "202520", "12345", database.NotificationOpened, 300, true)
+			if delivered, err := store.GetDeliveredNotifications("202510", "12345", 100); err != nil || len(delivered) != 2 {
+				t.Errorf("Expected the 2 delivered alerts of the section, got %+v (%v)", delivered, err)
+			}
+			if delivered, _ := store.GetDeliveredNotifications("202510", "12345", 150); len(delivered) != 1 || delivered[0].UserID != 2 {
+				t.Errorf("Expected only the alert at 200, got %+v", delivered)
+			}
+
+			for _, at := range []int64{300, 100, 200} {
+				store.RecordObservation(&database.SectionObservation{Term: "202510", CRN: "12345", Success: true, ObservedAt: at})
+			}
+			if deleted, err := store.PruneObservations(150); err != nil || deleted != 1 {
+				t.Errorf("Expected 1 pruned observation, got %d (%v)", deleted, err)
+			}
+			observations, _ := store.GetObservations("202510", "12345", 0)
+			if len(observations) != 2 || observations[0].ObservedAt != 200 || observations[1].ObservedAt != 300 {
+				t.Errorf("Expected observations at 200 and 300, got %+v", observations)
+			}
+
+			if err := store.Ping(context.Background()); err != nil {
+				t.Errorf("Expected ping to succeed, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestPasswordIsRedacted(t *testing.T) {
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("DB_DRIVER", "")
+	t.Setenv("DB_USER", "bot")
+	t.Setenv("DB_PASSWORD", "db-password-for-redaction")
+	t.Setenv("DB_NAME", "ndclasses")
+
+	config, err := database.ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv failed: %v", err)
+	}
+	if redacted := logger.Redact("connecting with " + config.DSN); strings.Contains(redacted, "db-password-for-redaction") {
+		t.Errorf("Expected the password to be masked, got: %s", redacted)
+	}
+
+	t.Setenv("DATABASE_URL", "postgres://bot:url-password-for-redaction@db:5432/ndclasses")
+	if _, err := database.ConfigFromEnv(); err != nil {
+		t.Fatalf("ConfigFromEnv failed: %v", err)
+	}
+	if redacted := logger.Redact("failed: url-password-for-redaction"); strings.Contains(redacted, "url-password-for-redaction") {
+		t.Errorf("Expected the password from DATABASE_URL to be masked, got: %s", redacted)
+	}
+}
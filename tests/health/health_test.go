@@ -0,0 +1,197 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"NDClasses/clients/health"
+)
+
+type fakeDB struct{ err error }
+
+func (f fakeDB) Ping(ctx context.Context) error { return f.err }
+
+type fakeChecker struct{ last time.Time }
+
+func (f fakeChecker) LastCycle() time.Time { return f.last }
+
+func (f fakeChecker) Paused() (bool, time.Time) { return false, time.Time{} }
+
+// busyChecker has always just finished a cycle
+type busyChecker struct{}
+
+func (busyChecker) LastCycle() time.Time { return time.Now() }
+
+func (busyChecker) Paused() (bool, time.Time) { return false, time.Time{} }
+
+// pausedChecker was paused or resumed at changed
+type pausedChecker struct {
+	paused  bool
+	changed time.Time
+}
+
+func (*pausedChecker) LastCycle() time.Time { return time.Time{} }
+
+func (p *pausedChecker) Paused() (bool, time.Time) { return p.paused, p.changed }
+
+type fakePoller struct{ last time.Time }
+
+func (f fakePoller) LastPoll() time.Time { return f.last }
+
+type fakeParser struct {
+	rate    float64
+	samples int
+}
+
+func (f fakeParser) SuccessRate() (float64, int) { return f.rate, f.samples }
+
+// get requests a health endpoint and decodes the report
+func get(t *testing.T, handler http.Handler) (int, health.Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON, got %q", ct)
+	}
+
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Can't decode report %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, report
+}
+
+func TestHealthy(t *testing.T) {
+	now := time.Now()
+	h := health.New(fakeDB{}, fakeChecker{now}, fakePoller{now}, fakeParser{0.95, 40})
+
+	for _, handler := range []http.Handler{h.LivenessHandler(), h.ReadinessHandler()} {
+		code, report := get(t, handler)
+		if code != http.StatusOK || report.Status != health.StatusOK {
+			t.Errorf("Expected a healthy report, got %d %+v", code, report)
+		}
+	}
+
+	_, report := get(t, h.ReadinessHandler())
+	for _, name := range []string{"database", "checker", "updates", "parser"} {
+		if report.Checks[name].Status != health.StatusOK {
+			t.Errorf("Expected %s to be ok, got %+v", name, report.Checks[name])
+		}
+	}
+	if parser := report.Checks["parser"]; parser.SuccessRate == nil || *parser.SuccessRate != 0.95 || parser.Samples != 40 {
+		t.Errorf("Expected the parser success rate in the report, got %+v", parser)
+	}
+	if !report.Checks["checker"].LastSuccess.Equal(now) {
+		t.Errorf("Expected the last cycle time in the report, got %v", report.Checks["checker"].LastSuccess)
+	}
+}
+
+func TestCheckerStalled(t *testing.T) {
+	t.Setenv("HEALTH_STALL_AFTER", "50ms")
+
+	// A checker that hasn't finished a cycle is given stallAfter from startup
+	h := health.New(fakeDB{}, fakeChecker{}, nil, fakeParser{1, 0})
+	if code, report := get(t, h.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("Expected a grace period after startup, got %d %+v", code, report)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	for _, handler := range []http.Handler{h.LivenessHandler(), h.ReadinessHandler()} {
+		code, report := get(t, handler)
+		if code != http.StatusServiceUnavailable || report.Status != health.StatusFail {
+			t.Errorf("Expected a stalled checker to fail, got %d %+v", code, report)
+		}
+		if report.Checks["checker"].Message == "" {
+			t.Errorf("Expected a reason for the failure, got %+v", report.Checks["checker"])
+		}
+	}
+
+	// Webhook mode has no polling to report on
+	if _, report := get(t, h.ReadinessHandler()); len(report.Checks) != 3 {
+		t.Errorf("Expected no updates check without a poller, got %+v", report.Checks)
+	}
+}
+
+func TestCheckerPaused(t *testing.T) {
+	t.Setenv("HEALTH_STALL_AFTER", "50ms")
+
+	// A paused checker isn't stalled, however long ago its last cycle was
+	checker := &pausedChecker{paused: true, changed: time.Now()}
+	h := health.New(fakeDB{}, checker, nil, fakeParser{1, 0})
+	time.Sleep(100 * time.Millisecond)
+
+	code, report := get(t, h.LivenessHandler())
+	if code != http.StatusOK || !strings.Contains(report.Checks["checker"].Message, "paused") {
+		t.Errorf("Expected a paused checker to be ok, got %d %+v", code, report)
+	}
+
+	// Once resumed, it gets stallAfter to finish a cycle
+	checker.paused, checker.changed = false, time.Now()
+	if code, report := get(t, h.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("Expected a grace period after resuming, got %d %+v", code, report)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if code, report := get(t, h.LivenessHandler()); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a resumed checker to stall without a cycle, got %d %+v", code, report)
+	}
+}
+
+func TestDatabaseDown(t *testing.T) {
+	h := health.New(fakeDB{errors.New("dial tcp: connection refused, password=hunter2")}, fakeChecker{time.Now()}, nil, fakeParser{1, 0})
+
+	// A database outage isn't fixed by a restart, so the bot stays live
+	if code, _ := get(t, h.LivenessHandler()); code != http.StatusOK {
+		t.Errorf("Expected liveness to ignore the database, got %d", code)
+	}
+
+	code, report := get(t, h.ReadinessHandler())
+	if code != http.StatusServiceUnavailable || report.Checks["database"].Status != health.StatusFail {
+		t.Errorf("Expected readiness to fail without a database, got %d %+v", code, report)
+	}
+	if msg := report.Checks["database"].Message; msg == "" || strings.Contains(msg, "hunter2") {
+		t.Errorf("Expected a redacted reason, got %q", msg)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	t.Setenv("HEALTH_STALL_AFTER", "50ms")
+
+	// Polling hasn't succeeded since startup and most searches fail
+	h := health.New(fakeDB{}, busyChecker{}, fakePoller{}, fakeParser{0.2, 50})
+	time.Sleep(100 * time.Millisecond)
+
+	code, report := get(t, h.ReadinessHandler())
+	if code != http.StatusOK || report.Status != health.StatusWarn {
+		t.Errorf("Expected a warning that doesn't fail readiness, got %d %+v", code, report)
+	}
+	for _, name := range []string{"updates", "parser"} {
+		if report.Checks[name].Status != health.StatusWarn {
+			t.Errorf("Expected %s to warn, got %+v", name, report.Checks[name])
+		}
+	}
+
+	// A few failed searches aren't enough to judge
+	h = health.New(fakeDB{}, fakeChecker{time.Now()}, nil, fakeParser{0, 3})
+	if _, report := get(t, h.ReadinessHandler()); report.Checks["parser"].Status != health.StatusOK {
+		t.Errorf("Expected too few samples to be ok, got %+v", report.Checks["parser"])
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	h := health.New(fakeDB{}, fakeChecker{time.Now()}, nil, fakeParser{1, 0})
+
+	rec := httptest.NewRecorder()
+	h.ReadinessHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
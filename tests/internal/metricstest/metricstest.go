@@ -0,0 +1,29 @@
+// Package metricstest reads the bot's metrics back in tests
+package metricstest
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"NDClasses/clients/metrics"
+)
+
+// Value returns the value of one series in the default registry as it would be scraped,
+// named like ndclasses_checker_last_cycle_timestamp_seconds, or 0 if it has no samples yet
+func Value(t *testing.T, series string) float64 {
+	t.Helper()
+
+	var b strings.Builder
+	metrics.Default().WriteTo(&b)
+	for _, line := range strings.Split(b.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Bad value in %q: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
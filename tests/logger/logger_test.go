@@ -1,9 +1,15 @@
 package logger_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"NDClasses/clients/logger"
 )
@@ -133,3 +139,235 @@ func TestLoggerBasic(t *testing.T) {
 		t.Error("Debug mode should not be set")
 	}
 }
+
+func TestWarn(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelInfo, Stdout: &stdout, Stderr: &stderr})
+
+	l.Warn("Test warning: %s", "retrying")
+
+	if !strings.Contains(stdout.String(), "[WARN] Test warning: retrying") {
+		t.Errorf("Expected warning on stdout, got: %s", stdout.String())
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("Expected nothing on stderr, got: %s", stderr.String())
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelWarn, Stdout: &stdout, Stderr: &stderr})
+
+	l.Debug("debug")
+	l.Info("info")
+	l.Warn("warn")
+	l.Error("error")
+
+	if strings.Contains(stdout.String(), "[DEBUG]") || strings.Contains(stdout.String(), "[INFO]") {
+		t.Errorf("Expected messages below warn to be dropped, got: %s", stdout.String())
+	}
+	if !strings.Contains(stdout.String(), "[WARN] warn") {
+		t.Errorf("Expected warning, got: %s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "[ERROR] error") {
+		t.Errorf("Expected error, got: %s", stderr.String())
+	}
+	if l.IsDebugMode() {
+		t.Error("Debug mode should not be set at warn level")
+	}
+}
+
+func TestTextFields(t *testing.T) {
+	var stdout bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelInfo, Stdout: &stdout, Stderr: io.Discard})
+
+	l.Component("checker").With("crn", "12345", "term", "Fall 2025", "duration", 1500*time.Microsecond).Info("Checked %d sections", 3)
+
+	output := stdout.String()
+	expected := `[INFO] Checked 3 sections component=checker crn=12345 term="Fall 2025" duration=2ms`
+	if !strings.Contains(output, expected) {
+		t.Errorf("Expected %q, got: %s", expected, output)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelInfo, Format: logger.FormatJSON, Stdout: &stdout, Stderr: &stderr})
+
+	l.Component("telegram").With("chat_id", 42).Info("Handled %s", "/list")
+	l.Error("Failed: %v", "timeout")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON object on stdout, got %q: %v", stdout.String(), err)
+	}
+	if entry["level"] != "INFO" || entry["msg"] != "Handled /list" {
+		t.Errorf("Unexpected level or message: %v", entry)
+	}
+	if entry["component"] != "telegram" || entry["chat_id"] != float64(42) {
+		t.Errorf("Expected fields in JSON output, got: %v", entry)
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Errorf("Expected a timestamp, got: %v", entry)
+	}
+
+	if err := json.Unmarshal(stderr.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected errors as JSON on stderr, got %q: %v", stderr.String(), err)
+	}
+	if entry["level"] != "ERROR" || entry["msg"] != "Failed: timeout" {
+		t.Errorf("Unexpected error entry: %v", entry)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "")
+
+	config, err := logger.ConfigFromEnv(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Level != slog.LevelInfo || config.Format != logger.FormatText {
+		t.Errorf("Expected info level and text format by default, got %v %q", config.Level, config.Format)
+	}
+
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LOG_FORMAT", "JSON")
+	config, err = logger.ConfigFromEnv(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Level != slog.LevelWarn || config.Format != logger.FormatJSON {
+		t.Errorf("Expected warn level and JSON format, got %v %q", config.Level, config.Format)
+	}
+
+	// The debug flag wins over LOG_LEVEL
+	config, err = logger.ConfigFromEnv(true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Level != slog.LevelDebug {
+		t.Errorf("Expected debug level with the debug flag, got %v", config.Level)
+	}
+	if !logger.NewWithConfig(config).IsDebugMode() {
+		t.Error("Expected debug mode with the debug flag")
+	}
+
+	// LOG_LEVEL=debug shows debug messages but leaves debug mode, and the parser's headless browser, alone
+	t.Setenv("LOG_LEVEL", "debug")
+	config, err = logger.ConfigFromEnv(false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Level != slog.LevelDebug {
+		t.Errorf("Expected debug level from LOG_LEVEL, got %v", config.Level)
+	}
+	if logger.NewWithConfig(config).IsDebugMode() {
+		t.Error("Expected LOG_LEVEL=debug not to turn on debug mode")
+	}
+
+	t.Setenv("LOG_LEVEL", "loud")
+	if _, err := logger.ConfigFromEnv(false); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("LOG_FORMAT", "xml")
+	if _, err := logger.ConfigFromEnv(false); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	previous := logger.Default()
+	defer logger.SetDefault(previous)
+
+	var stdout bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelInfo, Stdout: &stdout, Stderr: io.Discard})
+	logger.SetDefault(l)
+
+	if logger.Default() != l {
+		t.Error("Expected Default to return the logger passed to SetDefault")
+	}
+
+	// The slog package functions go through it too
+	slog.Info("From slog", "key", "value")
+	if !strings.Contains(stdout.String(), "[INFO] From slog key=value") {
+		t.Errorf("Expected slog output through the default logger, got: %s", stdout.String())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		input  string
+		secret string
+	}{
+		{`Get "https://api.telegram.org/bot123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw/getMe": timeout`, "AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"},
+		{"can't connect to postgres://bot:hunter2@db:5432/ndclasses", "hunter2"},
+		{"host=localhost user=bot password=hunter2 dbname=ndclasses", "hunter2"},
+		{`host=localhost user=bot password='it\'s secret' dbname=ndclasses`, "secret"},
+		{"DB_PASSWORD=hunter2", "hunter2"},
+		{"Cookie: JSESSIONID=ABC123DEF; BIGipServer=xyz", "ABC123DEF"},
+		{"Set-Cookie: JSESSIONID=ABC123DEF; Path=/", "ABC123DEF"},
+		{"searchResults?txt_term=202520&uniqueSessionId=abcde1760000000000&pageOffset=0", "abcde1760000000000"},
+	}
+
+	for _, tt := range tests {
+		redacted := logger.Redact(tt.input)
+		if strings.Contains(redacted, tt.secret) {
+			t.Errorf("Redact(%q) = %q, still contains the secret", tt.input, redacted)
+		}
+		if !strings.Contains(redacted, logger.Redacted) {
+			t.Errorf("Redact(%q) = %q, expected a marker where the secret was", tt.input, redacted)
+		}
+	}
+
+	// The bot ID and the rest of the text stay readable
+	redacted := logger.Redact("bot123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw/getMe")
+	if redacted != "bot123456789:"+logger.Redacted+"/getMe" {
+		t.Errorf("Unexpected redaction: %q", redacted)
+	}
+
+	// Ordinary messages are left alone
+	for _, s := range []string{"Checked 3 sections in 1.2s", "CRN 12345 in term 202520 has 4/30 seats", "2025/10/16 20:13:24"} {
+		if redacted := logger.Redact(s); redacted != s {
+			t.Errorf("Redact(%q) = %q, expected no change", s, redacted)
+		}
+	}
+}
+
+func TestAddSecret(t *testing.T) {
+	logger.AddSecret("plain-api-key-42")
+	logger.AddSecret("") // Ignored, as it would match everywhere
+
+	if redacted := logger.Redact("using key plain-api-key-42"); redacted != "using key "+logger.Redacted {
+		t.Errorf("Expected registered secret to be masked, got: %q", redacted)
+	}
+	if redacted := logger.Redact("nothing to hide"); redacted != "nothing to hide" {
+		t.Errorf("Expected text without secrets to be unchanged, got: %q", redacted)
+	}
+}
+
+func TestLogOutputIsRedacted(t *testing.T) {
+	logger.AddSecret("registered-secret-value")
+	leak := errors.New(`Post "https://example.com/login?password=hunter2": registered-secret-value`)
+
+	for _, format := range []string{logger.FormatText, logger.FormatJSON} {
+		var stdout, stderr bytes.Buffer
+		l := logger.NewWithConfig(logger.Config{Level: slog.LevelDebug, Format: format, Stdout: &stdout, Stderr: &stderr})
+
+		l.With("dsn", "postgres://bot:hunter2@db/ndclasses").Info("Connecting with registered-secret-value")
+		l.With("err", leak).Error("Request failed: %v", leak)
+		l.Slog().Warn("From slog", slog.Group("request", slog.String("cookie", "Cookie: JSESSIONID=hunter2")))
+
+		output := stdout.String() + stderr.String()
+		for _, secret := range []string{"hunter2", "registered-secret-value"} {
+			if strings.Contains(output, secret) {
+				t.Errorf("Expected %s output to hide %q, got: %s", format, secret, output)
+			}
+		}
+		if !strings.Contains(output, logger.Redacted) {
+			t.Errorf("Expected %s output to mark redacted secrets, got: %s", format, output)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"NDClasses/clients/metrics"
+)
+
+func scrape(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounter("test_events_total", "Events seen.")
+
+	c.Inc()
+	c.Add(2.5)
+	c.Add(-1) // Counters never go down
+
+	if c.Value() != 3.5 {
+		t.Errorf("Expected 3.5, got %v", c.Value())
+	}
+
+	expected := "# HELP test_events_total Events seen.\n# TYPE test_events_total counter\ntest_events_total 3.5\n"
+	if output := scrape(t, r); output != expected {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", output, expected)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	r := metrics.NewRegistry()
+	g := r.NewGauge("test_queue_length", "Items waiting.")
+
+	g.Set(10)
+	g.Add(-3)
+
+	if g.Value() != 7 {
+		t.Errorf("Expected 7, got %v", g.Value())
+	}
+	if output := scrape(t, r); !strings.Contains(output, "# TYPE test_queue_length gauge\ntest_queue_length 7\n") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	r := metrics.NewRegistry()
+	h := r.NewHistogram("test_duration_seconds", "Request durations.", []float64{1, 0.1, 0.5})
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(v)
+	}
+
+	if count, sum := h.Count(); count != 5 || sum != 3.15 {
+		t.Errorf("Expected 5 observations summing to 3.15, got %d and %v", count, sum)
+	}
+
+	expected := `# HELP test_duration_seconds Request durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 2
+test_duration_seconds_bucket{le="0.5"} 3
+test_duration_seconds_bucket{le="1"} 4
+test_duration_seconds_bucket{le="+Inf"} 5
+test_duration_seconds_sum 3.15
+test_duration_seconds_count 5
+`
+	if output := scrape(t, r); output != expected {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", output, expected)
+	}
+}
+
+func TestVecs(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests.", "method", "code")
+	durations := r.NewHistogramVec("test_request_seconds", "Request durations.", []float64{1}, "method")
+
+	requests.With("sendMessage", "429").Inc()
+	requests.With("getUpdates", "502").Add(2)
+	requests.With("sendMessage", "429").Inc()
+	durations.With("getMe").Observe(0.5)
+
+	if v := requests.With("sendMessage", "429").Value(); v != 2 {
+		t.Errorf("Expected the same series for the same labels, got %v", v)
+	}
+
+	output := scrape(t, r)
+	for _, want := range []string{
+		"test_requests_total{method=\"getUpdates\",code=\"502\"} 2\ntest_requests_total{method=\"sendMessage\",code=\"429\"} 2\n",
+		`test_request_seconds_bucket{method="getMe",le="1"} 1`,
+		`test_request_seconds_count{method="getMe"} 1`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+
+	// Metrics are written in name order
+	if strings.Index(output, "test_request_seconds") > strings.Index(output, "test_requests_total") {
+		t.Errorf("Expected metrics sorted by name:\n%s", output)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := r.NewCounterVec("test_errors_total", "Errors,\nby \\ reason.", "reason")
+	c.With("bad \"quote\"\nand \\ slash").Inc()
+
+	output := scrape(t, r)
+	for _, want := range []string{
+		`# HELP test_errors_total Errors,\nby \\ reason.`,
+		`test_errors_total{reason="bad \"quote\"\nand \\ slash"} 1`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected %q in output:\n%s", want, output)
+		}
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	r := metrics.NewRegistry()
+	v := r.NewCounterVec("test_total", "Test.", "label")
+
+	assertPanics(t, "duplicate name", func() { r.NewGauge("test_total", "Again.") })
+	assertPanics(t, "wrong number of labels", func() { v.With("a", "b") })
+}
+
+func assertPanics(t *testing.T, name string, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for %s", name)
+		}
+	}()
+	fn()
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("test_scrapes_total", "Scrapes.").Inc()
+
+	server := httptest.NewServer(r.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Expected the Prometheus text format, got %q", ct)
+	}
+	if !strings.Contains(body.String(), "test_scrapes_total 1") {
+		t.Errorf("Unexpected body:\n%s", body.String())
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,696 @@
+package ndparser_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"NDClasses/clients/logger"
+	"NDClasses/clients/ndparser"
+	"NDClasses/tests/internal/metricstest"
+)
+
+const termsJSON = `[{"code":"202520","description":"Spring Semester 2026"},{"code":"202510","description":"Fall Semester 2025"}]`
+
+const searchJSON = `{"success":true,"totalCount":1,"data":[{
+	"courseReferenceNumber":"12345","term":"202510","subject":"CSE","courseNumber":"20311","sequenceNumber":"01",
+	"courseTitle":"Fundamentals of Computing","creditHours":null,"creditHourLow":3,
+	"maximumEnrollment":30,"enrollment":26,"seatsAvailable":4,"waitCapacity":10,"waitCount":2,
+	"campusDescription":"Main","scheduleTypeDescription":"Lecture",
+	"faculty":[{"displayName":"Smith, Jane","primaryIndicator":false},{"displayName":"Doe, John","primaryIndicator":true}],
+	"meetingsFaculty":[{"meetingTime":{"beginTime":"0930","endTime":"1020","building":"DBRT","buildingDescription":"DeBartolo Hall","room":"102",
+		"monday":true,"tuesday":false,"wednesday":true,"thursday":false,"friday":true,"saturday":false,"sunday":false}}]
+}]}`
+
+const springSearchJSON = `{"success":true,"totalCount":1,"data":[{"courseReferenceNumber":"12345","courseTitle":"Data Structures","seatsAvailable":0}]}`
+
+// newFakeBanner starts an httptest stand-in for the Banner endpoints used by the parser
+func newFakeBanner(t *testing.T, sessions *int32) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/StudentRegistration/ssb/classSearch/getTerms", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(termsJSON))
+	})
+
+	mux.HandleFunc("/StudentRegistration/ssb/term/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST request, got %s", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Failed to parse form: %v", err)
+		}
+
+		// The session cookie remembers which term was selected
+		atomic.AddInt32(sessions, 1)
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: r.PostForm.Get("term"), Path: "/"})
+		w.Write([]byte(`{"fwdURL":"/StudentRegistration/ssb/classSearch/classSearch"}`))
+	})
+
+	mux.HandleFunc("/StudentRegistration/ssb/classSearch/resetDataForm", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("true"))
+	})
+
+	mux.HandleFunc("/StudentRegistration/ssb/searchResults/searchResults", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// Banner rejects searches that aren't tied to a session for the same term
+		if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != query.Get("txt_term") {
+			w.Write([]byte(`{"success":false,"data":null}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case query.Get("txt_subject") == "CSE" && query.Get("txt_courseNumber") == "20311" && query.Get("txt_keywordlike") == "":
+			w.Write([]byte(searchJSON))
+		case query.Get("txt_keywordlike") != "12345":
+			w.Write([]byte(`{"success":true,"totalCount":0,"data":[]}`))
+		case query.Get("txt_term") == "202520":
+			w.Write([]byte(springSearchJSON))
+		default:
+			w.Write([]byte(searchJSON))
+		}
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestBannerSearchSections(t *testing.T) {
+	var sessions int32
+	server := newFakeBanner(t, &sessions)
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL+"/StudentRegistration", "Fall Semester 2025")
+
+	classes, err := parser.SearchSections(context.Background(), "", ndparser.SectionQuery{Subject: "cse", CourseNumber: "20311"})
+	if err != nil {
+		t.Fatalf("SearchSections failed: %v", err)
+	}
+
+	if len(classes) != 1 || classes[0].CRN != "12345" || classes[0].Term != "202510" {
+		t.Errorf("Expected section 12345 of the fall term, got %+v", classes)
+	}
+
+	classes, err = parser.SearchSections(context.Background(), "", ndparser.SectionQuery{Subject: "CSE", CourseNumber: "99999"})
+	if err != nil {
+		t.Fatalf("SearchSections failed: %v", err)
+	}
+	if len(classes) != 0 {
+		t.Errorf("Expected no sections of an unknown course, got %+v", classes)
+	}
+}
+
+func TestBannerSearchClass(t *testing.T) {
+	var sessions int32
+	server := newFakeBanner(t, &sessions)
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL+"/StudentRegistration", "Fall Semester 2025")
+
+	class, err := parser.SearchClass(context.Background(), "", "12345")
+	if err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	if class.CRN != "12345" {
+		t.Errorf("Expected CRN '12345', got '%s'", class.CRN)
+	}
+
+	if class.Term != "202510" {
+		t.Errorf("Expected term '202510', got '%s'", class.Term)
+	}
+
+	if class.Title != "Fundamentals of Computing" {
+		t.Errorf("Expected title 'Fundamentals of Computing', got '%s'", class.Title)
+	}
+
+	if class.Seats != 4 {
+		t.Errorf("Expected 4 seats, got %d", class.Seats)
+	}
+
+	// A second search should reuse the existing session
+	if _, err := parser.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Fatalf("Second SearchClass failed: %v", err)
+	}
+
+	if atomic.LoadInt32(&sessions) != 1 {
+		t.Errorf("Expected 1 term session, got %d", sessions)
+	}
+}
+
+func TestBannerSearchClassDetails(t *testing.T) {
+	var sessions int32
+	server := newFakeBanner(t, &sessions)
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL+"/StudentRegistration", "Fall Semester 2025")
+
+	class, err := parser.SearchClass(context.Background(), "", "12345")
+	if err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	if class.Course() != "CSE 20311-01" {
+		t.Errorf("Expected course 'CSE 20311-01', got '%s'", class.Course())
+	}
+
+	if len(class.Instructors) != 2 || class.Instructors[0] != "Doe, John" {
+		t.Errorf("Expected primary instructor first, got %v", class.Instructors)
+	}
+
+	if len(class.Meetings) != 1 {
+		t.Fatalf("Expected 1 meeting, got %d", len(class.Meetings))
+	}
+
+	if got := class.Meetings[0].String(); got != "MWF 09:30-10:20, DeBartolo Hall 102" {
+		t.Errorf("Unexpected meeting: %s", got)
+	}
+
+	if class.CreditHours != 3 {
+		t.Errorf("Expected 3 credit hours, got %g", class.CreditHours)
+	}
+
+	if class.Enrollment != 26 || class.Capacity != 30 {
+		t.Errorf("Expected 26/30 enrolled, got %d/%d", class.Enrollment, class.Capacity)
+	}
+
+	if class.WaitlistCount != 2 || class.WaitlistCapacity != 10 {
+		t.Errorf("Expected waitlist 2/10, got %d/%d", class.WaitlistCount, class.WaitlistCapacity)
+	}
+
+	if class.Campus != "Main" || class.ScheduleType != "Lecture" {
+		t.Errorf("Expected Main Lecture, got %s %s", class.Campus, class.ScheduleType)
+	}
+}
+
+func TestBannerSearchClassInOtherTerm(t *testing.T) {
+	var sessions int32
+	server := newFakeBanner(t, &sessions)
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL+"/StudentRegistration", "Fall Semester 2025")
+
+	if _, err := parser.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Fatalf("SearchClass in current term failed: %v", err)
+	}
+
+	class, err := parser.SearchClass(context.Background(), "202520", "12345")
+	if err != nil {
+		t.Fatalf("SearchClass in spring term failed: %v", err)
+	}
+
+	if class.Term != "202520" || class.Title != "Data Structures" {
+		t.Errorf("Expected spring section, got %+v", class)
+	}
+
+	// Switching terms requires a new term session
+	if atomic.LoadInt32(&sessions) != 2 {
+		t.Errorf("Expected 2 term sessions, got %d", sessions)
+	}
+
+	if _, err := parser.SearchClass(context.Background(), "209910", "12345"); err == nil {
+		t.Error("Expected error for unknown term, but got none")
+	}
+}
+
+func TestBannerSearchClassNotFound(t *testing.T) {
+	var sessions int32
+	server := newFakeBanner(t, &sessions)
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL+"/StudentRegistration", "Fall Semester 2025")
+
+	_, err := parser.SearchClass(context.Background(), "", "99999")
+	if !errors.Is(err, ndparser.ErrClassNotFound) {
+		t.Errorf("Expected ErrClassNotFound, got: %v", err)
+	}
+}
+
+func TestBannerSearchClassServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL, "")
+
+	if _, err := parser.SearchClass(context.Background(), "", "12345"); err == nil {
+		t.Error("Expected error when the registration site is unavailable, but got none")
+	}
+}
+
+// newFlakyBanner starts an httptest stand-in for Banner whose search results come from the given handler
+func newFlakyBanner(t *testing.T, sessions *int32, searchResults http.HandlerFunc) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ssb/classSearch/getTerms", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(termsJSON))
+	})
+	mux.HandleFunc("/ssb/term/search", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(sessions, 1)
+		w.Write([]byte(`{}`))
+	})
+	mux.HandleFunc("/ssb/classSearch/resetDataForm", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("true"))
+	})
+	mux.HandleFunc("/ssb/searchResults/searchResults", searchResults)
+
+	return httptest.NewServer(mux)
+}
+
+func TestBannerRetriesExpiredSession(t *testing.T) {
+	var sessions, searches int32
+	server := newFlakyBanner(t, &sessions, func(w http.ResponseWriter, r *http.Request) {
+		// The first search is rejected as if the session had expired
+		if atomic.AddInt32(&searches, 1) == 1 {
+			w.Write([]byte(`{"success":false,"data":null}`))
+			return
+		}
+		w.Write([]byte(searchJSON))
+	})
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL, "Fall Semester 2025")
+
+	if _, err := parser.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	if atomic.LoadInt32(&sessions) != 2 || atomic.LoadInt32(&searches) != 2 {
+		t.Errorf("Expected 2 sessions and 2 searches, got %d and %d", sessions, searches)
+	}
+}
+
+func TestBannerDoesNotRetryOtherErrors(t *testing.T) {
+	var sessions, searches int32
+	server := newFlakyBanner(t, &sessions, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&searches, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	defer server.Close()
+
+	parser := ndparser.NewBanner(logger.New(false), server.URL, "Fall Semester 2025")
+
+	if _, err := parser.SearchClass(context.Background(), "", "12345"); err == nil {
+		t.Error("Expected error when searches fail, but got none")
+	}
+
+	if atomic.LoadInt32(&sessions) != 1 || atomic.LoadInt32(&searches) != 1 {
+		t.Errorf("Expected 1 session and 1 search, got %d and %d", sessions, searches)
+	}
+
+	// A canceled search isn't retried either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := parser.SearchClass(ctx, "", "12345"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+
+	if atomic.LoadInt32(&sessions) != 1 || atomic.LoadInt32(&searches) != 1 {
+		t.Errorf("Expected no more sessions or searches, got %d and %d", sessions, searches)
+	}
+}
+
+func TestFakeSearchClass(t *testing.T) {
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class", Seats: 2})
+
+	class, err := fake.SearchClass(context.Background(), "", "12345")
+	if err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	if class.Title != "Test Class" || class.Seats != 2 {
+		t.Errorf("Unexpected class: %+v", class)
+	}
+
+	// Updating a class should be visible on the next search
+	fake.Set(ndparser.Class{CRN: "12345", Title: "Test Class", Seats: 0})
+	class, _ = fake.SearchClass(context.Background(), "", "12345")
+	if class.Seats != 0 {
+		t.Errorf("Expected 0 seats after update, got %d", class.Seats)
+	}
+
+	if _, err := fake.SearchClass(context.Background(), "", "99999"); !errors.Is(err, ndparser.ErrClassNotFound) {
+		t.Errorf("Expected ErrClassNotFound, got: %v", err)
+	}
+
+	if fake.Calls("12345") != 2 {
+		t.Errorf("Expected 2 calls for 12345, got %d", fake.Calls("12345"))
+	}
+}
+
+func TestFakeSearchPage(t *testing.T) {
+	fake := ndparser.NewFake(
+		ndparser.Class{CRN: "10003", Subject: "CSE", CourseNumber: "20311", Title: "Fundamentals of Computing"},
+		ndparser.Class{CRN: "10001", Subject: "CSE", CourseNumber: "20311", Title: "Fundamentals of Computing"},
+		ndparser.Class{CRN: "10002", Subject: "CSE", CourseNumber: "20311", Title: "Fundamentals of Computing"},
+		ndparser.Class{CRN: "20001", Subject: "MATH", CourseNumber: "10550", Title: "Calculus I"},
+	)
+
+	page, err := fake.SearchPage(context.Background(), "", ndparser.SectionQuery{Subject: "cse", CourseNumber: "20311"}, 2, 2)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	if page.Total != 3 || len(page.Classes) != 1 || page.Classes[0].CRN != "10003" {
+		t.Errorf("Expected the last of 3 sections, got %+v", page)
+	}
+
+	page, err = fake.SearchPage(context.Background(), "", ndparser.SectionQuery{Keyword: "calculus"}, 0, 10)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	if page.Total != 1 || page.Classes[0].CRN != "20001" {
+		t.Errorf("Expected the calculus section, got %+v", page)
+	}
+}
+
+func TestFakeSetError(t *testing.T) {
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"})
+	injected := errors.New("registration site is down")
+
+	fake.SetError("12345", injected)
+	if _, err := fake.SearchClass(context.Background(), "", "12345"); !errors.Is(err, injected) {
+		t.Errorf("Expected injected error, got: %v", err)
+	}
+
+	fake.SetError("12345", nil)
+	if _, err := fake.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Errorf("Expected no error after clearing, got: %v", err)
+	}
+}
+
+func TestFakeTerms(t *testing.T) {
+	spring := ndparser.Term{Code: "202520", Description: "Spring Semester 2026"}
+
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Fall Class"})
+	fake.Set(ndparser.Class{CRN: "12345", Term: spring.Code, Title: "Spring Class"})
+
+	class, err := fake.SearchClass(context.Background(), spring.Code, "12345")
+	if err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	if class.Title != "Spring Class" {
+		t.Errorf("Expected 'Spring Class', got '%s'", class.Title)
+	}
+
+	class, err = fake.SearchClass(context.Background(), "", "12345")
+	if err != nil {
+		t.Fatalf("SearchClass in current term failed: %v", err)
+	}
+
+	if class.Title != "Fall Class" {
+		t.Errorf("Expected 'Fall Class', got '%s'", class.Title)
+	}
+}
+
+func TestFakeWithoutTerms(t *testing.T) {
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Fall Class"})
+	fake.SetTerms()
+
+	// Everything that needs the current term fails instead of panicking
+	if _, err := fake.CurrentTerm(context.Background()); err == nil {
+		t.Error("Expected CurrentTerm to fail without terms")
+	}
+	if _, err := fake.SearchClass(context.Background(), "", "12345"); err == nil {
+		t.Error("Expected SearchClass in the current term to fail without terms")
+	}
+	if _, err := fake.SearchSections(context.Background(), "", ndparser.SectionQuery{Keyword: "Fall"}); err == nil {
+		t.Error("Expected SearchSections in the current term to fail without terms")
+	}
+
+	// Named terms still work
+	if _, err := fake.SearchClass(context.Background(), ndparser.FakeTerm.Code, "12345"); err != nil {
+		t.Errorf("Expected SearchClass in a named term to work, got: %v", err)
+	}
+}
+
+func TestPickTerm(t *testing.T) {
+	terms := []ndparser.Term{
+		{Code: "202610", Description: "Fall Semester 2026"},
+		{Code: "202600", Description: "Summer Session 2026"},
+		{Code: "202520", Description: "Spring Semester 2026"},
+		{Code: "202510", Description: "Fall Semester 2025 (View Only)"},
+	}
+
+	tests := []struct {
+		now  time.Time
+		want string
+	}{
+		// Spring registration runs through the fall semester
+		{time.Date(2025, time.October, 20, 0, 0, 0, 0, time.UTC), "202520"},
+		// Fall registration opens in the spring, summer sessions are skipped
+		{time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC), "202610"},
+		{time.Date(2026, time.September, 1, 0, 0, 0, 0, time.UTC), "202610"},
+		// When nothing starts soon enough the newest term is used
+		{time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), "202610"},
+	}
+
+	for _, tt := range tests {
+		term, err := ndparser.PickTerm(terms, tt.now)
+		if err != nil {
+			t.Fatalf("PickTerm failed: %v", err)
+		}
+		if term.Code != tt.want {
+			t.Errorf("PickTerm(%s) = %s, want %s", tt.now.Format("2006-01-02"), term.Code, tt.want)
+		}
+	}
+
+	if _, err := ndparser.PickTerm(nil, time.Now()); err == nil {
+		t.Error("Expected error for empty term list, but got none")
+	}
+}
+
+func TestFindTerm(t *testing.T) {
+	terms := []ndparser.Term{
+		{Code: "202520", Description: "Spring Semester 2026"},
+		{Code: "202510", Description: "Fall Semester 2025"},
+	}
+
+	if term, ok := ndparser.FindTerm(terms, "202510"); !ok || term.Code != "202510" {
+		t.Errorf("Expected to find term by code, got %+v", term)
+	}
+
+	if term, ok := ndparser.FindTerm(terms, "spring semester"); !ok || term.Code != "202520" {
+		t.Errorf("Expected to find term by description, got %+v", term)
+	}
+
+	if _, ok := ndparser.FindTerm(terms, "Summer"); ok {
+		t.Error("Expected no term for 'Summer'")
+	}
+}
+
+// blockingSource is a class source that holds every search until released
+type blockingSource struct {
+	*ndparser.Fake
+	release  chan struct{}
+	inFlight atomic.Int32
+	maxSeen  atomic.Int32
+}
+
+func (b *blockingSource) SearchClass(ctx context.Context, term string, crn string) (*ndparser.Class, error) {
+	n := b.inFlight.Add(1)
+	defer b.inFlight.Add(-1)
+	for {
+		seen := b.maxSeen.Load()
+		if n <= seen || b.maxSeen.CompareAndSwap(seen, n) {
+			break
+		}
+	}
+
+	<-b.release
+	return b.Fake.SearchClass(ctx, term, crn)
+}
+
+func TestLimitedConcurrency(t *testing.T) {
+	source := &blockingSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"}),
+		release: make(chan struct{}),
+	}
+	limited := ndparser.NewLimited(source, 2, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := limited.SearchClass(context.Background(), "", "12345"); err != nil {
+				t.Errorf("SearchClass failed: %v", err)
+			}
+		}()
+	}
+
+	// Wait until the excess searches are queued
+	deadline := time.Now().Add(2 * time.Second)
+	for limited.Stats().Queued != 3 || limited.Stats().InFlight != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 queued and 2 in flight, got %+v", limited.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	close(source.release)
+	wg.Wait()
+
+	if source.maxSeen.Load() != 2 {
+		t.Errorf("Expected at most 2 concurrent searches, got %d", source.maxSeen.Load())
+	}
+
+	if stats := limited.Stats(); stats.Queued != 0 || stats.InFlight != 0 {
+		t.Errorf("Expected empty queue after completion, got %+v", stats)
+	}
+}
+
+func TestLimitedRate(t *testing.T) {
+	// 600 searches a minute is one every 100ms
+	limited := ndparser.NewLimited(ndparser.NewFake(ndparser.Class{CRN: "12345"}), 5, 600)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := limited.SearchClass(context.Background(), "", "12345"); err != nil {
+			t.Fatalf("SearchClass failed: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected 3 searches to take at least 200ms, took %v", elapsed)
+	}
+}
+
+func TestLimitedCancelWhileQueued(t *testing.T) {
+	source := &blockingSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345"}),
+		release: make(chan struct{}),
+	}
+	defer close(source.release)
+	limited := ndparser.NewLimited(source, 1, 0)
+
+	go limited.SearchClass(context.Background(), "", "12345")
+	for limited.Stats().InFlight != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := limited.SearchClass(ctx, "", "12345"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded while queued, got: %v", err)
+	}
+
+	if limited.Stats().Queued != 0 {
+		t.Errorf("Expected cancelled search to leave the queue, got %+v", limited.Stats())
+	}
+}
+
+func TestLimitedCancelGivesBackRate(t *testing.T) {
+	// 600 searches a minute is one every 100ms
+	limited := ndparser.NewLimited(ndparser.NewFake(ndparser.Class{CRN: "12345"}), 5, 600)
+
+	start := time.Now()
+	if _, err := limited.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+
+	// This search gives up while waiting for the slot at 100ms
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := limited.SearchClass(ctx, "", "12345"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected deadline exceeded, got: %v", err)
+	}
+
+	// So the next search takes its slot instead of waiting for the one at 200ms
+	if _, err := limited.SearchClass(context.Background(), "", "12345"); err != nil {
+		t.Fatalf("SearchClass failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 180*time.Millisecond {
+		t.Errorf("Expected the cancelled slot to be reused, took %v", elapsed)
+	}
+}
+
+func TestLimitedTermsDontWaitForSlot(t *testing.T) {
+	source := &blockingSource{
+		Fake:    ndparser.NewFake(ndparser.Class{CRN: "12345"}),
+		release: make(chan struct{}),
+	}
+	defer close(source.release)
+	limited := ndparser.NewLimited(source, 1, 0)
+
+	go limited.SearchClass(context.Background(), "", "12345")
+	for limited.Stats().InFlight != 1 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Commands look terms up while handling updates, so they don't queue behind searches
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := limited.CurrentTerm(ctx); err != nil {
+		t.Errorf("Expected CurrentTerm not to wait for a slot, got: %v", err)
+	}
+}
+
+func TestMeasuredRecordsOutcomes(t *testing.T) {
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"}, ndparser.Class{CRN: "23456", Title: "Broken Class"})
+	fake.SetError("23456", errors.New("registration site is down"))
+	source := ndparser.NewMeasured(fake, "measured_test")
+
+	source.SearchClass(context.Background(), "", "12345")
+	source.SearchClass(context.Background(), "", "12345")
+	source.SearchClass(context.Background(), "", "99999")
+	source.SearchClass(context.Background(), "", "23456")
+	source.SearchPage(context.Background(), "", ndparser.SectionQuery{Keyword: "test"}, 0, 10)
+
+	for series, want := range map[string]float64{
+		`ndclasses_parser_searches_total{backend="measured_test",operation="search_class",outcome="ok"}`:        2,
+		`ndclasses_parser_searches_total{backend="measured_test",operation="search_class",outcome="not_found"}`: 1,
+		`ndclasses_parser_searches_total{backend="measured_test",operation="search_class",outcome="error"}`:     1,
+		`ndclasses_parser_searches_total{backend="measured_test",operation="search_page",outcome="ok"}`:         1,
+		`ndclasses_parser_search_duration_seconds_count{backend="measured_test",operation="search_class"}`:      4,
+		`ndclasses_parser_search_duration_seconds_count{backend="measured_test",operation="search_page"}`:       1,
+	} {
+		if got := metricstest.Value(t, series); got != want {
+			t.Errorf("%s = %v, want %v", series, got, want)
+		}
+	}
+
+	// Missing sections are answers too; only the broken one failed
+	if rate, samples := source.SuccessRate(); rate != 0.8 || samples != 5 {
+		t.Errorf("Expected 4 of 5 searches to succeed, got %v of %d", rate, samples)
+	}
+}
+
+func TestMeasuredSuccessRateWindow(t *testing.T) {
+	fake := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"})
+	source := ndparser.NewMeasured(fake, "window_test")
+
+	if rate, samples := source.SuccessRate(); rate != 1 || samples != 0 {
+		t.Errorf("Expected 1 from no searches, got %v of %d", rate, samples)
+	}
+
+	// Old failures drop out as new searches come in
+	fake.SetError("12345", errors.New("registration site is down"))
+	for i := 0; i < 10; i++ {
+		source.SearchClass(context.Background(), "", "12345")
+	}
+	fake.SetError("12345", nil)
+	for i := 0; i < 100; i++ {
+		source.SearchClass(context.Background(), "", "12345")
+	}
+	if rate, samples := source.SuccessRate(); rate != 1 || samples != 50 {
+		t.Errorf("Expected only the latest 50 searches to count, got %v of %d", rate, samples)
+	}
+
+	// Cancelled searches aren't counted, and Limited reports the rate of the source it wraps
+	cancelled := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"})
+	cancelled.SetError("12345", context.Canceled)
+	limited := ndparser.NewLimited(ndparser.NewMeasured(cancelled, "cancel_test"), 1, 0)
+	limited.SearchClass(context.Background(), "", "12345")
+	if _, samples := limited.SuccessRate(); samples != 0 {
+		t.Errorf("Expected cancelled searches not to count, got %d", samples)
+	}
+}
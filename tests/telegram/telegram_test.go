@@ -1,14 +1,27 @@
 package telegram_test
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"NDClasses/clients/database"
+	"NDClasses/clients/logger"
+	"NDClasses/clients/ndparser"
 	"NDClasses/clients/telegram"
+	"NDClasses/tests/internal/metricstest"
 )
 
 func createTestClient(serverURL string) telegram.Client {
@@ -92,6 +105,44 @@ func TestUpdates(t *testing.T) {
 	}
 }
 
+func TestLastPoll(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":[]}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	if !client.LastPoll().IsZero() {
+		t.Errorf("Expected no successful poll yet, got %v", client.LastPoll())
+	}
+
+	// Failed polls aren't recorded
+	if _, err := client.Updates(0, 10); err == nil {
+		t.Fatal("Expected Updates to fail")
+	}
+	if !client.LastPoll().IsZero() {
+		t.Errorf("Expected a failed poll not to be recorded, got %v", client.LastPoll())
+	}
+
+	failing.Store(false)
+	if _, err := client.Updates(0, 10); err != nil {
+		t.Fatalf("Updates failed: %v", err)
+	}
+
+	// Copies of the client share when it last polled
+	copied := client
+	if time.Since(copied.LastPoll()) > time.Minute {
+		t.Errorf("Expected the poll to be recorded, got %v", copied.LastPoll())
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	// Mock response
 	mockResponse := map[string]interface{}{
@@ -148,3 +199,2067 @@ func TestUpdatesInvalidJSON(t *testing.T) {
 		t.Errorf("Expected JSON parsing error, got: %v", err)
 	}
 }
+
+// newTestProcessor creates a message processor backed by an in-memory database and a fake class source
+func newTestProcessor(t *testing.T, client *telegram.Client, source ndparser.ClassSource) *telegram.MessageProcessor {
+	return telegram.NewMessageProcessor(client, newTestDatabase(t), source, logger.New(false))
+}
+
+// newTestDatabase creates an in-memory store, so commands can be tested without a database
+func newTestDatabase(t *testing.T) database.Store {
+	db := database.NewMemory()
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestProcessCheckCommand(t *testing.T) {
+	messages := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messages <- r.URL.Query().Get("text")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	source := ndparser.NewFake(ndparser.Class{
+		CRN:          "12345",
+		Subject:      "CSE",
+		CourseNumber: "20311",
+		Section:      "01",
+		Title:        "Test Class",
+		Instructors:  []string{"Doe, John"},
+		Seats:        3,
+		Enrollment:   27,
+		Capacity:     30,
+	})
+	processor := newTestProcessor(t, &client, source)
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	// The first message acknowledges the command, the second one carries the result
+	<-messages
+	select {
+	case text := <-messages:
+		for _, want := range []string{"CSE 20311-01", "Test Class", "Doe, John", "Seats Available: 3 (27/30 enrolled)"} {
+			if !strings.Contains(text, want) {
+				t.Errorf("Expected check result to contain %q, got: %s", want, text)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for check result")
+	}
+
+	if source.Calls("12345") != 1 {
+		t.Errorf("Expected 1 search for 12345, got %d", source.Calls("12345"))
+	}
+}
+
+func TestLongRepliesSplitBetweenRunes(t *testing.T) {
+	messages := make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messages <- r.URL.Query().Get("text")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	// A title of two-byte runes makes one line far longer than a message, with nowhere to split it between lines;
+	// the leading letter puts the 4096th byte in the middle of a rune
+	title := "A" + strings.Repeat("é", 3000)
+	client := createTestClient(server.URL)
+	source := ndparser.NewFake(ndparser.Class{CRN: "12345", Title: title}, ndparser.Class{CRN: "67890", Title: "Other Class"})
+	processor := newTestProcessor(t, &client, source)
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345 67890"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	// Skip the acknowledgement, then collect the reply until the last class shows up
+	<-messages
+	var reply strings.Builder
+	for !strings.Contains(reply.String(), "Other Class") {
+		select {
+		case text := <-messages:
+			if !utf8.ValidString(text) {
+				t.Fatalf("Expected every message to be valid UTF-8, got a message of %d bytes that isn't", len(text))
+			}
+			if len(text) > 4096 {
+				t.Errorf("Expected messages of at most 4096 bytes, got %d", len(text))
+			}
+			reply.WriteString(text)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for the rest of the reply, got: %s", reply.String())
+		}
+	}
+
+	if !strings.Contains(reply.String(), title) {
+		t.Error("Expected the long title to arrive whole across messages")
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	polls := make(chan struct{}, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls <- struct{}{}
+		w.Write([]byte(`{"ok":true,"result":[]}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx, processor) }()
+
+	<-polls
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected Run to return nil after cancel, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+}
+
+func TestShutdownWaitsForCommands(t *testing.T) {
+	var mu sync.Mutex
+	var texts []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		texts = append(texts, r.URL.Query().Get("text"))
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"}))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := processor.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	// Both the acknowledgement and the result were sent before Shutdown returned
+	mu.Lock()
+	defer mu.Unlock()
+	if len(texts) != 2 {
+		t.Errorf("Expected 2 messages, got %d: %v", len(texts), texts)
+	}
+}
+
+func TestShutdownRejectsNewCommands(t *testing.T) {
+	var mu sync.Mutex
+	var texts []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		texts = append(texts, r.URL.Query().Get("text"))
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"}))
+
+	if err := processor.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	// Only the acknowledgement went out; the search never started
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(texts) != 1 {
+		t.Errorf("Expected 1 message, got %d: %v", len(texts), texts)
+	}
+}
+
+func TestSetWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/setWebhook") {
+			t.Errorf("Expected setWebhook request, got %s", r.URL.Path)
+		}
+
+		query := r.URL.Query()
+		if query.Get("url") != "https://example.com/hook" {
+			t.Errorf("Expected url 'https://example.com/hook', got '%s'", query.Get("url"))
+		}
+		if query.Get("secret_token") != "secret" {
+			t.Errorf("Expected secret_token 'secret', got '%s'", query.Get("secret_token"))
+		}
+
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+
+	if err := client.SetWebhook(context.Background(), "https://example.com/hook", "secret"); err != nil {
+		t.Fatalf("SetWebhook failed: %v", err)
+	}
+}
+
+func TestRunWebhookRetriesSetWebhook(t *testing.T) {
+	var calls atomic.Int32
+	set := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Telegram is out of reach twice before the webhook is set
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"ok":false,"error_code":502,"description":"Bad Gateway"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":true}`))
+		set <- struct{}{}
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+	config := telegram.WebhookConfig{URL: "https://example.com/hook", ListenAddr: "127.0.0.1:0", Path: "/hook", SecretToken: "secret"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.RunWebhook(ctx, processor, config) }()
+
+	select {
+	case <-set:
+	case err := <-done:
+		t.Fatalf("RunWebhook returned before the webhook was set: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the webhook to be set")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected RunWebhook to return nil after cancel, got: %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 setWebhook calls, got %d", n)
+	}
+}
+
+func TestRunWebhookStopsOnRejectedURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: bad webhook: HTTPS url must be provided for webhook"}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+	config := telegram.WebhookConfig{URL: "http://example.com/hook", ListenAddr: "127.0.0.1:0", Path: "/hook", SecretToken: "secret"}
+
+	done := make(chan error, 1)
+	go func() { done <- client.RunWebhook(context.Background(), processor, config) }()
+
+	select {
+	case err := <-done:
+		var apiErr *telegram.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+			t.Errorf("Expected the 400 error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("RunWebhook kept retrying a rejected URL")
+	}
+}
+
+func TestDeleteWebhookRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+
+	err := client.DeleteWebhook(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Errorf("Expected Unauthorized error, got: %v", err)
+	}
+}
+
+func TestWebhookHandler(t *testing.T) {
+	messages := make(chan string, 10)
+
+	telegramServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		messages <- r.URL.Query().Get("text")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer telegramServer.Close()
+
+	client := createTestClient(telegramServer.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	hook := httptest.NewServer(telegram.NewWebhookHandler(processor, "secret"))
+	defer hook.Close()
+
+	body, _ := json.Marshal(telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/help"}})
+
+	post := func(secret string) int {
+		req, _ := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(string(body)))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to post update: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Requests without the right secret never reach the processor
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without secret, got %d", code)
+	}
+	if code := post("wrong"); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with wrong secret, got %d", code)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Expected no messages for rejected updates, got %d", len(messages))
+	}
+
+	// Oversized bodies are refused before they're read in full
+	req, _ := http.NewRequest(http.MethodPost, hook.URL, strings.NewReader(`{"update_id":1,"message":{"text":"`+strings.Repeat("a", 2<<20)+`"}}`))
+	req.Header.Set("X-Telegram-Bot-Api-Secret-Token", "secret")
+	if resp, err := http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("Failed to post oversized update: %v", err)
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 for an oversized update, got %d", resp.StatusCode)
+		}
+	}
+
+	if code := post("secret"); code != http.StatusOK {
+		t.Errorf("Expected 200 with secret, got %d", code)
+	}
+
+	select {
+	case text := <-messages:
+		if !strings.Contains(text, "Available commands") {
+			t.Errorf("Expected help text, got: %s", text)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for reply")
+	}
+}
+
+func TestUpdatesAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+
+	_, err := client.Updates(0, 10)
+
+	var apiErr *telegram.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected *telegram.Error, got: %v", err)
+	}
+
+	if apiErr.Code != 429 {
+		t.Errorf("Expected error code 429, got %d", apiErr.Code)
+	}
+	if apiErr.RetryAfter != 7*time.Second {
+		t.Errorf("Expected retry after 7s, got %v", apiErr.RetryAfter)
+	}
+	if !strings.Contains(apiErr.Description, "Too Many Requests") {
+		t.Errorf("Unexpected description: %s", apiErr.Description)
+	}
+	if !apiErr.Temporary() {
+		t.Error("Expected 429 to be temporary")
+	}
+}
+
+// flakyTelegram is a fake Bot API whose getUpdates fails a few times before delivering an update
+type flakyTelegram struct {
+	mu       sync.Mutex
+	failures []func(w http.ResponseWriter)
+	polls    int
+	timeouts []string
+	sent     chan string
+}
+
+func (f *flakyTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+		f.mu.Lock()
+		f.polls++
+		f.timeouts = append(f.timeouts, r.URL.Query().Get("timeout"))
+		var fail func(w http.ResponseWriter)
+		if len(f.failures) > 0 {
+			fail, f.failures = f.failures[0], f.failures[1:]
+		}
+		first := r.URL.Query().Get("offset") == "0"
+		f.mu.Unlock()
+
+		if fail != nil {
+			fail(w)
+			return
+		}
+		if first {
+			w.Write([]byte(`{"ok":true,"result":[{"update_id":5,"message":{"message_id":1,"chat":{"id":456},"text":"/help"}}]}`))
+			return
+		}
+		// Nothing new; a real server would hold the request for the long-poll timeout
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(`{"ok":true,"result":[]}`))
+	case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+		f.sent <- r.URL.Query().Get("text")
+		w.Write([]byte(`{"ok":true}`))
+	default:
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}
+}
+
+func TestRunRecoversFromFailures(t *testing.T) {
+	fake := &flakyTelegram{
+		sent: make(chan string, 10),
+		failures: []func(w http.ResponseWriter){
+			// Gateway error with an HTML body
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte("<html>Bad Gateway</html>"))
+			},
+			// Telegram error payload
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+			},
+			// Dropped connection
+			func(w http.ResponseWriter) {
+				panic(http.ErrAbortHandler)
+			},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Run(ctx, processor) }()
+
+	select {
+	case text := <-fake.sent:
+		if !strings.Contains(text, "Available commands") {
+			t.Errorf("Expected help text, got: %s", text)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the update to be processed after failures")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected Run to return nil after cancel, got: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.polls < 4 {
+		t.Errorf("Expected at least 4 polls, got %d", fake.polls)
+	}
+	for _, timeout := range fake.timeouts {
+		if timeout != "30" {
+			t.Errorf("Expected long polling with timeout=30, got '%s'", timeout)
+		}
+	}
+}
+
+func TestRunHonorsRetryAfter(t *testing.T) {
+	fake := &flakyTelegram{
+		sent: make(chan string, 10),
+		failures: []func(w http.ResponseWriter){
+			func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+			},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	go client.Run(ctx, processor)
+
+	select {
+	case <-fake.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the update to be processed")
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait retry_after of 1s, waited %v", elapsed)
+	}
+}
+
+func TestRunDoesNotHoldUpOtherChats(t *testing.T) {
+	sent := make(chan string, 10)
+	var polled atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+			if polled.CompareAndSwap(false, true) {
+				w.Write([]byte(`{"ok":true,"result":[` +
+					`{"update_id":1,"message":{"message_id":1,"chat":{"id":401},"text":"/start"}},` +
+					`{"update_id":2,"message":{"message_id":2,"chat":{"id":401},"text":"/help"}},` +
+					`{"update_id":3,"message":{"message_id":3,"chat":{"id":402},"text":"/help"}}]}`))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			w.Write([]byte(`{"ok":true,"result":[]}`))
+		case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+			sent <- r.URL.Query().Get("chat_id")
+			w.Write([]byte(`{"ok":true}`))
+		default:
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx, processor)
+
+	// The second reply to chat 401 waits a second for the per-chat limit; chat 402 is answered meanwhile
+	var order []string
+	for range 3 {
+		select {
+		case chat := <-sent:
+			order = append(order, chat)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for replies, got: %v", order)
+		}
+	}
+	if order[2] != "401" {
+		t.Errorf("Expected chat 402 to be answered before the second reply to chat 401, got: %v", order)
+	}
+}
+
+func TestRunStopsOnInvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	done := make(chan error, 1)
+	go func() { done <- client.Run(context.Background(), processor) }()
+
+	select {
+	case err := <-done:
+		var apiErr *telegram.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != 401 {
+			t.Errorf("Expected 401 error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run kept retrying with an invalid token")
+	}
+}
+
+// sendLog is a fake sendMessage endpoint that answers with scripted responses and records request times
+type sendLog struct {
+	mu        sync.Mutex
+	responses []func(w http.ResponseWriter)
+	times     map[string][]time.Time
+}
+
+func (s *sendLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	if s.times == nil {
+		s.times = make(map[string][]time.Time)
+	}
+	chat := r.URL.Query().Get("chat_id")
+	s.times[chat] = append(s.times[chat], time.Now())
+	var respond func(w http.ResponseWriter)
+	if len(s.responses) > 0 {
+		respond, s.responses = s.responses[0], s.responses[1:]
+	}
+	s.mu.Unlock()
+
+	if respond != nil {
+		respond(w)
+		return
+	}
+	w.Write([]byte(`{"ok":true,"result":{"message_id":1,"chat":{"id":` + chat + `}}}`))
+}
+
+func (s *sendLog) requests(chat string) []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.times[chat]
+}
+
+func TestSendMessagePerChatLimit(t *testing.T) {
+	fake := &sendLog{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+
+	// Two messages to one chat and one to another, all at once
+	var wg sync.WaitGroup
+	for _, chatID := range []int64{1, 1, 2} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.SendMessage(chatID, "hello"); err != nil {
+				t.Errorf("SendMessage failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	same := fake.requests("1")
+	if len(same) != 2 {
+		t.Fatalf("Expected 2 messages to chat 1, got %d", len(same))
+	}
+	if gap := same[1].Sub(same[0]); gap < 900*time.Millisecond {
+		t.Errorf("Expected messages to one chat to be a second apart, got %v", gap)
+	}
+
+	other := fake.requests("2")
+	if len(other) != 1 || other[0].Sub(same[0]) > 500*time.Millisecond {
+		t.Errorf("Expected the other chat not to wait, got %v", other)
+	}
+
+	if stats := client.Outbox().Stats(); stats.Sent != 3 || stats.Failed != 0 || stats.Queued != 0 {
+		t.Errorf("Unexpected outbox stats: %+v", stats)
+	}
+}
+
+func TestSendMessageHonorsRetryAfter(t *testing.T) {
+	fake := &sendLog{responses: []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+		},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	msg, err := client.SendMessageContext(context.Background(), 456, "hello")
+	if err != nil {
+		t.Fatalf("Expected the message to be delivered after retry_after, got: %v", err)
+	}
+	if msg.Chat.ID != 456 {
+		t.Errorf("Expected the sent message to be returned, got %+v", msg)
+	}
+
+	times := fake.requests("456")
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(times))
+	}
+	if gap := times[1].Sub(times[0]); gap < 900*time.Millisecond {
+		t.Errorf("Expected retry to wait for retry_after, waited %v", gap)
+	}
+}
+
+func TestSendMessageRetriesTransientFailures(t *testing.T) {
+	fake := &sendLog{responses: []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>Bad Gateway</html>"))
+		},
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"ok":false,"error_code":500,"description":"Internal Server Error"}`))
+		},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	if err := client.SendMessage(456, "hello"); err != nil {
+		t.Fatalf("Expected the message to be delivered eventually, got: %v", err)
+	}
+
+	if n := len(fake.requests("456")); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func TestSendMessagePermanentFailure(t *testing.T) {
+	fake := &sendLog{responses: []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+		},
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	err := client.SendMessage(456, "hello")
+
+	var apiErr *telegram.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != 403 {
+		t.Fatalf("Expected a 403 error, got: %v", err)
+	}
+	if n := len(fake.requests("456")); n != 1 {
+		t.Errorf("Expected a blocked chat not to be retried, got %d attempts", n)
+	}
+	if stats := client.Outbox().Stats(); stats.Failed != 1 {
+		t.Errorf("Expected 1 failed message, got %+v", stats)
+	}
+}
+
+func TestSendMessageNotRetriedAfterLostResponse(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+
+		// Telegram got the message, but the connection drops before it answers
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	client.SetBackoff(time.Millisecond, 10*time.Millisecond)
+
+	if err := client.SendMessage(456, "hello"); err == nil {
+		t.Fatal("Expected the lost response to fail the send")
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Errorf("Expected a message that may have been delivered not to be sent again, got %d attempts", n)
+	}
+}
+
+// botRequest is a Bot API request received by a fake server
+type botRequest struct {
+	method string
+	query  url.Values
+}
+
+// newRecordingServer creates a fake Bot API that records every request
+func newRecordingServer(requests chan botRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		requests <- botRequest{method: method, query: r.URL.Query()}
+		if method == "sendMessage" {
+			w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+}
+
+func TestListShowsButtons(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	user, _ := db.CreateUser(456, "")
+	tracked, _ := db.AddTrackedCRN(user.ID, "12345", ndparser.FakeTerm.Code, "Test Class")
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/list"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	req := <-requests
+	var keyboard telegram.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(req.query.Get("reply_markup")), &keyboard); err != nil {
+		t.Fatalf("Expected an inline keyboard, got %q: %v", req.query.Get("reply_markup"), err)
+	}
+
+	if len(keyboard.InlineKeyboard) != 1 || len(keyboard.InlineKeyboard[0]) != 2 {
+		t.Fatalf("Expected one row of two buttons, got %+v", keyboard)
+	}
+	check, untrack := keyboard.InlineKeyboard[0][0], keyboard.InlineKeyboard[0][1]
+	if !strings.Contains(check.Text, "Check now") || !strings.Contains(untrack.Text, "Stop tracking") {
+		t.Errorf("Unexpected buttons: %+v", keyboard.InlineKeyboard[0])
+	}
+	if want := fmt.Sprintf("untrack:%d", tracked.ID); untrack.CallbackData != want {
+		t.Errorf("Expected callback data %q, got %q", want, untrack.CallbackData)
+	}
+}
+
+func TestCallbackStopTracking(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	user, _ := db.CreateUser(456, "")
+	tracked, _ := db.AddTrackedCRN(user.ID, "12345", ndparser.FakeTerm.Code, "Test Class")
+
+	update := telegram.Update{ID: 1, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb1",
+		Message: &telegram.Message{Chat: telegram.Chat{ID: 456}},
+		Data:    fmt.Sprintf("untrack:%d", tracked.ID),
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	answer := <-requests
+	if answer.method != "answerCallbackQuery" || answer.query.Get("callback_query_id") != "cb1" {
+		t.Errorf("Expected the callback query to be answered, got %s %v", answer.method, answer.query)
+	}
+	if reply := <-requests; !strings.Contains(reply.query.Get("text"), "Removed CRN 12345") {
+		t.Errorf("Unexpected reply: %v", reply.query)
+	}
+
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 0 {
+		t.Errorf("Expected the class to be untracked, got %+v", crns)
+	}
+}
+
+func TestCallbackSnooze(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	owner, _ := db.CreateUser(456, "")
+	tracked, _ := db.AddTrackedCRN(owner.ID, "12345", ndparser.FakeTerm.Code, "Test Class")
+	db.CreateUser(789, "")
+
+	// Someone else's button must not touch the owner's class
+	update := telegram.Update{ID: 1, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb1",
+		Message: &telegram.Message{Chat: telegram.Chat{ID: 789}},
+		Data:    fmt.Sprintf("snooze:%d", tracked.ID),
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if answer := <-requests; !strings.Contains(answer.query.Get("text"), "not tracking") {
+		t.Errorf("Expected a stranger's snooze to be refused, got %v", answer.query)
+	}
+
+	update.CallbackQuery.Message.Chat.ID = 456
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if answer := <-requests; !strings.Contains(answer.query.Get("text"), "snoozed") {
+		t.Errorf("Expected a snooze confirmation, got %v", answer.query)
+	}
+
+	snoozed, _ := db.GetTrackedCRN(owner.ID, tracked.ID)
+	if snoozed.SnoozedUntil < time.Now().Add(telegram.SnoozeDuration-time.Minute).Unix() {
+		t.Errorf("Expected alerts to be snoozed for %v, got until %d", telegram.SnoozeDuration, snoozed.SnoozedUntil)
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	router := telegram.NewRouter()
+	router.Handle(telegram.Command{Name: "add", Aliases: []string{"track"}, Usage: "CRN [term]"})
+	router.Handle(telegram.Command{Name: "check", Usage: "CRN"})
+	router.SetBotName("NDClassesBot")
+
+	tests := []struct {
+		text string
+		name string
+		args []string
+	}{
+		{"/add 12345", "add", []string{"12345"}},
+		{"/ADD@ndclassesbot 12345 Spring 2026", "add", []string{"12345", "Spring", "2026"}},
+		{"/Track   12345", "add", []string{"12345"}},
+		{"/check_12345", "check", []string{"12345"}},
+		{"/check_12345@NDClassesBot", "check", []string{"12345"}},
+	}
+
+	for _, tt := range tests {
+		req, ok := router.Route(456, tt.text)
+		if !ok || req.Command == nil {
+			t.Errorf("%q: expected a command, got %+v", tt.text, req)
+			continue
+		}
+		if req.Command.Name != tt.name {
+			t.Errorf("%q: expected /%s, got /%s", tt.text, tt.name, req.Command.Name)
+		}
+		if strings.Join(req.Args, ",") != strings.Join(tt.args, ",") {
+			t.Errorf("%q: expected args %v, got %v", tt.text, tt.args, req.Args)
+		}
+	}
+
+	if _, ok := router.Route(456, "/add@OtherBot 12345"); ok {
+		t.Error("Expected a command for another bot to be ignored")
+	}
+	if req, ok := router.Route(456, "/unknown"); !ok || req.Command != nil {
+		t.Errorf("Expected an unknown command to be routed without a handler, got %+v", req)
+	}
+}
+
+func TestCommandUsageAndHelp(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/add"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := (<-requests).query.Get("text"); text != "Usage: /add CRN... [term]" {
+		t.Errorf("Expected a usage hint, got: %s", text)
+	}
+
+	update.Message.Text = "/Help"
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	text := (<-requests).query.Get("text")
+	for _, want := range []string{"/add CRN... [term] - Add classes to track", "/list - List all tracked classes"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected help to contain %q, got: %s", want, text)
+		}
+	}
+}
+
+func TestCommandReportsUserLoadFailure(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	// Without a database the user can't be loaded; the command must not run with a nil user
+	db.Close()
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/list"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := (<-requests).query.Get("text"); !strings.Contains(text, "something went wrong") {
+		t.Errorf("Expected an apology, got: %s", text)
+	}
+}
+
+func TestUnknownCommandDoesNotCreateUser(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/garbage"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextRequest(t, requests).query.Get("text"); !strings.Contains(text, "Unknown command") {
+		t.Errorf("Expected unknown command reply, got: %s", text)
+	}
+	if users, _ := db.GetAllUsers(); len(users) != 0 {
+		t.Errorf("Expected no user to be created, got %+v", users)
+	}
+}
+
+// panickingSource panics on every search, standing in for a bug in a command
+type panickingSource struct {
+	*ndparser.Fake
+}
+
+func (s panickingSource) SearchClass(ctx context.Context, term string, crn string) (*ndparser.Class, error) {
+	panic("search exploded")
+}
+
+func TestBackgroundCommandPanicIsReported(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, panickingSource{ndparser.NewFake()})
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	// The acknowledgement, then an apology instead of the bot going down
+	nextRequest(t, requests)
+	if text := nextRequest(t, requests).query.Get("text"); !strings.Contains(text, "something went wrong") {
+		t.Errorf("Expected an apology, got: %s", text)
+	}
+}
+
+// panickingStore panics when a button looks up its user
+type panickingStore struct {
+	database.Store
+}
+
+func (s panickingStore) GetUserByTelegramID(telegramID int64) (*database.User, error) {
+	panic("store exploded")
+}
+
+func TestButtonPanicIsReported(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := telegram.NewMessageProcessor(&client, panickingStore{newTestDatabase(t)}, ndparser.NewFake(), logger.New(false))
+
+	update := telegram.Update{ID: 1, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb1",
+		Message: &telegram.Message{MessageID: 1, Chat: telegram.Chat{ID: 456}},
+		Data:    "check:1",
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextRequest(t, requests).query.Get("text"); !strings.Contains(text, "something went wrong") {
+		t.Errorf("Expected an apology, got: %s", text)
+	}
+}
+
+func TestSyncCommands(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		requests <- botRequest{method: method, query: r.URL.Query()}
+		switch method {
+		case "getMe":
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"username":"NDClassesBot"}}`))
+		case "sendMessage":
+			w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+		default:
+			w.Write([]byte(`{"ok":true,"result":true}`))
+		}
+	}))
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	if err := processor.SyncCommands(context.Background()); err != nil {
+		t.Fatalf("SyncCommands failed: %v", err)
+	}
+
+	<-requests // getMe
+	set := <-requests
+	if set.method != "setMyCommands" {
+		t.Fatalf("Expected setMyCommands, got %s", set.method)
+	}
+	var commands []telegram.BotCommand
+	if err := json.Unmarshal([]byte(set.query.Get("commands")), &commands); err != nil {
+		t.Fatalf("Failed to parse commands: %v", err)
+	}
+	if len(commands) == 0 || commands[0].Command != "start" {
+		t.Errorf("Unexpected commands: %+v", commands)
+	}
+
+	// Now that the bot knows its name, commands for other bots are ignored
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/help@OtherBot"}}
+	processor.ProcessUpdate(update)
+	update.Message.Text = "/help@NDClassesBot"
+	processor.ProcessUpdate(update)
+
+	if reply := <-requests; !strings.Contains(reply.query.Get("text"), "Available commands") {
+		t.Errorf("Expected only the help addressed to this bot to be answered, got: %v", reply.query)
+	}
+}
+
+// nextReply waits for the next message sent through a recording server
+func nextReply(t *testing.T, requests chan botRequest) string {
+	t.Helper()
+
+	select {
+	case req := <-requests:
+		return req.query.Get("text")
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for reply")
+		return ""
+	}
+}
+
+// courseSections are the sections of two courses known to the fake source in the tests below
+var courseSections = []ndparser.Class{
+	{CRN: "11111", Subject: "CSE", CourseNumber: "20311", Section: "01", Title: "Fundamentals of Computing", Seats: 0, Capacity: 30, Enrollment: 30},
+	{CRN: "22222", Subject: "CSE", CourseNumber: "20311", Section: "02", Title: "Fundamentals of Computing", Seats: 4, Capacity: 30, Enrollment: 26},
+	{CRN: "33333", Subject: "MATH", CourseNumber: "10550", Section: "01", Title: "Calculus I", Seats: 1},
+}
+
+func TestAddMultipleCRNs(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(courseSections...), logger.New(false))
+
+	user, _ := db.CreateUser(456, "")
+	db.AddTrackedCRN(user.ID, "33333", ndparser.FakeTerm.Code, "Calculus I")
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/add 11111, 33333 99999"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	text := nextReply(t, requests)
+	for _, want := range []string{"Added to your tracking list (Fall Semester 2025):\n- 11111 CSE 20311-01", "Already tracking:\n- 33333", "Not found in Fall Semester 2025:\n- 99999"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected reply to contain %q, got: %s", want, text)
+		}
+	}
+
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 2 {
+		t.Errorf("Expected 2 tracked CRNs, got %d", len(crns))
+	}
+}
+
+func TestAddSeesLegacyRowInCurrentTerm(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(courseSections...), logger.New(false))
+
+	// Tracked before terms were stored, so the row's term is empty and means the current one
+	user, _ := db.CreateUser(456, "")
+	db.AddTrackedCRN(user.ID, "33333", "", "Calculus I")
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/add 33333 " + ndparser.FakeTerm.Code}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	if text := nextReply(t, requests); !strings.Contains(text, "Already tracking:\n- 33333") {
+		t.Errorf("Expected the legacy row to count as tracked, got: %s", text)
+	}
+	if crns, _ := db.GetUserTrackedCRNs(user.ID); len(crns) != 1 {
+		t.Errorf("Expected the section to be tracked once, got %+v", crns)
+	}
+}
+
+func TestAddCourseExpandsToSections(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(courseSections...), logger.New(false))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/add cse20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	text := nextReply(t, requests)
+	if !strings.Contains(text, "11111") || !strings.Contains(text, "22222") || strings.Contains(text, "33333") {
+		t.Errorf("Expected both CSE 20311 sections to be added, got: %s", text)
+	}
+
+	user, _ := db.GetUserByTelegramID(456)
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 2 {
+		t.Errorf("Expected 2 tracked CRNs, got %d", len(crns))
+	}
+}
+
+func TestAddRejectsInvalidCRNs(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	source := ndparser.NewFake(courseSections...)
+	processor := newTestProcessor(t, &client, source)
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/add 11111 1234"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	if text := nextReply(t, requests); !strings.Contains(text, "Invalid CRN(s): 1234") {
+		t.Errorf("Expected the invalid CRN to be reported, got: %s", text)
+	}
+	if source.Calls("11111") != 0 {
+		t.Error("Expected nothing to be searched when the input is invalid")
+	}
+}
+
+func TestCheckCourse(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake(courseSections...))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check CSE 20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	nextReply(t, requests) // Checking...
+	text := nextReply(t, requests)
+	for _, want := range []string{
+		"11111 CSE 20311-01 Fundamentals of Computing: 0 seat(s) (30/30 enrolled)",
+		"22222 CSE 20311-02 Fundamentals of Computing: 4 seat(s) (26/30 enrolled)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected reply to contain %q, got: %s", want, text)
+		}
+	}
+}
+
+func TestRemoveCourse(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(courseSections...), logger.New(false))
+
+	user, _ := db.CreateUser(456, "")
+	for _, class := range courseSections {
+		db.AddTrackedCRN(user.ID, class.CRN, ndparser.FakeTerm.Code, class.Title)
+	}
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/remove CSE 20311 99999"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	text := nextReply(t, requests)
+	if !strings.Contains(text, "- 11111") || !strings.Contains(text, "- 22222") || !strings.Contains(text, "You are not tracking:\n- 99999") {
+		t.Errorf("Unexpected reply: %s", text)
+	}
+
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 1 || crns[0].CRN != "33333" {
+		t.Errorf("Expected only 33333 to remain tracked, got %+v", crns)
+	}
+}
+
+// downSource can't resolve the current term or search courses, as when the registration site is down
+type downSource struct {
+	*ndparser.Fake
+}
+
+func (s downSource) CurrentTerm(ctx context.Context) (ndparser.Term, error) {
+	return ndparser.Term{}, errors.New("registration site is down")
+}
+
+func (s downSource) SearchSections(ctx context.Context, term string, query ndparser.SectionQuery) ([]ndparser.Class, error) {
+	return nil, errors.New("registration site is down")
+}
+
+func TestRemoveWithoutCurrentTerm(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, downSource{ndparser.NewFake(courseSections...)}, logger.New(false))
+
+	// 11111 was tracked before terms were stored
+	user, _ := db.CreateUser(456, "")
+	db.AddTrackedCRN(user.ID, "11111", "", "Fundamentals of Computing")
+	db.AddTrackedCRN(user.ID, "33333", ndparser.FakeTerm.Code, "Calculus I")
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/remove 11111 CSE 20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	// The CRN needs no term; the course can't be searched, so it's reported as failed only
+	text := nextReply(t, requests)
+	if !strings.Contains(text, "Removed from your tracking list:\n- 11111") || !strings.Contains(text, "Failed:\n- CSE 20311") {
+		t.Errorf("Unexpected reply: %s", text)
+	}
+	if strings.Contains(text, "You are not tracking") || strings.Count(text, "CSE 20311") != 1 {
+		t.Errorf("Expected the failed course to be listed once, got: %s", text)
+	}
+
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 1 || crns[0].CRN != "33333" {
+		t.Errorf("Expected only 33333 to remain tracked, got %+v", crns)
+	}
+}
+
+// nextRequest waits for the next request to a recording server
+func nextRequest(t *testing.T, requests chan botRequest) botRequest {
+	t.Helper()
+
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for request")
+		return botRequest{}
+	}
+}
+
+func TestSearchTrackSeesLegacyRow(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(ndparser.Class{
+		CRN: "10001", Subject: "CSE", CourseNumber: "20311", Section: "01", Title: "Fundamentals of Computing",
+	}), logger.New(false))
+
+	// Tracked before terms were stored, so the row's term is empty
+	user, _ := db.CreateUser(456, "")
+	db.AddTrackedCRN(user.ID, "10001", "", "Fundamentals of Computing")
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/search cse 20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	nextRequest(t, requests)
+	time.Sleep(50 * time.Millisecond)
+
+	update = telegram.Update{ID: 2, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb1",
+		Message: &telegram.Message{MessageID: 1, Chat: telegram.Chat{ID: 456}},
+		Data:    "track:" + ndparser.FakeTerm.Code + ":10001",
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextRequest(t, requests).query.Get("text"); !strings.Contains(text, "already tracking") {
+		t.Errorf("Expected the legacy row to count as tracked, got: %s", text)
+	}
+	if crns, _ := db.GetUserTrackedCRNs(user.ID); len(crns) != 1 {
+		t.Errorf("Expected the section to be tracked once, got %+v", crns)
+	}
+}
+
+func TestSearchPagesAndTracks(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	var sections []ndparser.Class
+	for i := 1; i <= 7; i++ {
+		sections = append(sections, ndparser.Class{
+			CRN:          fmt.Sprintf("1000%d", i),
+			Subject:      "CSE",
+			CourseNumber: "20311",
+			Section:      fmt.Sprintf("0%d", i),
+			Title:        "Fundamentals of Computing",
+			Instructors:  []string{"Doe, John"},
+			Seats:        i,
+		})
+	}
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(sections...), logger.New(false))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/search cse 20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	first := nextRequest(t, requests)
+	text := first.query.Get("text")
+
+	// Let the search finish remembering the results before the buttons are pressed
+	time.Sleep(50 * time.Millisecond)
+	for _, want := range []string{"Results for CSE 20311 in Fall Semester 2025 (1-5 of 7)", "10001 CSE 20311-01", "Instructor: Doe, John"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected results to contain %q, got: %s", want, text)
+		}
+	}
+	if strings.Contains(text, "10006") {
+		t.Errorf("Expected the second page to be left out, got: %s", text)
+	}
+
+	var keyboard telegram.InlineKeyboardMarkup
+	json.Unmarshal([]byte(first.query.Get("reply_markup")), &keyboard)
+	if len(keyboard.InlineKeyboard) != 6 {
+		t.Fatalf("Expected 5 track buttons and a page row, got %+v", keyboard)
+	}
+	next := keyboard.InlineKeyboard[5][0]
+	if next.CallbackData != "page:5" {
+		t.Errorf("Expected a next page button, got %+v", next)
+	}
+
+	// Turn the page
+	update = telegram.Update{ID: 2, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb1",
+		Message: &telegram.Message{MessageID: 1, Chat: telegram.Chat{ID: 456}},
+		Data:    next.CallbackData,
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if req := nextRequest(t, requests); req.method != "answerCallbackQuery" {
+		t.Errorf("Expected the button to be answered, got %s", req.method)
+	}
+	edit := nextRequest(t, requests)
+	if edit.method != "editMessageText" || !strings.Contains(edit.query.Get("text"), "(6-7 of 7)") {
+		t.Errorf("Expected the results to be replaced by the second page, got %s: %s", edit.method, edit.query.Get("text"))
+	}
+
+	// Track a section from the second page
+	update = telegram.Update{ID: 3, CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb2",
+		Message: &telegram.Message{MessageID: 1, Chat: telegram.Chat{ID: 456}},
+		Data:    "track:" + ndparser.FakeTerm.Code + ":10006",
+	}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if answer := nextRequest(t, requests); !strings.Contains(answer.query.Get("text"), "Added CRN 10006") {
+		t.Errorf("Expected a confirmation, got: %v", answer.query)
+	}
+
+	user, _ := db.GetUserByTelegramID(456)
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 1 || crns[0].CRN != "10006" || crns[0].Term != ndparser.FakeTerm.Code {
+		t.Errorf("Expected 10006 to be tracked, got %+v", crns)
+	}
+}
+
+func TestSearchButtonsPressedConcurrently(t *testing.T) {
+	requests := make(chan botRequest, 100)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	var sections []ndparser.Class
+	for i := 1; i <= 7; i++ {
+		sections = append(sections, ndparser.Class{CRN: fmt.Sprintf("1000%d", i), Subject: "CSE", CourseNumber: "20311", Title: "Fundamentals of Computing"})
+	}
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(sections...), logger.New(false))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/search cse 20311"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	nextRequest(t, requests)
+	time.Sleep(50 * time.Millisecond)
+
+	// Page turns refresh the stored results while other presses read them; run with -race
+	var wg sync.WaitGroup
+	for i := range 20 {
+		data := "page:5"
+		switch {
+		case i == 0:
+			data = "track:" + ndparser.FakeTerm.Code + ":10001"
+		case i%2 == 1:
+			data = "page:0"
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			update := telegram.Update{ID: 2 + i, CallbackQuery: &telegram.CallbackQuery{
+				ID:      fmt.Sprintf("cb%d", i),
+				Message: &telegram.Message{MessageID: 1, Chat: telegram.Chat{ID: 456}},
+				Data:    data,
+			}}
+			if err := processor.ProcessUpdate(update); err != nil {
+				t.Errorf("ProcessUpdate failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := processor.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	user, _ := db.GetUserByTelegramID(456)
+	crns, _ := db.GetUserTrackedCRNs(user.ID)
+	if len(crns) != 1 || crns[0].CRN != "10001" {
+		t.Errorf("Expected 10001 to be tracked, got %+v", crns)
+	}
+}
+
+func TestSearchByKeyword(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake(courseSections...))
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/search calculus"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	text := nextReply(t, requests)
+	if !strings.Contains(text, "33333 MATH 10550-01 Calculus I") || strings.Contains(text, "11111") {
+		t.Errorf("Expected only the calculus section, got: %s", text)
+	}
+
+	update.Message.Text = "/search ab"
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextReply(t, requests); !strings.Contains(text, "at least 3 characters") {
+		t.Errorf("Expected a hint for a short keyword, got: %s", text)
+	}
+}
+
+func TestHistorySummary(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	// Two days of checks every 4 hours: the section opens twice, for 4 and 12 hours
+	start := time.Now().Add(-48 * time.Hour).Unix()
+	for i, seats := range []int{0, 1, 0, 0, 2, 3, 1, 0, 0, 0, 0, 0, 0} {
+		obs := &database.SectionObservation{Term: "202510", CRN: "12345", Success: true, Seats: seats, ObservedAt: start + int64(i)*4*3600}
+		if err := db.RecordObservation(obs); err != nil {
+			t.Fatalf("Failed to record observation: %v", err)
+		}
+	}
+	db.RecordObservation(&database.SectionObservation{Term: "202510", CRN: "12345", Error: "timeout", ObservedAt: start + 49*3600})
+
+	// Checks from before the window /history looks at are left out
+	db.RecordObservation(&database.SectionObservation{Term: "202510", CRN: "12345", Success: true, Seats: 5, ObservedAt: time.Now().Add(-60 * 24 * time.Hour).Unix()})
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/history 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	text := nextReply(t, requests)
+	for _, want := range []string{
+		"Seat history for CRN 12345 in Fall Semester 2025",
+		"Checked 14 times",
+		"(92% successful)",
+		"Currently: full",
+		"Openings: 2 (1.0 per day)",
+		"8h on average, 12h at most",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected history to contain %q, got: %s", want, text)
+		}
+	}
+
+	update = telegram.Update{ID: 2, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/history 67890"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	if text := nextReply(t, requests); !strings.Contains(text, "No history for CRN 67890") {
+		t.Errorf("Expected no history for an unchecked CRN, got: %s", text)
+	}
+}
+
+func TestErrorRepliesHideURLs(t *testing.T) {
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	// Errors from the registration site quote the request URL, session ID included
+	source := ndparser.NewFake()
+	source.SetError("12345", fmt.Errorf("can't search class: %w", &url.Error{
+		Op:  "Get",
+		URL: "https://registration.nd.edu/searchResults?txt_term=202510&uniqueSessionId=abc123secret",
+		Err: errors.New("connection reset by peer"),
+	}))
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, source)
+
+	update := telegram.Update{ID: 1, Message: telegram.Message{Chat: telegram.Chat{ID: 456}, Text: "/check 12345"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+
+	nextReply(t, requests)
+	text := nextReply(t, requests)
+	if strings.Contains(text, "abc123secret") || strings.Contains(text, "txt_term") {
+		t.Errorf("Expected the reply to leave out the URL's query, got: %s", text)
+	}
+	if !strings.Contains(text, "connection reset by peer") {
+		t.Errorf("Expected the reply to still say what went wrong, got: %s", text)
+	}
+}
+
+func TestErrorsHideToken(t *testing.T) {
+	const token = "987654321:secret-token-for-redaction-tests"
+	const secret = "webhook-secret-for-redaction-tests"
+
+	// Nothing listens on the server's address once it's closed, so every request fails to connect
+	server := httptest.NewServer(http.NotFoundHandler())
+	host := "http://" + strings.TrimPrefix(server.URL, "http://")
+	server.Close()
+
+	client := telegram.New(host, token)
+	ctx := context.Background()
+
+	_, getMeErr := client.GetMe(ctx)
+	_, updatesErr := client.Updates(0, 1)
+	webhookErr := client.SetWebhook(ctx, "https://example.com/hook", secret)
+
+	for _, err := range []error{getMeErr, updatesErr, webhookErr} {
+		if err == nil {
+			t.Fatal("Expected an error for an unreachable server, but got none")
+		}
+		if strings.Contains(err.Error(), token) || strings.Contains(err.Error(), secret) {
+			t.Errorf("Expected error to hide the token and webhook secret, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), logger.Redacted) {
+			t.Errorf("Expected error to show where the token was, got: %v", err)
+		}
+	}
+
+	// Log output masks the token too, wherever it comes from
+	var stdout bytes.Buffer
+	l := logger.NewWithConfig(logger.Config{Level: slog.LevelInfo, Stdout: &stdout, Stderr: &stdout})
+	l.With("url", host+"/bot"+token+"/getMe").Warn("Request failed: %v, token %s", getMeErr, token)
+	if strings.Contains(stdout.String(), token) {
+		t.Errorf("Expected log output to hide the token, got: %s", stdout.String())
+	}
+}
+
+func TestErrorsHideTokenOnTimeout(t *testing.T) {
+	const token = "987654321:secret-token-for-redaction-tests"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := telegram.New("http://"+strings.TrimPrefix(server.URL, "http://"), token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.GetMe(ctx)
+	if err == nil {
+		t.Fatal("Expected a timeout, but got none")
+	}
+	if strings.Contains(err.Error(), token) {
+		t.Errorf("Expected error to hide the token, got: %v", err)
+	}
+	// The cause is still there for callers that check it
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestMessageMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chat_id") == "666" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	}))
+	defer server.Close()
+
+	const (
+		sentSeries   = `ndclasses_telegram_messages_total{outcome="sent"}`
+		failedSeries = `ndclasses_telegram_messages_total{outcome="failed"}`
+		errorSeries  = `ndclasses_telegram_api_errors_total{method="sendMessage",code="403"}`
+	)
+	sent, failed, apiErrors := metricstest.Value(t, sentSeries), metricstest.Value(t, failedSeries), metricstest.Value(t, errorSeries)
+
+	client := createTestClient(server.URL)
+	if _, err := client.SendMessageContext(context.Background(), 42, "hello"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if _, err := client.SendMessageContext(context.Background(), 666, "hello"); err == nil {
+		t.Fatal("Expected an error for a blocked chat, but got none")
+	}
+
+	if got := metricstest.Value(t, sentSeries); got != sent+1 {
+		t.Errorf("Expected one more sent message, got %v (was %v)", got, sent)
+	}
+	if got := metricstest.Value(t, failedSeries); got != failed+1 {
+		t.Errorf("Expected one more failed message, got %v (was %v)", got, failed)
+	}
+	if got := metricstest.Value(t, errorSeries); got != apiErrors+1 {
+		t.Errorf("Expected the 403 to be counted, got %v (was %v)", got, apiErrors)
+	}
+}
+
+// fakeChecker stands in for the background checker controlled by admin commands
+type fakeChecker struct {
+	mu     sync.Mutex
+	paused bool
+	checks int
+}
+
+func (f *fakeChecker) CheckNow(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checks++
+	return nil
+}
+
+func (f *fakeChecker) Pause() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.paused {
+		return false
+	}
+	f.paused = true
+	return true
+}
+
+func (f *fakeChecker) Resume() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.paused {
+		return false
+	}
+	f.paused = false
+	return true
+}
+
+func (f *fakeChecker) Paused() (bool, time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.paused, time.Now().Add(-time.Hour)
+}
+
+func (f *fakeChecker) LastCycle() time.Time { return time.Now().Add(-time.Minute) }
+
+func (f *fakeChecker) LastCycleDuration() time.Duration { return 1500 * time.Millisecond }
+
+func (f *fakeChecker) QueueDepth() int { return 0 }
+
+// sendAs processes a command sent by a user in their private chat, whose ID is the user's
+func sendAs(t *testing.T, processor *telegram.MessageProcessor, chatID int64, text string) {
+	t.Helper()
+	update := telegram.Update{ID: 1, Message: telegram.Message{From: &telegram.User{ID: chatID}, Chat: telegram.Chat{ID: chatID}, Text: text}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate(%q) failed: %v", text, err)
+	}
+}
+
+func TestAdminCommandsNeedAdminChat(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789, not-a-chat")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+	checker := &fakeChecker{}
+	processor.SetChecker(checker)
+
+	// Other chats are told the command doesn't exist, and it doesn't run
+	sendAs(t, processor, 456, "/pause")
+	if text := nextReply(t, requests); text != "Unknown command. Type /help for available commands." {
+		t.Errorf("Expected an admin command to look unknown, got: %s", text)
+	}
+	if paused, _ := checker.Paused(); paused {
+		t.Error("Expected the checker not to be paused by a non-admin")
+	}
+
+	// Admin commands are only listed for admins
+	sendAs(t, processor, 457, "/help")
+	if text := nextReply(t, requests); strings.Contains(text, "/stats") || strings.Contains(text, "Admin commands") {
+		t.Errorf("Expected no admin commands in the help, got: %s", text)
+	}
+	sendAs(t, processor, 789, "/help")
+	text := nextReply(t, requests)
+	for _, want := range []string{"Admin commands:", "/broadcast message - Send a message to every user", "/user telegram_id"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected the admin help to contain %q, got: %s", want, text)
+		}
+	}
+
+	// Nor are they published in Telegram's command menu
+	router := telegram.NewRouter()
+	router.Handle(telegram.Command{Name: "list"})
+	router.Handle(telegram.Command{Name: "stats", Admin: true})
+	if commands := router.Commands(); len(commands) != 1 || commands[0].Name != "list" {
+		t.Errorf("Expected only /list to be listed, got %+v", commands)
+	}
+	if commands := router.AdminCommands(); len(commands) != 1 || commands[0].Name != "stats" {
+		t.Errorf("Expected /stats among the admin commands, got %+v", commands)
+	}
+}
+
+func TestAdminCommandsCheckSender(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789,-1001")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+	checker := &fakeChecker{}
+	processor.SetChecker(checker)
+
+	// A group listed as admin doesn't make its members admins, and they aren't stored as users
+	update := telegram.Update{ID: 1, Message: telegram.Message{From: &telegram.User{ID: 456}, Chat: telegram.Chat{ID: -1001, Type: "group"}, Text: "/pause"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextReply(t, requests); !strings.HasPrefix(text, "Unknown command") {
+		t.Errorf("Expected the command to be denied, got: %s", text)
+	}
+	if users, _ := db.GetAllUsers(); len(users) != 0 {
+		t.Errorf("Expected a denied command not to create a user, got %+v", users)
+	}
+
+	// An admin can use them in any chat
+	update = telegram.Update{ID: 2, Message: telegram.Message{From: &telegram.User{ID: 789}, Chat: telegram.Chat{ID: -2002, Type: "group"}, Text: "/pause"}}
+	if err := processor.ProcessUpdate(update); err != nil {
+		t.Fatalf("ProcessUpdate failed: %v", err)
+	}
+	if text := nextReply(t, requests); !strings.HasPrefix(text, "Checker paused.") {
+		t.Errorf("Expected the admin to pause the checker, got: %s", text)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	source := ndparser.NewMeasured(ndparser.NewFake(ndparser.Class{CRN: "12345", Title: "Test Class"}), "fake")
+	processor := telegram.NewMessageProcessor(&client, db, source, logger.New(false))
+	processor.SetChecker(&fakeChecker{paused: true})
+
+	// Two users share a section, one of them from before terms were stored, and a third tracks nothing
+	alice, _ := db.CreateUser(100, "")
+	bob, _ := db.CreateUser(200, "")
+	db.CreateUser(300, "")
+	db.AddTrackedCRN(alice.ID, "12345", ndparser.FakeTerm.Code, "Test Class")
+	db.AddTrackedCRN(alice.ID, "67890", ndparser.FakeTerm.Code, "Other Class")
+	db.AddTrackedCRN(bob.ID, "12345", "", "Test Class")
+	source.SearchClass(context.Background(), "202510", "12345")
+
+	sendAs(t, processor, 789, "/stats")
+	text := nextReply(t, requests)
+	for _, want := range []string{
+		"Users: 4 (2 tracking classes)", // The admin became a user by sending the command
+		"Tracked CRNs: 3",
+		"Distinct CRNs: 2",
+		"Checker: paused since",
+		"Last cycle: took 1.5s, finished 1m0s ago",
+		"Search errors: 0% of the last 1 searches",
+		"Messages:",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected stats to contain %q, got: %s", want, text)
+		}
+	}
+}
+
+func TestAdminUserLookup(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789,790,791")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	user, _ := db.CreateUser(456, "student")
+	tracked, _ := db.AddTrackedCRN(user.ID, "12345", ndparser.FakeTerm.Code, "Test Class")
+	db.SnoozeTrackedCRN(user.ID, tracked.ID, time.Now().Add(time.Hour).Unix())
+
+	// Each admin chat gets one reply, so the per-chat rate limit doesn't slow the test down
+	sendAs(t, processor, 789, "/user 456")
+	text := nextReply(t, requests)
+	for _, want := range []string{"User 456", "Username: @student", "Tracking 1 class(es)", "- 12345 (Test Class, Fall Semester 2025), snoozed until"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected the lookup to contain %q, got: %s", want, text)
+		}
+	}
+
+	sendAs(t, processor, 790, "/user 999")
+	if text := nextReply(t, requests); text != "No user with Telegram ID 999." {
+		t.Errorf("Expected an unknown user to be reported, got: %s", text)
+	}
+
+	sendAs(t, processor, 791, "/user bob")
+	if text := nextReply(t, requests); !strings.Contains(text, "Invalid Telegram ID") {
+		t.Errorf("Expected an invalid ID to be rejected, got: %s", text)
+	}
+}
+
+func TestAdminCheckerControl(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789,790,791,792")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	processor := newTestProcessor(t, &client, ndparser.NewFake())
+
+	// Without a checker there's nothing to control
+	sendAs(t, processor, 789, "/pause")
+	if text := nextReply(t, requests); text != "The checker isn't running." {
+		t.Errorf("Expected to be told there's no checker, got: %s", text)
+	}
+
+	checker := &fakeChecker{}
+	processor.SetChecker(checker)
+
+	sendAs(t, processor, 790, "/pause")
+	if text := nextReply(t, requests); !strings.HasPrefix(text, "Checker paused.") {
+		t.Errorf("Expected the checker to be paused, got: %s", text)
+	}
+	sendAs(t, processor, 791, "/pause")
+	if text := nextReply(t, requests); text != "The checker is already paused." {
+		t.Errorf("Expected a second pause to be refused, got: %s", text)
+	}
+	sendAs(t, processor, 792, "/resume")
+	if text := nextReply(t, requests); !strings.HasPrefix(text, "Checker resumed") {
+		t.Errorf("Expected the checker to be resumed, got: %s", text)
+	}
+	if paused, _ := checker.Paused(); paused {
+		t.Error("Expected the checker to be running again")
+	}
+
+	// Forced checks acknowledge the command, then report when they're done
+	sendAs(t, processor, 456, "/forcecheck")
+	nextReply(t, requests) // Unknown command
+	sendAs(t, processor, 789, "/forcecheck")
+	if text := nextReply(t, requests); text != "Checking all tracked classes..." {
+		t.Errorf("Expected an acknowledgement, got: %s", text)
+	}
+	if text := nextReply(t, requests); !strings.HasPrefix(text, "Check finished in") {
+		t.Errorf("Expected the check to be reported, got: %s", text)
+	}
+	checker.mu.Lock()
+	if checker.checks != 1 {
+		t.Errorf("Expected 1 forced check, got %d", checker.checks)
+	}
+	checker.mu.Unlock()
+}
+
+func TestAdminBroadcast(t *testing.T) {
+	t.Setenv("ADMIN_CHAT_IDS", "789")
+
+	requests := make(chan botRequest, 10)
+	server := newRecordingServer(requests)
+	defer server.Close()
+
+	client := createTestClient(server.URL)
+	db := newTestDatabase(t)
+	processor := telegram.NewMessageProcessor(&client, db, ndparser.NewFake(), logger.New(false))
+
+	db.CreateUser(100, "")
+	db.CreateUser(200, "")
+
+	sendAs(t, processor, 789, "/broadcast Registration opens\ntomorrow at 7am")
+
+	// The admin gets a progress note, everyone including the admin gets the message, then the admin gets the result
+	received := make(map[string]string)
+	var replies []string
+	for i := 0; i < 5; i++ {
+		req := nextRequest(t, requests)
+		chat, text := req.query.Get("chat_id"), req.query.Get("text")
+		if text == "Registration opens\ntomorrow at 7am" {
+			received[chat] = text
+		} else {
+			replies = append(replies, text)
+		}
+	}
+
+	if len(received) != 3 || received["100"] == "" || received["200"] == "" || received["789"] == "" {
+		t.Errorf("Expected every user to get the message, got: %v", received)
+	}
+	if len(replies) != 2 || replies[0] != "Sending the message to 3 user(s)..." || replies[1] != "Broadcast delivered to 3 of 3 user(s)." {
+		t.Errorf("Unexpected replies to the admin: %q", replies)
+	}
+}
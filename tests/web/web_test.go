@@ -1,8 +1,10 @@
 package web_test
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -75,3 +77,84 @@ func TestSendMessage(t *testing.T) {
 		t.Fatalf("SendMessage failed: %v", err)
 	}
 }
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Expected GET request, got %s", r.Method)
+		}
+		if r.URL.Query().Get("q") != "value" {
+			t.Errorf("Expected q 'value', got '%s'", r.URL.Query().Get("q"))
+		}
+		w.Write([]byte("test content"))
+	}))
+	defer server.Close()
+
+	client := web.New()
+
+	data, err := client.Get(context.Background(), server.URL, url.Values{"q": {"value"}})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if string(data) != "test content" {
+		t.Errorf("Expected content 'test content', got '%s'", string(data))
+	}
+}
+
+func TestGetErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := web.New()
+
+	if _, err := client.Get(context.Background(), server.URL, nil); err == nil {
+		t.Error("Expected error for 500 response, but got none")
+	}
+}
+
+func TestPostFormKeepsCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			if r.Method != "POST" {
+				t.Errorf("Expected POST request, got %s", r.Method)
+			}
+			if r.FormValue("user") != "test" {
+				t.Errorf("Expected user 'test', got '%s'", r.FormValue("user"))
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		case "/data":
+			cookie, err := r.Cookie("session")
+			if err != nil || cookie.Value != "abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("secret"))
+		}
+	}))
+	defer server.Close()
+
+	client := web.New()
+
+	if _, err := client.PostForm(context.Background(), server.URL+"/login", url.Values{"user": {"test"}}); err != nil {
+		t.Fatalf("PostForm failed: %v", err)
+	}
+
+	data, err := client.Get(context.Background(), server.URL+"/data", nil)
+	if err != nil {
+		t.Fatalf("Get with session cookie failed: %v", err)
+	}
+
+	if string(data) != "secret" {
+		t.Errorf("Expected content 'secret', got '%s'", string(data))
+	}
+
+	// After resetting the session the cookie must be gone
+	client.ResetSession()
+	if _, err := client.Get(context.Background(), server.URL+"/data", nil); err == nil {
+		t.Error("Expected error after session reset, but got none")
+	}
+}